@@ -0,0 +1,154 @@
+package amqptest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func dial(t *testing.T, s *Server) (*amqp.Connection, *amqp.Channel) {
+	t.Helper()
+
+	conn, err := amqp.Dial(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, ch
+}
+
+func TestServerRecordsDeclarations(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	_, ch := dial(t, s)
+	if err := ch.ExchangeDeclare("logs_topic", "topic", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	q, err := ch.QueueDeclare("", false, false, true, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.QueueBind(q.Name, "log.*", "logs_topic", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.Consume(q.Name, "tag", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.Exchanges(); !reflect.DeepEqual(got, []string{"logs_topic"}) {
+		t.Errorf("exchanges = %v", got)
+	}
+	if got := s.Bindings(); !reflect.DeepEqual(got, []Binding{{Queue: q.Name, Exchange: "logs_topic", RoutingKey: "log.*"}}) {
+		t.Errorf("bindings = %v", got)
+	}
+	if got := s.Consumers(); !reflect.DeepEqual(got, []string{q.Name}) {
+		t.Errorf("consumers = %v", got)
+	}
+
+	if err := ch.Cancel("tag", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Consumers(); len(got) != 0 {
+		t.Errorf("consumers after cancelling = %v", got)
+	}
+}
+
+func TestServerConfirmsAndReturns(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	_, ch := dial(t, s)
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+	ch.QueueDeclare("bound", false, false, false, false, nil)
+	ch.QueueBind("bound", "log.#", "logs_topic", false, nil)
+
+	publish := func(routingKey string) (bool, error) {
+		confirm, err := ch.PublishWithDeferredConfirmWithContext(context.Background(), "logs_topic", routingKey, true, false,
+			amqp.Publishing{MessageId: routingKey, Body: []byte("hi")})
+		if err != nil {
+			return false, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return confirm.WaitContext(ctx)
+	}
+
+	if acked, err := publish("log.INFO.auth"); !acked || err != nil {
+		t.Fatalf("got %t, %v for a routed event, want an ack", acked, err)
+	}
+	select {
+	case ret := <-returns:
+		t.Fatalf("a routed event was returned: %+v", ret)
+	default:
+	}
+
+	if acked, err := publish("mail.INFO"); !acked || err != nil {
+		t.Fatalf("got %t, %v for an unroutable event, want an ack", acked, err)
+	}
+	select {
+	case ret := <-returns:
+		if ret.MessageId != "mail.INFO" || string(ret.Body) != "hi" {
+			t.Fatalf("returned %+v", ret)
+		}
+	default:
+		t.Fatal("an unroutable event wasnt returned before its ack")
+	}
+
+	s.SetConfirm(Nack)
+	if acked, err := publish("log.INFO"); acked || err != nil {
+		t.Fatalf("got %t, %v, want a nack", acked, err)
+	}
+
+	s.SetConfirm(NoConfirm)
+	if _, err := publish("log.INFO"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if got := len(s.Published()); got != 4 {
+		t.Fatalf("%d events published, want 4", got)
+	}
+}
+
+func TestServerStop(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	conn, ch := dial(t, s)
+	ch.ExchangeDeclare("logs_topic", "topic", true, false, false, false, nil)
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	s.Stop()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the connection didnt notice the server stopping")
+	}
+	if _, err := amqp.Dial(s.URL); err == nil {
+		t.Fatal("dialled a stopped server")
+	}
+
+	s.Start()
+
+	dial(t, s)
+	if got := s.Exchanges(); len(got) != 0 {
+		t.Fatalf("the server remembered %v after it restarted", got)
+	}
+	if got := s.Dials(); got != 2 {
+		t.Fatalf("%d dials, want 2", got)
+	}
+}
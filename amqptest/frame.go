@@ -0,0 +1,155 @@
+package amqptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// frame types
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+// the protocol header every client starts with, for amqp 0-9-1
+var protocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		typ:     header[0],
+		channel: binary.BigEndian.Uint16(header[1:3]),
+		payload: make([]byte, binary.BigEndian.Uint32(header[3:7])),
+	}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+
+	end, err := r.ReadByte()
+	if err != nil {
+		return frame{}, err
+	}
+	if end != frameEnd {
+		return frame{}, fmt.Errorf("frame ends with %#x instead of %#x", end, frameEnd)
+	}
+
+	return f, nil
+}
+
+func writeFrame(w io.Writer, typ byte, channel uint16, payload []byte) error {
+	buf := make([]byte, 0, len(payload)+8)
+	buf = append(buf, typ)
+	buf = binary.BigEndian.AppendUint16(buf, channel)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = append(buf, payload...)
+	buf = append(buf, frameEnd)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// the arguments of a method, built up field by field
+type args struct {
+	bytes.Buffer
+}
+
+func method(class, id uint16) *args {
+	a := &args{}
+	a.short(class)
+	a.short(id)
+	return a
+}
+
+func (a *args) octet(v byte) *args {
+	a.WriteByte(v)
+	return a
+}
+
+func (a *args) short(v uint16) *args {
+	binary.Write(a, binary.BigEndian, v)
+	return a
+}
+
+func (a *args) long(v uint32) *args {
+	binary.Write(a, binary.BigEndian, v)
+	return a
+}
+
+func (a *args) longlong(v uint64) *args {
+	binary.Write(a, binary.BigEndian, v)
+	return a
+}
+
+func (a *args) shortstr(s string) *args {
+	a.WriteByte(byte(len(s)))
+	a.WriteString(s)
+	return a
+}
+
+func (a *args) longstr(s string) *args {
+	a.long(uint32(len(s)))
+	a.WriteString(s)
+	return a
+}
+
+// an empty field table
+func (a *args) table() *args {
+	return a.long(0)
+}
+
+var errShort = errors.New("method arguments end too soon")
+
+// reads the arguments of a method a client sent. only the fields the server looks at are read,
+// so anything after them (such as a table of arguments) is never parsed
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		r.err = errShort
+		return make([]byte, n)
+	}
+
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) octet() byte {
+	return r.take(1)[0]
+}
+
+func (r *reader) short() uint16 {
+	return binary.BigEndian.Uint16(r.take(2))
+}
+
+func (r *reader) long() uint32 {
+	return binary.BigEndian.Uint32(r.take(4))
+}
+
+func (r *reader) longlong() uint64 {
+	return binary.BigEndian.Uint64(r.take(8))
+}
+
+func (r *reader) shortstr() string {
+	return string(r.take(int(r.octet())))
+}
@@ -0,0 +1,5 @@
+module github.com/jateen67/amqptest
+
+go 1.20
+
+require github.com/rabbitmq/amqp091-go v1.8.1
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/jateen67/authentication/data"
 )
 
 // method that will be called when we send a post request to "localhost:80/authenticate" (will be mapped to 8080 through docker)
@@ -24,8 +27,9 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// if the json is in the correct format and can be decoded, we now want to validate the email against the db
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	user, err := app.Models.User.GetByEmail(r.Context(), requestPayload.Email)
 	if err != nil {
+		recordAuthAttempt(false)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
@@ -33,28 +37,98 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	// now we validate the password
 	validPass, err := user.PasswordMatches(requestPayload.Password)
 	if err != nil || !validPass {
+		recordAuthAttempt(false)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
 
+	recordAuthAttempt(true)
+
 	// log authentication to logger-service
-	err = app.logRequest("Authentication Event", fmt.Sprintf("%s logged in", user.Email))
+	// the broker's request id is passed along so the log entry can be matched up with the login
+	err = app.logRequest(r.Context(), r.Header.Get(requestIDHeader), "Authentication Event", fmt.Sprintf("%s logged in", user.Email))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	// hand out the tokens the user will send along with every other request to the broker
+	tokens, err := app.Tokens.issue(user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("logged in user %s", user.Email),
-		Data:    user,
+		Data: authResponse{
+			User:      user,
+			tokenPair: tokens,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// the user's fields, with the tokens added next to them
+type authResponse struct {
+	*data.User
+	tokenPair
+}
+
+// method that will be called when we send a post request to "localhost:80/refresh" (will be mapped to 8080 through docker)
+// trades a refresh token for a new pair of tokens
+func (app *Config) Refresh(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := app.Tokens.verifyRefresh(requestPayload.RefreshToken)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	// make sure the user still exists and hasnt been deactivated since the token was issued
+	user, err := app.Models.User.GetOne(r.Context(), userID)
+	if err != nil || user.Active != 1 {
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := app.Tokens.issue(user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("refreshed tokens for user %s", user.Email),
+		Data:    tokens,
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
+// method that will be called when we send a get request to "localhost:80/.well-known/jwks.json"
+// publishes the public keys that our tokens can be verified with
+func (app *Config) JWKS(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, app.Tokens.jwks())
+}
+
+// header the broker sends the id of the original request in
+const requestIDHeader = "X-Request-ID"
+
 // helper function that logs to the logger-service anytime we try to authenticate
-func (app *Config) logRequest(name, data string) error {
+func (app *Config) logRequest(ctx context.Context, requestID, name, data string) error {
 	var entry struct {
 		Name string `json:"name"`
 		Data string `json:"data"`
@@ -67,17 +141,21 @@ func (app *Config) logRequest(name, data string) error {
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 
 	// setup the request to the logger service
-	req, err := http.NewRequest("POST", "http://logger-service/log", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+loggerService+"/log", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
 
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+
 	// we will actually send the request now and get the response from the auth service
-	client := &http.Client{}
-	_, err = client.Do(req)
+	res, err := app.Logger.Do(req)
 	if err != nil {
 		return err
 	}
+	res.Body.Close()
 
 	return nil
 }
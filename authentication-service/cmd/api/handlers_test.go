@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jateen67/authentication/data"
+)
+
+// a database/sql driver standing in for postgres, answering every query with the user whose id it is given
+type fakeUsers map[int64]data.User
+
+func (u fakeUsers) Open(name string) (driver.Conn, error) {
+	return fakeConn{u}, nil
+}
+
+type fakeConn struct {
+	users fakeUsers
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements arent supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions arent supported")
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	id, _ := args[0].Value.(int64)
+	user, ok := c.users[id]
+	if !ok {
+		return &fakeRows{}, nil
+	}
+
+	return &fakeRows{row: []driver.Value{
+		int64(user.ID), user.Email, user.FirstName, user.LastName, user.Password, int64(user.Active), user.CreatedAt, user.UpdatedAt,
+	}}, nil
+}
+
+type fakeRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "email", "first_name", "last_name", "password", "user_active", "created_at", "updated_at"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.row == nil || r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+
+	return nil
+}
+
+func init() {
+	sql.Register("fakeusers", fakeUsers{
+		1: {ID: 1, Email: "admin@example.com", Active: 1},
+		2: {ID: 2, Email: "gone@example.com", Active: 0},
+	})
+}
+
+func newTestApp(t *testing.T) *Config {
+	t.Helper()
+
+	db, err := sql.Open("fakeusers", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	t.Setenv("JWT_PRIVATE_KEY_FILE", "")
+	tokens, err := newTokenSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Config{DB: db, Models: data.New(db), Tokens: tokens}
+}
+
+func TestRefresh(t *testing.T) {
+	app := newTestApp(t)
+	other := newTestApp(t)
+
+	admin := &data.User{ID: 1, Email: "admin@example.com"}
+	sign := func(s *tokenSigner, user *data.User, tokenType string, ttl time.Duration) string {
+		token, err := s.sign(user, tokenType, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "refresh token", body: sign(app.Tokens, admin, tokenTypeRefresh, time.Hour), wantStatus: http.StatusAccepted},
+		{name: "access token", body: sign(app.Tokens, admin, tokenTypeAccess, time.Hour), wantStatus: http.StatusUnauthorized},
+		{name: "expired", body: sign(app.Tokens, admin, tokenTypeRefresh, -time.Minute), wantStatus: http.StatusUnauthorized},
+		{name: "signed with another key", body: sign(other.Tokens, admin, tokenTypeRefresh, time.Hour), wantStatus: http.StatusUnauthorized},
+		{name: "deactivated user", body: sign(app.Tokens, &data.User{ID: 2}, tokenTypeRefresh, time.Hour), wantStatus: http.StatusUnauthorized},
+		{name: "deleted user", body: sign(app.Tokens, &data.User{ID: 3}, tokenTypeRefresh, time.Hour), wantStatus: http.StatusUnauthorized},
+		{name: "not a token", body: "nope", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"refresh_token": tt.body})
+			r := httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+
+			app.Refresh(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusAccepted {
+				return
+			}
+
+			// the new refresh token can be traded again, and the access token cant
+			var res struct {
+				Data tokenPair `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if id, err := app.Tokens.verifyRefresh(res.Data.RefreshToken); err != nil || id != 1 {
+				t.Errorf("new refresh token: %d, %v", id, err)
+			}
+			if _, err := app.Tokens.verifyRefresh(res.Data.AccessToken); err == nil {
+				t.Errorf("the new access token was taken as a refresh token")
+			}
+		})
+	}
+}
+
+func TestRefreshBadJSON(t *testing.T) {
+	app := newTestApp(t)
+
+	w := httptest.NewRecorder()
+	app.Refresh(w, httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewBufferString("{")))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/jateen67/authentication/data"
+	"github.com/jateen67/registry"
+	"github.com/jateen67/tracing"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -17,24 +22,63 @@ import (
 
 const port = "80"
 
+// name our spans are reported under
+const serviceName = "authentication-service"
+
 var count int64
 
 type Config struct {
 	DB     *sql.DB
 	Models data.Models
+	Tokens *tokenSigner
+	// client used to send logs to the logger service, spread across its instances
+	Logger *http.Client
 }
 
+// name of the logger service in the registry
+const loggerService = "logger-service"
+
 func main() {
+	// stop on ctrl+c, or on the SIGTERM docker sends when the container is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// set up tracing before anything else, so that every span is exported
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// connect to db using our helper function defined below
 	conn := connectToDB()
 	if conn == nil {
 		log.Panic("cant connect to postgres")
 	}
+	defer conn.Close()
+
+	// load the key we sign access and refresh tokens with
+	tokens, err := newTokenSigner()
+	if err != nil {
+		log.Panic(err)
+	}
 
 	// set up some configuration from models.go
+	// find the instances of the logger service, so that our logs are spread across all of them
+	registryConfig, err := registry.LoadConfig(loggerService)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	services := registry.New(registryConfig, loggerService)
+	defer services.Close()
+
 	app := Config{
 		DB:     conn,
 		Models: data.New(conn),
+		Tokens: tokens,
+		// the traced transport passes our trace on to the logger service
+		Logger: &http.Client{Transport: tracing.Transport(&registry.Transport{Registry: services})},
 	}
 
 	log.Printf("starting auth service on port %s\n", port)
@@ -45,11 +89,8 @@ func main() {
 		Handler: app.routes(),
 	}
 
-	// start server
-	err := srv.ListenAndServe()
-	if err != nil {
-		log.Panic(err)
-	}
+	// start server, and run it until we are told to stop. the deferred calls above then close our connections
+	serve(ctx, srv)
 }
 
 func openDB(dsn string) (*sql.DB, error) {
@@ -7,11 +7,16 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/jateen67/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
+	// start a span for every request, continuing the caller's trace if it sent one
+	mux.Use(tracing.Middleware(serviceName))
+
 	// specify who is able to connect
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
@@ -25,10 +30,22 @@ func (app *Config) routes() http.Handler {
 	// easily make sure that the service is running by hitting the endpoint to get a response
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	// count and time every request (see metrics.go)
+	mux.Use(app.recordMetrics)
+
+	// prometheus metrics
+	mux.Handle("/metrics", promhttp.Handler())
+
 	// add routes that use handlers, which will be called when we access these routes
 	// post request to localhost:80/authenticate will run the Authenticate method (will be mapped to 8081 through docker)
 	mux.Post("/authenticate", app.Authenticate)
 
+	// trade a refresh token for a new pair of tokens
+	mux.Post("/refresh", app.Refresh)
+
+	// public keys that the other services verify our tokens with
+	mux.Get("/.well-known/jwks.json", app.JWKS)
+
 	return mux
 
 }
@@ -0,0 +1,257 @@
+// signed access and refresh tokens handed out after a successful login
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jateen67/authentication/data"
+)
+
+const (
+	// who signs the tokens, and who they are meant for
+	tokenIssuer   = "authentication-service"
+	tokenAudience = "broker-service"
+
+	// the two kinds of token we hand out, stored in the "typ" claim
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// the claims carried by both kinds of token
+type tokenClaims struct {
+	Email string `json:"email"`
+	Type  string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// what a client gets back after logging in or refreshing its tokens
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// signs tokens with an rsa key, and publishes the public half of it so other services can verify them
+type tokenSigner struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+// load the signing key from the pem file named by JWT_PRIVATE_KEY_FILE, creating it if it doesnt exist yet,
+// so that every replica signs with the same key and tokens outlive a restart.
+// without one we generate a key for this run only, which means tokens stop working when the service restarts
+func newTokenSigner() (*tokenSigner, error) {
+	var key *rsa.PrivateKey
+	var err error
+
+	if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+		key, err = loadOrCreatePrivateKey(path)
+	} else {
+		log.Println("JWT_PRIVATE_KEY_FILE not set, generating a signing key for this run")
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the key id is a fingerprint of the public key, so it changes whenever the key does
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &tokenSigner{
+		key: key,
+		kid: base64.RawURLEncoding.EncodeToString(sum[:8]),
+	}, nil
+}
+
+// read the private key at path. if there is none, a new one is generated and saved there.
+// replicas starting at the same time may both generate one, but only the first to be linked into place is kept,
+// and the others read that one back, so they all end up with the same key
+func loadOrCreatePrivateKey(path string) (*rsa.PrivateKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err == nil {
+		return parsePrivateKey(pemBytes)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	log.Printf("no signing key at %s, generating one\n", path)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	// write it somewhere else first, so that nobody ever reads half a key
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".jwt-key-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = pem.Encode(tmp, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// link fails if the key is already there, unlike rename
+	err = os.Link(tmp.Name(), path)
+	if errors.Is(err, fs.ErrExist) {
+		return loadOrCreatePrivateKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// accepts both pkcs#1 ("RSA PRIVATE KEY") and pkcs#8 ("PRIVATE KEY") pem files
+func parsePrivateKey(pemBytes []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no pem block found in private key file")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an rsa key")
+	}
+
+	return key, nil
+}
+
+// issue a new access and refresh token for a user
+func (s *tokenSigner) issue(user *data.User) (tokenPair, error) {
+	access, err := s.sign(user, tokenTypeAccess, accessTokenTTL)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	refresh, err := s.sign(user, tokenTypeRefresh, refreshTokenTTL)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	return tokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *tokenSigner) sign(user *data.User, tokenType string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	// random id for every token, so that two tokens issued in the same second still differ
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+
+	claims := tokenClaims{
+		Email: user.Email,
+		Type:  tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{tokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			ID:        base64.RawURLEncoding.EncodeToString(id),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+
+	return token.SignedString(s.key)
+}
+
+// check a refresh token and return the id of the user it was issued to
+func (s *tokenSigner) verifyRefresh(tokenString string) (int, error) {
+	var claims tokenClaims
+
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (any, error) {
+		return &s.key.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(tokenAudience),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if claims.Type != tokenTypeRefresh {
+		return 0, fmt.Errorf("expected a %s token, got %q", tokenTypeRefresh, claims.Type)
+	}
+
+	return strconv.Atoi(claims.Subject)
+}
+
+// a single key of a json web key set (rfc 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// the public keys that tokens can be verified with
+func (s *tokenSigner) jwks() map[string][]jsonWebKey {
+	pub := s.key.PublicKey
+
+	return map[string][]jsonWebKey{
+		"keys": {
+			{
+				Kty: "RSA",
+				Use: "sig",
+				Alg: jwt.SigningMethodRS256.Alg(),
+				Kid: s.kid,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	}
+}
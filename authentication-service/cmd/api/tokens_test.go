@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSignerKeyIsSharedThroughTheKeyFile(t *testing.T) {
+	t.Setenv("JWT_PRIVATE_KEY_FILE", filepath.Join(t.TempDir(), "jwt.pem"))
+
+	// replicas starting at the same time all end up with the one key
+	const replicas = 4
+	signers := make([]*tokenSigner, replicas)
+	var wg sync.WaitGroup
+	for i := range signers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var err error
+			signers[i], err = newTokenSigner()
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	for _, s := range signers[1:] {
+		if s.kid != signers[0].kid {
+			t.Fatalf("replicas sign with different keys: %q and %q", signers[0].kid, s.kid)
+		}
+	}
+
+	// and so does one started later, e.g. after a restart
+	restarted, err := newTokenSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.kid != signers[0].kid {
+		t.Fatalf("the key changed across a restart: %q, then %q", signers[0].kid, restarted.kid)
+	}
+
+	info, err := os.Stat(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		t.Errorf("the key file can be read by others: %s", info.Mode().Perm())
+	}
+}
+
+func TestSignerWithABrokenKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_PRIVATE_KEY_FILE", path)
+
+	if _, err := newTokenSigner(); err == nil {
+		t.Fatal("expected an error for a key file that isnt a key")
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"errors"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -13,6 +16,9 @@ const dbTimeout = time.Second * 3
 
 var db *sql.DB
 
+// tracer used for the spans around our database queries
+var tracer = otel.Tracer("github.com/jateen67/authentication/data")
+
 // New is the function used to create an instance of the data package. It returns the type
 // Model, which embeds all the types we want to be available to our application.
 func New(dbPool *sql.DB) Models {
@@ -43,12 +49,15 @@ type User struct {
 }
 
 // GetByEmail returns one user by email
-func (u *User) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
 
+	ctx, span := startQuerySpan(ctx, "select users by email", query)
+	defer span.End()
+
 	var user User
 	row := db.QueryRowContext(ctx, query, email)
 
@@ -70,6 +79,50 @@ func (u *User) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetOne returns one user by id
+func (u *User) GetOne(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+
+	ctx, span := startQuerySpan(ctx, "select users by id", query)
+	defer span.End()
+
+	var user User
+	row := db.QueryRowContext(ctx, query, id)
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Active,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// startQuerySpan starts a client span for a query against the users database.
+// the span's context has to be passed to the query so that its time is counted
+func startQuerySpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "postgres "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.name", "users"),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
 // PasswordMatches uses Go's bcrypt package to compare a user supplied password
 // with the hash we have stored for a given user in the database. If the password
 // and hash match, we return true; otherwise, we return false.
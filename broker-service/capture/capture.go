@@ -0,0 +1,112 @@
+// package capture records requests sent to the broker, and the responses it gave, as one json object per line
+// (ndjson), with secrets such as passwords redacted. the replay command sends them to a broker again
+// and compares the responses it gets with the recorded ones
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// a request the broker handled, and the response it sent back
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	// the path and query, e.g. "/v2/handle?async=true"
+	URI string `json:"uri"`
+	// request headers that change what the broker does, such as Idempotency-Key. never the Authorization header
+	Header map[string]string `json:"header,omitempty"`
+	// the action named in the request, if it had one
+	Action string `json:"action,omitempty"`
+	// the request's body, with its secrets redacted (see Redact)
+	Request json.RawMessage `json:"request"`
+	// whether anything was redacted from the request, in which case sending it again wont do the same thing
+	Redacted bool            `json:"redacted,omitempty"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+	// how long the broker took to answer, in milliseconds
+	Duration float64 `json:"duration_ms"`
+}
+
+type Options struct {
+	// the fraction of requests to record, between 0 and 1
+	Sample float64
+	// stop recording once this many bytes have been written, so that a forgotten capture doesnt fill the disk.
+	// 0 means no limit
+	MaxBytes int64
+}
+
+// Recorder writes records to w, one per line. it is safe to use from many requests at once
+type Recorder struct {
+	opts Options
+
+	mu      sync.Mutex
+	w       io.Writer
+	written int64
+	full    bool
+}
+
+func NewRecorder(w io.Writer, opts Options) *Recorder {
+	return &Recorder{w: w, opts: opts}
+}
+
+// Sample reports whether the next request should be recorded
+func (rec *Recorder) Sample() bool {
+	if rec.opts.Sample <= 0 {
+		return false
+	}
+
+	return rec.opts.Sample >= 1 || rand.Float64() < rec.opts.Sample
+}
+
+// Write adds a record on a line of its own
+func (rec *Recorder) Write(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.full {
+		return nil
+	}
+	if rec.opts.MaxBytes > 0 && rec.written+int64(len(line)) > rec.opts.MaxBytes {
+		log.Printf("capture has reached %d bytes, no more requests will be recorded\n", rec.written)
+		rec.full = true
+		return nil
+	}
+
+	n, err := rec.w.Write(line)
+	rec.written += int64(n)
+
+	return err
+}
+
+// Reader reads records back, in the order they were written
+type Reader struct {
+	dec *json.Decoder
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next record, or io.EOF once there are none left
+func (r *Reader) Next() (Record, error) {
+	var rec Record
+	err := r.dec.Decode(&rec)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Record{}, err
+	}
+
+	return rec, err
+}
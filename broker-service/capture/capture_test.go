@@ -0,0 +1,160 @@
+package capture
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		want         string
+		wantRedacted bool
+	}{
+		{
+			name: "nothing secret",
+			body: `{"action": "log", "log": {"name": "event", "data": "hi"}}`,
+			want: `{"action":"log","log":{"data":"hi","name":"event"}}`,
+		},
+		{
+			name:         "nested password",
+			body:         `{"action": "auth", "auth": {"email": "a@b.com", "password": "verysecret"}}`,
+			want:         `{"action":"auth","auth":{"email":"a@b.com","password":"[REDACTED]"}}`,
+			wantRedacted: true,
+		},
+		{
+			name:         "keys are matched by any part, whatever their case",
+			body:         `{"Access_Token": "abc", "refresh_token": "def", "client_secret": "ghi", "X-API-Key": "jkl", "apikey": "mno"}`,
+			want:         `{"Access_Token":"[REDACTED]","X-API-Key":"[REDACTED]","apikey":"[REDACTED]","client_secret":"[REDACTED]","refresh_token":"[REDACTED]"}`,
+			wantRedacted: true,
+		},
+		{
+			name:         "secrets that arent strings",
+			body:         `{"tokens": {"access": "abc", "refresh": "def"}}`,
+			want:         `{"tokens":"[REDACTED]"}`,
+			wantRedacted: true,
+		},
+		{
+			name:         "inside arrays",
+			body:         `[{"password": "a"}, {"name": "b"}]`,
+			want:         `[{"password":"[REDACTED]"},{"name":"b"}]`,
+			wantRedacted: true,
+		},
+		{
+			name: "big numbers are kept as they are",
+			body: `{"id": 12345678901234567890}`,
+			want: `{"id":12345678901234567890}`,
+		},
+		{
+			name: "empty",
+			body: "  ",
+			want: `null`,
+		},
+		{
+			name:         "not json",
+			body:         `password=verysecret`,
+			want:         `"[REDACTED] (not json)"`,
+			wantRedacted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, redacted := Redact([]byte(tt.body))
+
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if redacted != tt.wantRedacted {
+				t.Errorf("redacted = %t, want %t", redacted, tt.wantRedacted)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ignore := map[string]bool{"id": true, "created_at": true}
+
+	tests := []struct {
+		name      string
+		want, got string
+		diffs     []string
+	}{
+		{
+			name: "same",
+			want: `{"error": false, "data": {"n": 1}}`,
+			got:  `{"data": {"n": 1}, "error": false}`,
+		},
+		{
+			name:  "changed value",
+			want:  `{"data": {"result": "pushed to logs_topic as log.INFO"}}`,
+			got:   `{"data": {"result": "kept in the outbox as entry 3"}}`,
+			diffs: []string{`data.result: "pushed to logs_topic as log.INFO" != "kept in the outbox as entry 3"`},
+		},
+		{
+			name:  "missing and unexpected keys",
+			want:  `{"a": 1, "b": 2}`,
+			got:   `{"b": 2, "c": 3}`,
+			diffs: []string{"a: missing", "c: unexpected 3"},
+		},
+		{
+			name: "ignored keys",
+			want: `{"id": 1, "data": {"id": 2, "created_at": "yesterday"}}`,
+			got:  `{"id": 7, "data": {"id": 8, "created_at": "today"}}`,
+		},
+		{
+			name:  "arrays of different lengths",
+			want:  `{"items": [1, 2]}`,
+			got:   `{"items": [1]}`,
+			diffs: []string{"items: 2 items != 1 items"},
+		},
+		{
+			name:  "inside arrays",
+			want:  `[{"status": "succeeded"}, {"status": "failed"}]`,
+			got:   `[{"status": "succeeded"}, {"status": "skipped"}]`,
+			diffs: []string{`[1].status: "failed" != "skipped"`},
+		},
+		{
+			name:  "different types",
+			want:  `{"data": {"n": 1}}`,
+			got:   `{"data": [1]}`,
+			diffs: []string{`data: {"n":1} != [1]`},
+		},
+		{
+			name:  "whole response",
+			want:  `1`,
+			got:   `2`,
+			diffs: []string{"(response): 1 != 2"},
+		},
+		{
+			name:  "big numbers",
+			want:  `{"n": 12345678901234567890}`,
+			got:   `{"n": 12345678901234567891}`,
+			diffs: []string{"n: 12345678901234567890 != 12345678901234567891"},
+		},
+		{
+			name:  "recorded response isnt json",
+			want:  `nope`,
+			got:   `{}`,
+			diffs: []string{"recorded response isnt json"},
+		},
+		{
+			name:  "response isnt json",
+			want:  `{}`,
+			got:   `<html>`,
+			diffs: []string{"response isnt json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := Diff(json.RawMessage(tt.want), json.RawMessage(tt.got), ignore)
+
+			if !reflect.DeepEqual(diffs, tt.diffs) {
+				t.Fatalf("got %q, want %q", diffs, tt.diffs)
+			}
+		})
+	}
+}
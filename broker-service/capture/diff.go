@@ -0,0 +1,103 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Diff compares a recorded json response with one received now, and describes every place they differ,
+// e.g. `data.result: "pushed to logs_topic as log.INFO" != "kept in the outbox as entry 3"`.
+// values under the keys in ignore, such as ids and timestamps that change on every request, arent compared
+func Diff(want, got json.RawMessage, ignore map[string]bool) []string {
+	w, err := decode(want)
+	if err != nil {
+		return []string{"recorded response isnt json"}
+	}
+	g, err := decode(got)
+	if err != nil {
+		return []string{"response isnt json"}
+	}
+
+	var diffs []string
+	diffValues("", w, g, ignore, &diffs)
+	return diffs
+}
+
+func diffValues(path string, want, got any, ignore map[string]bool, diffs *[]string) {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(w)+len(g))
+		for key := range w {
+			keys = append(keys, key)
+		}
+		for key := range g {
+			if _, ok := w[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if ignore[key] {
+				continue
+			}
+
+			wv, inWant := w[key]
+			gv, inGot := g[key]
+			switch {
+			case !inGot:
+				*diffs = append(*diffs, fmt.Sprintf("%s: missing", join(path, key)))
+			case !inWant:
+				*diffs = append(*diffs, fmt.Sprintf("%s: unexpected %s", join(path, key), show(gv)))
+			default:
+				diffValues(join(path, key), wv, gv, ignore, diffs)
+			}
+		}
+		return
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			break
+		}
+		if len(w) != len(g) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: %d items != %d items", orRoot(path), len(w), len(g)))
+			return
+		}
+
+		for i := range w {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], ignore, diffs)
+		}
+		return
+	}
+
+	if show(want) != show(got) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: %s != %s", orRoot(path), show(want), show(got)))
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func orRoot(path string) string {
+	if path == "" {
+		return "(response)"
+	}
+
+	return path
+}
+
+func show(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
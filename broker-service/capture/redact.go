@@ -0,0 +1,85 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// what a secret is replaced with
+const Redacted = "[REDACTED]"
+
+// keys whose values are secret wherever they turn up, matched case insensitively against any part of the key,
+// e.g. "password", "access_token", "client_secret" or "X-Api-Key"
+var secretKeys = []string{"password", "token", "secret", "authorization", "api_key", "apikey"}
+
+func secret(key string) bool {
+	key = strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redact replaces the value of every secret key in a json document, however deeply it is nested, and reports
+// whether it found any. a body that isnt json cant be checked, so all of it is replaced
+func Redact(body []byte) (json.RawMessage, bool) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return json.RawMessage("null"), false
+	}
+
+	v, err := decode(body)
+	if err != nil {
+		quoted, _ := json.Marshal(Redacted + " (not json)")
+		return quoted, true
+	}
+
+	v, redacted := redactValue(v)
+	out, err := json.Marshal(v)
+	if err != nil {
+		quoted, _ := json.Marshal(Redacted)
+		return quoted, true
+	}
+
+	return out, redacted
+}
+
+func redactValue(v any) (any, bool) {
+	redacted := false
+
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if secret(key) {
+				v[key] = Redacted
+				redacted = true
+				continue
+			}
+
+			var r bool
+			v[key], r = redactValue(value)
+			redacted = redacted || r
+		}
+	case []any:
+		for i, value := range v {
+			var r bool
+			v[i], r = redactValue(value)
+			redacted = redacted || r
+		}
+	}
+
+	return v, redacted
+}
+
+// decode json without turning big numbers into floats, so that they are written back out the same
+func decode(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
@@ -0,0 +1,293 @@
+// registry of the actions that can be sent to the /handle endpoint
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jateen67/broker/validate"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// an action knows how to decode its own payload and how to run it against a downstream service
+type action struct {
+	Name string
+	// public actions can be called without an access token
+	Public bool
+	// returns a pointer to a fresh, empty payload that the request's json gets decoded into.
+	// the payload is checked against the rules in its `validate` tags before the action runs (see the validate package)
+	NewPayload func() any
+	// runs the action with the decoded payload and returns the response to send back to the client
+	Handle func(ctx context.Context, payload any) (jsonResponse, error)
+}
+
+// holds every action the broker knows about, keyed by the action's name
+type actionRegistry struct {
+	actions map[string]action
+	// when set, every action that isnt public needs the caller's identity in its context (see middleware.go)
+	requireIdentity bool
+	// when set, requests with fields the action's payload doesnt have are turned away
+	strict bool
+}
+
+func newActionRegistry() *actionRegistry {
+	return &actionRegistry{
+		actions: make(map[string]action),
+	}
+}
+
+// registerAction adds an action whose payload is of type T to the registry.
+// the handler receives the payload already decoded, so it never has to deal with raw json
+func registerAction[T any](reg *actionRegistry, name string, handler func(ctx context.Context, payload T) (jsonResponse, error)) {
+	if _, exists := reg.actions[name]; exists {
+		panic(fmt.Sprintf("action %q registered twice", name))
+	}
+
+	reg.actions[name] = action{
+		Name:       name,
+		NewPayload: func() any { return new(T) },
+		Handle: func(ctx context.Context, payload any) (jsonResponse, error) {
+			return handler(ctx, *payload.(*T))
+		},
+	}
+}
+
+// registerPublicAction adds an action that can be called without an access token, such as logging in
+func registerPublicAction[T any](reg *actionRegistry, name string, handler func(ctx context.Context, payload T) (jsonResponse, error)) {
+	registerAction(reg, name, handler)
+
+	a := reg.actions[name]
+	a.Public = true
+	reg.actions[name] = a
+}
+
+// look up an action by its name
+func (reg *actionRegistry) lookup(name string) (action, bool) {
+	a, ok := reg.actions[name]
+	return a, ok
+}
+
+// sorted list of the names of every registered action
+func (reg *actionRegistry) names() []string {
+	names := make([]string, 0, len(reg.actions))
+	for name := range reg.actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// decode the payload of a request and run the matching action
+func (reg *actionRegistry) dispatch(ctx context.Context, req RequestPayload) (jsonResponse, error) {
+	ctx, span := tracer.Start(ctx, "action "+req.Action)
+	defer span.End()
+
+	res, err := reg.run(ctx, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	_, known := reg.lookup(req.Action)
+	recordAction(req.Action, known, err)
+
+	return res, err
+}
+
+func (reg *actionRegistry) run(ctx context.Context, req RequestPayload) (jsonResponse, error) {
+	a, payload, err := reg.prepare(ctx, req)
+	if err != nil {
+		return jsonResponse{}, err
+	}
+
+	return a.Handle(ctx, payload)
+}
+
+// everything that happens before an action runs: check that the caller may run it,
+// then decode its payload and make sure the payload is valid
+func (reg *actionRegistry) prepare(ctx context.Context, req RequestPayload) (action, any, error) {
+	a, err := reg.authorize(ctx, req.Action)
+	if err != nil {
+		return action{}, nil, err
+	}
+
+	var errs validate.Errors
+	if reg.strict {
+		for _, field := range req.unknown {
+			errs = append(errs, validate.Unknown(field))
+		}
+	}
+
+	payload := a.NewPayload()
+	if len(req.Payload) > 0 {
+		field, err := decodePayload(req.Payload, payload, reg.strict)
+		if field != "" {
+			errs = append(errs, validate.Unknown(req.Action+"."+field))
+			// decoding stopped at the unknown field, so decode the rest of it to check that too
+			err = json.Unmarshal(req.Payload, payload)
+		}
+		if err != nil {
+			return action{}, nil, err
+		}
+	}
+
+	// fields are named the way they appear in the request, e.g. "mail.to"
+	if err := validate.Struct(payload); err != nil {
+		for _, fe := range err.(validate.Errors) {
+			fe.Field = req.Action + "." + fe.Field
+			errs = append(errs, fe)
+		}
+	}
+	if len(errs) > 0 {
+		return action{}, nil, withStatus(errs, http.StatusUnprocessableEntity)
+	}
+
+	return a, payload, nil
+}
+
+// decode an action's payload. in strict mode, a field the payload doesnt have is an error,
+// and its name is returned so that it can be reported like any other invalid field
+func decodePayload(raw json.RawMessage, payload any, strict bool) (string, error) {
+	if !strict {
+		return "", json.Unmarshal(raw, payload)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(payload)
+	// encoding/json has no error type for unknown fields, only this message
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return field, err
+	}
+
+	return "", err
+}
+
+// look up an action, and check that the caller is allowed to run it
+func (reg *actionRegistry) authorize(ctx context.Context, name string) (action, error) {
+	a, ok := reg.lookup(name)
+	if !ok {
+		return action{}, &unknownActionError{Action: name, Available: reg.names()}
+	}
+
+	if !a.Public && reg.requireIdentity {
+		if err := requireIdentity(ctx); err != nil {
+			return action{}, err
+		}
+	}
+
+	return a, nil
+}
+
+// every action the broker supports gets registered here
+func (app *Config) registerActions() {
+	app.Actions = newActionRegistry()
+	app.Actions.requireIdentity = app.RequireAuth
+	app.Actions.strict = app.StrictPayloads
+
+	// logging in is the only thing you can do without an access token
+	registerPublicAction(app.Actions, "auth", app.authenticate)
+	registerAction(app.Actions, "log", app.logItemVia)
+	registerAction(app.Actions, "mail", app.sendMail)
+}
+
+// returned when a request names an action that was never registered
+type unknownActionError struct {
+	Action    string   `json:"action"`
+	Available []string `json:"available"`
+}
+
+func (e *unknownActionError) Error() string {
+	return fmt.Sprintf("unknown action %q", e.Action)
+}
+
+// lets an action decide which status code its error is sent back with
+type statusError struct {
+	Status int
+	// error code sent to the client (see problems.go). worked out from the error and status if it is empty
+	Code string
+	Err  error
+}
+
+func (e *statusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.Err
+}
+
+// wrap an error so that it is reported with the given status code
+func withStatus(err error, status int) error {
+	return &statusError{Status: status, Err: err}
+}
+
+// the status code an action's error is reported with: whatever the action asked for, the status that goes
+// with the error if it is a known one (such as a downstream service timing out), or 400 by default
+func actionErrorStatus(err error) int {
+	status, _ := describeError(err, 0)
+	return status
+}
+
+// the error code an action's error is reported with (see problems.go)
+func actionErrorCode(err error) string {
+	_, code := describeError(err, 0)
+	return code
+}
+
+// details of an action's error that the client can act on, such as the actions that do exist,
+// or the fields that broke a rule. nil if there are none
+func actionErrorData(err error) any {
+	var unknown *unknownActionError
+	if errors.As(err, &unknown) {
+		return unknown
+	}
+
+	var invalid validate.Errors
+	if errors.As(err, &invalid) {
+		return map[string]any{"fields": invalid}
+	}
+
+	return nil
+}
+
+// the status code an action's error is sent back with by the version of the api answering w.
+// the first version predates the status codes of /v2 (see problems.go), so its clients keep getting
+// a 400 for everything but the statuses an action asked for with withStatus
+func versionedErrorStatus(w http.ResponseWriter, err error) int {
+	if w.Header().Get(apiVersionHeader) == "2" {
+		return actionErrorStatus(err)
+	}
+
+	var se *statusError
+	if errors.As(err, &se) && se.Code == "" {
+		return se.Status
+	}
+
+	return http.StatusBadRequest
+}
+
+// write the error returned by an action
+func (app *Config) actionErrorJSON(w http.ResponseWriter, err error) error {
+	if w.Header().Get(apiVersionHeader) == "2" {
+		return app.writeProblem(w, err, actionErrorStatus(err))
+	}
+
+	payload := jsonResponse{
+		Error:   true,
+		Message: err.Error(),
+		Data:    actionErrorData(err),
+	}
+
+	return app.writeJSON(w, versionedErrorStatus(w, err), payload)
+}
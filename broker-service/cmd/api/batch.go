@@ -0,0 +1,248 @@
+// running many /handle requests in a single call
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// most items a single batch may hold
+	maxBatchSize = 1000
+	// how many items of an unordered batch run at once, unless the request asks for something else
+	defaultBatchConcurrency = 8
+	maxBatchConcurrency     = 32
+)
+
+// how a batch reacts to an item that fails
+const (
+	// keep going and report every item's result
+	batchBestEffort = "best-effort"
+	// stop at the first failure. items that were still running are cancelled and, like the rest, skipped
+	batchFailFast = "fail-fast"
+)
+
+// the cause of a fail-fast batch's context being cancelled
+var errBatchFailed = errors.New("cancelled after another item of the batch failed")
+
+// what happened to a single item of a batch
+const (
+	itemSucceeded = "succeeded"
+	itemFailed    = "failed"
+	itemSkipped   = "skipped"
+)
+
+// options for a batch, read from the query string of /handle/batch
+type batchOptions struct {
+	Mode string
+	// run the items one after the other in the order they were sent, instead of concurrently
+	Ordered     bool
+	Concurrency int
+}
+
+// the result of one item of a batch. results are always returned in the same order as the items
+type batchResult struct {
+	Index  int    `json:"index"`
+	Action string `json:"action"`
+	Status string `json:"status"`
+	Code   int    `json:"code,omitempty"` // the status code the item would have gotten from /handle
+	// machine readable code of the item's error (see problems.go)
+	ErrorCode string `json:"error_code,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Data      any    `json:"data,omitempty"`
+	// the item's error, so its Code can be sent the way the version of the api answering does
+	err error
+}
+
+// summary sent back in the Data field of the batch's response
+type batchSummary struct {
+	Mode      string        `json:"mode"`
+	Ordered   bool          `json:"ordered"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Results   []batchResult `json:"results"`
+}
+
+// method that will be called when we send a post request to "localhost:80/handle/batch" (will be mapped to 8080 through docker)
+// takes a json array of the same payloads that /handle takes, e.g.
+// POST /handle/batch?mode=best-effort&ordered=false&concurrency=8
+func (app *Config) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	opts, err := readBatchOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var items []RequestPayload
+	err = app.readJSON(w, r, &items)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if len(items) == 0 {
+		app.errorJSON(w, errors.New("batch must hold at least one item"))
+		return
+	}
+	if len(items) > maxBatchSize {
+		app.errorJSON(w, fmt.Errorf("batch holds %d items, the most allowed is %d", len(items), maxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := app.runBatch(r.Context(), items, opts)
+
+	summary := batchSummary{
+		Mode:    opts.Mode,
+		Ordered: opts.Ordered,
+		Results: results,
+	}
+	for i, result := range results {
+		switch result.Status {
+		case itemSucceeded:
+			summary.Succeeded++
+		case itemFailed:
+			summary.Failed++
+			results[i].Code = versionedErrorStatus(w, result.err)
+		case itemSkipped:
+			summary.Skipped++
+		}
+	}
+
+	payload := jsonResponse{
+		Error: summary.Failed > 0,
+		Message: fmt.Sprintf("processed batch of %d items: %d succeeded, %d failed, %d skipped",
+			len(items), summary.Succeeded, summary.Failed, summary.Skipped),
+		Data: summary,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// read the batch's options from the query string, using the defaults for anything left out
+func readBatchOptions(r *http.Request) (batchOptions, error) {
+	query := r.URL.Query()
+
+	opts := batchOptions{
+		Mode:        batchBestEffort,
+		Concurrency: defaultBatchConcurrency,
+	}
+
+	if mode := query.Get("mode"); mode != "" {
+		if mode != batchBestEffort && mode != batchFailFast {
+			return opts, fmt.Errorf("unknown batch mode %q, expected %q or %q", mode, batchBestEffort, batchFailFast)
+		}
+		opts.Mode = mode
+	}
+
+	if ordered := query.Get("ordered"); ordered != "" {
+		b, err := strconv.ParseBool(ordered)
+		if err != nil {
+			return opts, fmt.Errorf("ordered must be true or false, got %q", ordered)
+		}
+		opts.Ordered = b
+	}
+
+	if concurrency := query.Get("concurrency"); concurrency != "" {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil || n < 1 || n > maxBatchConcurrency {
+			return opts, fmt.Errorf("concurrency must be a number between 1 and %d, got %q", maxBatchConcurrency, concurrency)
+		}
+		opts.Concurrency = n
+	}
+
+	// ordered items run one at a time
+	if opts.Ordered {
+		opts.Concurrency = 1
+	}
+
+	return opts, nil
+}
+
+// run every item of a batch through the action registry, at most opts.Concurrency at a time
+func (app *Config) runBatch(ctx context.Context, items []RequestPayload, opts batchOptions) []batchResult {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]batchResult, len(items))
+	for i, item := range items {
+		results[i] = batchResult{
+			Index:  i,
+			Action: item.Action,
+			Status: itemSkipped,
+		}
+	}
+
+	// semaphore that bounds how many items run at once
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+
+	for i := range items {
+		// wait for a free slot, unless the batch has been cancelled in the meantime
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := app.runBatchItem(ctx, i, items[i])
+			// an item that was cancelled because another one failed didnt fail itself
+			if result.Status == itemFailed && errors.Is(result.err, context.Canceled) && errors.Is(context.Cause(ctx), errBatchFailed) {
+				result = batchResult{
+					Index:   i,
+					Action:  items[i].Action,
+					Status:  itemSkipped,
+					Message: errBatchFailed.Error(),
+				}
+			}
+			results[i] = result
+
+			if result.Status == itemFailed && opts.Mode == batchFailFast {
+				cancel(errBatchFailed)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// run a single item of a batch the same way /handle would
+func (app *Config) runBatchItem(ctx context.Context, index int, item RequestPayload) batchResult {
+	result := batchResult{
+		Index:  index,
+		Action: item.Action,
+	}
+
+	payload, err := app.Actions.dispatch(ctx, item)
+	if err != nil {
+		result.Status = itemFailed
+		result.Code = actionErrorStatus(err)
+		result.ErrorCode = actionErrorCode(err)
+		result.Error = err.Error()
+		result.Data = actionErrorData(err)
+		result.err = err
+
+		return result
+	}
+
+	result.Status = itemSucceeded
+	result.Code = http.StatusAccepted
+	result.Message = payload.Message
+	result.Data = payload.Data
+
+	return result
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestReadBatchOptions(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    batchOptions
+		wantErr bool
+	}{
+		{query: "", want: batchOptions{Mode: batchBestEffort, Concurrency: defaultBatchConcurrency}},
+		{query: "mode=fail-fast", want: batchOptions{Mode: batchFailFast, Concurrency: defaultBatchConcurrency}},
+		{query: "concurrency=3", want: batchOptions{Mode: batchBestEffort, Concurrency: 3}},
+		// ordered items run one at a time, whatever the concurrency says
+		{query: "ordered=true&concurrency=3", want: batchOptions{Mode: batchBestEffort, Ordered: true, Concurrency: 1}},
+		{query: "mode=all-or-nothing", wantErr: true},
+		{query: "ordered=maybe", wantErr: true},
+		{query: "concurrency=0", wantErr: true},
+		{query: fmt.Sprintf("concurrency=%d", maxBatchConcurrency+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/handle/batch?"+tt.query, nil)
+
+			opts, err := readBatchOptions(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts != tt.want {
+				t.Fatalf("got %+v, want %+v", opts, tt.want)
+			}
+		})
+	}
+}
+
+// the payload of the actions registered by batchTestApp
+type batchTestPayload struct {
+	N int `json:"n"`
+}
+
+// an app whose actions are "ok", which succeeds, "fail", which fails, and "block", which waits for its
+// batch to be cancelled. the items that ran are returned in the order they ran in
+func batchTestApp() (*Config, func() []int) {
+	var mu sync.Mutex
+	var ran []int
+	record := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, n)
+	}
+
+	reg := newActionRegistry()
+	registerAction(reg, "ok", func(ctx context.Context, p batchTestPayload) (jsonResponse, error) {
+		record(p.N)
+		return jsonResponse{Message: "ok"}, nil
+	})
+	registerAction(reg, "fail", func(ctx context.Context, p batchTestPayload) (jsonResponse, error) {
+		record(p.N)
+		return jsonResponse{}, withStatus(errors.New("downstream failed"), http.StatusBadGateway)
+	})
+	registerAction(reg, "block", func(ctx context.Context, p batchTestPayload) (jsonResponse, error) {
+		record(p.N)
+		<-ctx.Done()
+		return jsonResponse{}, ctx.Err()
+	})
+
+	return &Config{Actions: reg}, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), ran...)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []string
+		opts    batchOptions
+		want    []string
+		// the items that ran, in the order they ran in. only checked when set
+		wantRan []int
+	}{
+		{
+			name:    "best effort runs every item",
+			actions: []string{"ok", "fail", "ok", "fail"},
+			opts:    batchOptions{Mode: batchBestEffort, Concurrency: 4},
+			want:    []string{itemSucceeded, itemFailed, itemSucceeded, itemFailed},
+		},
+		{
+			name:    "ordered runs the items in order",
+			actions: []string{"ok", "fail", "ok", "ok"},
+			opts:    batchOptions{Mode: batchBestEffort, Ordered: true, Concurrency: 1},
+			want:    []string{itemSucceeded, itemFailed, itemSucceeded, itemSucceeded},
+			wantRan: []int{0, 1, 2, 3},
+		},
+		{
+			name:    "ordered fail fast skips everything after the failure",
+			actions: []string{"ok", "fail", "ok", "ok"},
+			opts:    batchOptions{Mode: batchFailFast, Ordered: true, Concurrency: 1},
+			want:    []string{itemSucceeded, itemFailed, itemSkipped, itemSkipped},
+			wantRan: []int{0, 1},
+		},
+		{
+			name:    "fail fast cancels the items still running",
+			actions: []string{"block", "fail", "ok"},
+			opts:    batchOptions{Mode: batchFailFast, Concurrency: 2},
+			want:    []string{itemSkipped, itemFailed, itemSkipped},
+		},
+
+		{
+			name:    "unknown actions fail",
+			actions: []string{"ok", "nope"},
+			opts:    batchOptions{Mode: batchBestEffort, Concurrency: 2},
+			want:    []string{itemSucceeded, itemFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, ran := batchTestApp()
+
+			items := make([]RequestPayload, len(tt.actions))
+			for i, name := range tt.actions {
+				items[i] = RequestPayload{Action: name, Payload: json.RawMessage(fmt.Sprintf(`{"n": %d}`, i))}
+			}
+
+			results := app.runBatch(context.Background(), items, tt.opts)
+
+			var got []string
+			for i, result := range results {
+				if result.Index != i || result.Action != tt.actions[i] {
+					t.Errorf("result %d is for item %d (%s)", i, result.Index, result.Action)
+				}
+				got = append(got, result.Status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			if tt.wantRan != nil && !reflect.DeepEqual(ran(), tt.wantRan) {
+				t.Fatalf("items ran in the order %v, want %v", ran(), tt.wantRan)
+			}
+		})
+	}
+}
+
+func TestRunBatchReportsTheItemsError(t *testing.T) {
+	app, _ := batchTestApp()
+
+	results := app.runBatch(context.Background(), []RequestPayload{{Action: "fail"}}, batchOptions{Mode: batchBestEffort, Concurrency: 1})
+
+	if results[0].Code != http.StatusBadGateway {
+		t.Errorf("code = %d, want %d", results[0].Code, http.StatusBadGateway)
+	}
+	if results[0].Error != "downstream failed" {
+		t.Errorf("error = %q", results[0].Error)
+	}
+}
+
+func TestHandleBatchOnlyCountsTheItemThatFailed(t *testing.T) {
+	app, _ := batchTestApp()
+
+	body := `[{"action": "block"}, {"action": "fail"}, {"action": "ok"}]`
+	r := httptest.NewRequest(http.MethodPost, "/handle/batch?mode=fail-fast&concurrency=2", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	app.HandleBatch(w, r)
+
+	var res struct {
+		Data batchSummary `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Data.Succeeded != 0 || res.Data.Failed != 1 || res.Data.Skipped != 2 {
+		t.Fatalf("got %d succeeded, %d failed and %d skipped, want 0, 1 and 2: %s", res.Data.Succeeded, res.Data.Failed, res.Data.Skipped, w.Body)
+	}
+	if cancelled := res.Data.Results[0]; cancelled.Error != "" || cancelled.Message != errBatchFailed.Error() {
+		t.Fatalf("the cancelled item was reported as %+v", cancelled)
+	}
+}
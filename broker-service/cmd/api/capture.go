@@ -0,0 +1,131 @@
+// recording a sample of /handle traffic, so that it can be replayed against a broker later (see cmd/replay)
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jateen67/broker/capture"
+	"github.com/jateen67/broker/requestid"
+)
+
+// used when CAPTURE_PATH isnt set
+const defaultCapturePath = "capture.ndjson"
+
+// request headers that change what /handle does, so they are recorded with the request
+var capturedHeaders = []string{idempotencyHeader}
+
+// recording /handle traffic is turned on by setting CAPTURE_SAMPLE to the fraction of requests to record, e.g. 0.01.
+// the requests and responses are appended to CAPTURE_PATH, until it is CAPTURE_MAX_BYTES long (100mb by default).
+// returns nil, and a nil close func, when recording is off
+func newTrafficCapture() (*capture.Recorder, func() error, error) {
+	value := os.Getenv("CAPTURE_SAMPLE")
+	if value == "" {
+		return nil, nil, nil
+	}
+
+	sample, err := strconv.ParseFloat(value, 64)
+	if err != nil || sample < 0 || sample > 1 {
+		return nil, nil, fmt.Errorf("CAPTURE_SAMPLE: must be a number between 0 and 1, got %q", value)
+	}
+	if sample == 0 {
+		return nil, nil, nil
+	}
+
+	maxBytes := int64(100 << 20)
+	if value := os.Getenv("CAPTURE_MAX_BYTES"); value != "" {
+		maxBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes < 0 {
+			return nil, nil, fmt.Errorf("CAPTURE_MAX_BYTES: invalid number %q", value)
+		}
+	}
+
+	path := os.Getenv("CAPTURE_PATH")
+	if path == "" {
+		path = defaultCapturePath
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CAPTURE_PATH: %w", err)
+	}
+
+	// the limit counts what is already in the file, so restarting the broker doesnt reset it
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if maxBytes > 0 {
+		maxBytes -= info.Size()
+		if maxBytes <= 0 {
+			log.Printf("%s is already %d bytes long, no requests will be recorded\n", path, info.Size())
+			f.Close()
+			return nil, nil, nil
+		}
+	}
+
+	log.Printf("recording %g of /handle requests to %s\n", sample, path)
+
+	return capture.NewRecorder(f, capture.Options{Sample: sample, MaxBytes: maxBytes}), f.Close, nil
+}
+
+// records a sample of the requests that pass through it, and the responses sent back, with their secrets redacted
+func (app *Config) captureTraffic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Capture == nil || !app.Capture.Sample() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// read the body so it can be recorded, then put it back for the handler
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1048576))
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		record := capture.Record{
+			Time:      start,
+			RequestID: requestid.FromContext(r.Context()),
+			Method:    r.Method,
+			URI:       r.URL.RequestURI(),
+			Status:    rec.status,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		}
+
+		for _, name := range capturedHeaders {
+			if value := r.Header.Get(name); value != "" {
+				if record.Header == nil {
+					record.Header = map[string]string{}
+				}
+				record.Header[name] = value
+			}
+		}
+
+		// the same envelope /handle reads, so the recording can be filtered by action
+		var envelope RequestPayload
+		if json.Unmarshal(body, &envelope) == nil {
+			record.Action = envelope.Action
+		}
+
+		record.Request, record.Redacted = capture.Redact(body)
+		record.Response, _ = capture.Redact(rec.body.Bytes())
+
+		if err := app.Capture.Write(record); err != nil {
+			log.Println("error recording request:", err)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+// resilient http clients for the services the broker calls
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jateen67/broker/requestid"
+	"github.com/jateen67/broker/resilient"
+	"github.com/jateen67/registry"
+	"github.com/jateen67/tracing"
+)
+
+// names of the downstream services, as defined in our docker-compose file.
+// the registry (see newRegistry) maps each of them to the instances it runs on
+const (
+	authService   = "authentication-service"
+	loggerService = "logger-service"
+	mailerService = "mailer-service"
+)
+
+// ports the logger service listens on for net/rpc and grpc
+const (
+	loggerRPCPort  = "5001"
+	loggerGRPCPort = "50001"
+)
+
+// net/rpc method of the logger service that does nothing but answer
+const loggerRPCPing = "RPCServer.Ping"
+
+// load the registry of downstream services from SERVICE_REGISTRY_FILE and the env (see registry.LoadConfig)
+func newRegistry() (*registry.Registry, error) {
+	services := []string{authService, loggerService, mailerService}
+
+	cfg, err := registry.LoadConfig(services...)
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.New(cfg, services...), nil
+}
+
+// url of a path on a downstream service. the registry picks which instance of the service it goes to
+func serviceURL(service, path string) string {
+	return "http://" + service + path
+}
+
+// an http transport that sends every request to an instance of the service it is for,
+// with a client span that passes the trace on to that service
+func serviceTransport(reg *registry.Registry) http.RoundTripper {
+	return tracing.Transport(&registry.Transport{Registry: reg})
+}
+
+// one client per downstream service, each with its own timeout and circuit breaker
+func newClients(reg *registry.Registry) map[string]*resilient.Client {
+	breaker := resilient.BreakerOptions{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+	}
+
+	// every call goes to one of the service's instances, gets a client span, and passes the trace on
+	transport := serviceTransport(reg)
+
+	return map[string]*resilient.Client{
+		// authenticating only reads from the db, so it is safe to retry
+		authService: resilient.NewClient(authService, resilient.Options{
+			Timeout:     5 * time.Second,
+			MaxRetries:  2,
+			BaseBackoff: 100 * time.Millisecond,
+			MaxBackoff:  time.Second,
+			Breaker:     breaker,
+			Transport:   transport,
+		}),
+		loggerService: resilient.NewClient(loggerService, resilient.Options{
+			Timeout:   5 * time.Second,
+			Breaker:   breaker,
+			Transport: transport,
+		}),
+		// the mailer does a whole smtp round trip (with 10 second connect and send timeouts) before answering
+		mailerService: resilient.NewClient(mailerService, resilient.Options{
+			Timeout:   25 * time.Second,
+			Breaker:   breaker,
+			Transport: transport,
+		}),
+	}
+}
+
+// send a request that must only be made once to a downstream service
+func (app *Config) doRequest(service string, request *http.Request) (*http.Response, error) {
+	setRequestID(request)
+
+	res, err := app.Clients[service].Do(request)
+	return res, downstreamError(err)
+}
+
+// send a request that is safe to repeat to a downstream service, retrying it if the service fails
+func (app *Config) doIdempotentRequest(service string, request *http.Request) (*http.Response, error) {
+	setRequestID(request)
+
+	res, err := app.Clients[service].DoIdempotent(request)
+	return res, downstreamError(err)
+}
+
+// pass the id of the request we are handling on to the downstream service
+func setRequestID(request *http.Request) {
+	if id := requestid.FromContext(request.Context()); id != "" {
+		request.Header.Set(requestid.Header, id)
+	}
+}
+
+// a call refused by an open breaker means the service is unavailable, not that the request was bad
+func downstreamError(err error) error {
+	if errors.Is(err, resilient.ErrCircuitOpen) {
+		return withStatus(err, http.StatusServiceUnavailable)
+	}
+
+	return err
+}
+
+// the error for a downstream service that answered with a status code we didnt expect.
+// the request was already checked by the broker, so it is the downstream service's fault either way
+func unexpectedStatus(message string, status int) error {
+	err := fmt.Errorf("%s: it answered with status %d", message, status)
+
+	switch status {
+	case http.StatusServiceUnavailable:
+		return withCode(err, codeDownstreamUnavailable)
+	case http.StatusGatewayTimeout:
+		return withCode(err, codeDownstreamTimeout)
+	}
+
+	return withCode(err, codeDownstreamError)
+}
+
+// method that will be called when we send a get request to "localhost:80/breakers" (will be mapped to 8080 through docker)
+// shows the state of the circuit breaker of every downstream service
+func (app *Config) Breakers(w http.ResponseWriter, r *http.Request) {
+	breakers := make(map[string]resilient.BreakerSnapshot, len(app.Clients))
+	for name, client := range app.Clients {
+		breakers[name] = client.Breaker()
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "circuit breakers",
+		Data:    breakers,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// method that will be called when we send a get request to "localhost:80/services" (will be mapped to 8080 through docker)
+// shows every instance of every downstream service, and whether it has been ejected
+func (app *Config) Services(w http.ResponseWriter, r *http.Request) {
+	payload := jsonResponse{
+		Error:   false,
+		Message: "service instances",
+		Data:    app.Registry.Snapshot(),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
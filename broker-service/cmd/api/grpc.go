@@ -0,0 +1,252 @@
+// grpc api for the broker (see proto/broker/broker.proto), served next to the http one.
+// every call is run through the same action registry as the /handle endpoint
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/jateen67/broker/brokerpb"
+	"github.com/jateen67/broker/requestid"
+	"github.com/jateen67/tracing"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const gRpcPort = "50001"
+
+type BrokerServer struct {
+	brokerpb.UnimplementedBrokerServiceServer
+	Actions *actionRegistry
+}
+
+func (b *BrokerServer) Authenticate(ctx context.Context, req *brokerpb.AuthRequest) (*brokerpb.AuthResponse, error) {
+	// the user and the tokens the authentication service sent back, side by side in one object
+	var data struct {
+		ID           int64  `json:"id"`
+		Email        string `json:"email"`
+		FirstName    string `json:"first_name"`
+		LastName     string `json:"last_name"`
+		Active       int32  `json:"active"`
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int32  `json:"expires_in"`
+	}
+
+	message, err := b.run(ctx, "auth", AuthPayload{Email: req.GetEmail(), Password: req.GetPassword()}, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &brokerpb.AuthResponse{
+		Message: message,
+		User: &brokerpb.User{
+			Id:        data.ID,
+			Email:     data.Email,
+			FirstName: data.FirstName,
+			LastName:  data.LastName,
+			Active:    data.Active,
+		},
+		AccessToken:  data.AccessToken,
+		RefreshToken: data.RefreshToken,
+		TokenType:    data.TokenType,
+		ExpiresIn:    data.ExpiresIn,
+	}
+	return res, nil
+}
+
+func (b *BrokerServer) Log(ctx context.Context, req *brokerpb.LogRequest) (*brokerpb.LogResponse, error) {
+	var data logResult
+
+	message, err := b.run(ctx, "log", LogPayload{Name: req.GetName(), Data: req.GetData(), Transport: req.GetTransport(),
+		Severity: req.GetSeverity(), Route: req.GetRoute()}, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &brokerpb.LogResponse{
+		Message:   message,
+		Transport: data.Transport,
+		Result:    data.Result,
+	}
+	return res, nil
+}
+
+func (b *BrokerServer) SendMail(ctx context.Context, req *brokerpb.MailRequest) (*brokerpb.MailResponse, error) {
+	payload := MailPayload{
+		From:    req.GetFrom(),
+		To:      req.GetTo(),
+		Subject: req.GetSubject(),
+		Message: req.GetMessage(),
+	}
+
+	message, err := b.run(ctx, "mail", payload, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &brokerpb.MailResponse{Message: message}
+	return res, nil
+}
+
+// run an action the same way /handle would, and decode the Data of its response into data (if it isnt nil).
+// returns the response's message, or the action's error as a grpc status
+func (b *BrokerServer) run(ctx context.Context, name string, payload any, data any) (string, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+
+	res, err := b.Actions.dispatch(ctx, RequestPayload{Action: name, Payload: raw})
+	if err != nil {
+		return "", grpcError(err)
+	}
+
+	// Data is whatever the action put in it: one of our own types, or json decoded from a downstream service.
+	// going through json gets either one into data
+	if data != nil && res.Data != nil {
+		j, err := json.Marshal(res.Data)
+		if err != nil {
+			return "", status.Error(codes.Internal, err.Error())
+		}
+
+		err = json.Unmarshal(j, data)
+		if err != nil {
+			return "", status.Error(codes.Internal, fmt.Sprintf("unexpected response from the %q action: %v", name, err))
+		}
+	}
+
+	return res.Message, nil
+}
+
+// turn an action's error into a grpc status, with the code that matches the http status /handle would have sent.
+// the broker's own error code (see problems.go) goes along as the reason of an ErrorInfo detail
+func grpcError(err error) error {
+	code := grpcCode(actionErrorStatus(err))
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+
+	st, detailErr := status.New(code, err.Error()).WithDetails(&errdetails.ErrorInfo{
+		Reason: actionErrorCode(err),
+		Domain: "broker",
+	})
+	if detailErr != nil {
+		return status.Error(code, err.Error())
+	}
+
+	return st.Err()
+}
+
+// the grpc code closest to an http status code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+
+	if httpStatus >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+
+	return codes.Unknown
+}
+
+// first value of a key in the call's metadata
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// grpc version of the assignRequestID middleware. the id is read from and sent back in the x-request-id metadata
+func assignRequestIDGRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	id := metadataValue(ctx, requestid.MetadataKey)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataKey, id))
+
+	return handler(requestid.WithID(ctx, id), req)
+}
+
+// grpc version of the authenticateToken middleware. the token is read from the "authorization" metadata.
+// like over http, a bad token doesnt stop the call; only actions that arent public turn it away
+func (app *Config) authenticateTokenGRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	header := metadataValue(ctx, "authorization")
+	if header == "" {
+		return handler(ctx, req)
+	}
+
+	return handler(app.verifyToken(ctx, header), req)
+}
+
+// build the grpc server. its health service is returned too, so that it can be marked as not serving while we shut down
+func (app *Config) gRPCServer() (*grpc.Server, *health.Server) {
+	// new grpc server, with a span for every call, and the same request ids and access tokens as the http api
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		tracing.UnaryServerInterceptor(),
+		assignRequestIDGRPC,
+		app.authenticateTokenGRPC,
+	))
+
+	// register the service
+	brokerpb.RegisterBrokerServiceServer(s, &BrokerServer{Actions: app.Actions})
+
+	// register the standard grpc health service so that clients can check that we are serving
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	return s, healthServer
+}
+
+// serve grpc on gRpcPort until the server is stopped
+func gRPCListen(s *grpc.Server) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRpcPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen for grpc: %w", err)
+	}
+
+	log.Printf("grpc server started on port %s", gRpcPort)
+
+	return s.Serve(lis)
+}
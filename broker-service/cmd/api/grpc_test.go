@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jateen67/broker/event"
+	"github.com/jateen67/broker/idempotency"
+	"github.com/jateen67/broker/jobs"
+	"github.com/jateen67/broker/logstream"
+	"github.com/jateen67/broker/resilient"
+	"github.com/jateen67/broker/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{name: "bad request", err: errors.New("bad payload"), wantCode: codes.InvalidArgument, wantReason: codeBadRequest},
+		{name: "validation failed", err: validate.Errors{{Field: "email", Rule: "required", Message: "is required"}}, wantCode: codes.InvalidArgument, wantReason: codeValidationFailed},
+		{name: "unknown action", err: &unknownActionError{Action: "nope", Available: []string{"log"}}, wantCode: codes.InvalidArgument, wantReason: codeUnknownAction},
+		{name: "unauthenticated", err: errUnauthenticated, wantCode: codes.Unauthenticated, wantReason: codeUnauthenticated},
+		{name: "invalid token", err: errInvalidToken, wantCode: codes.Unauthenticated, wantReason: codeInvalidToken},
+		{name: "invalid credentials", err: withStatus(withCode(errors.New("wrong password"), codeInvalidCredentials), http.StatusUnauthorized), wantCode: codes.Unauthenticated, wantReason: codeInvalidCredentials},
+		{name: "forbidden", err: withCode(errors.New("admins only"), codeForbidden), wantCode: codes.PermissionDenied, wantReason: codeForbidden},
+		{name: "not found", err: withStatus(errors.New("no such job"), http.StatusNotFound), wantCode: codes.NotFound, wantReason: codeNotFound},
+		{name: "payload too large", err: withCode(errors.New("too big"), codePayloadTooLarge), wantCode: codes.Unknown, wantReason: codePayloadTooLarge},
+		{name: "idempotency key in use", err: idempotency.ErrInProgress, wantCode: codes.AlreadyExists, wantReason: codeIdempotencyKeyInUse},
+		{name: "idempotency key reused", err: idempotency.ErrMismatch, wantCode: codes.InvalidArgument, wantReason: codeIdempotencyKeyReused},
+		{name: "queue full", err: jobs.ErrQueueFull, wantCode: codes.Unavailable, wantReason: codeQueueFull},
+		{name: "too many subscribers", err: logstream.ErrTooManySubscribers, wantCode: codes.Unavailable, wantReason: codeTooManySubscribers},
+		{name: "internal", err: withCode(errors.New("oops"), codeInternal), wantCode: codes.Internal, wantReason: codeInternal},
+		{name: "downstream error", err: event.ErrNacked, wantCode: codes.Internal, wantReason: codeDownstreamError},
+		{name: "downstream unavailable", err: fmt.Errorf("calling the logger: %w", resilient.ErrCircuitOpen), wantCode: codes.Unavailable, wantReason: codeDownstreamUnavailable},
+		{name: "rabbitmq down", err: event.ErrNotConnected, wantCode: codes.Unavailable, wantReason: codeDownstreamUnavailable},
+		{name: "downstream timeout", err: event.ErrConfirmTimeout, wantCode: codes.DeadlineExceeded, wantReason: codeDownstreamTimeout},
+		{name: "unroutable", err: &event.UnroutableError{RoutingKey: "log.INFO"}, wantCode: codes.Unavailable, wantReason: codeEventUnroutable},
+		{name: "logger's grpc server unavailable", err: status.Error(codes.Unavailable, "connection refused"), wantCode: codes.Unavailable, wantReason: codeDownstreamUnavailable},
+		// the call's own context runs out, whatever status the error would get otherwise
+		{name: "deadline exceeded", err: fmt.Errorf("calling the logger: %w", context.DeadlineExceeded), wantCode: codes.DeadlineExceeded, wantReason: codeDownstreamTimeout},
+		{name: "cancelled", err: fmt.Errorf("calling the logger: %w", context.Canceled), wantCode: codes.Canceled, wantReason: codeBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ok := status.FromError(grpcError(tt.err))
+			if !ok {
+				t.Fatal("not a grpc status")
+			}
+			if st.Code() != tt.wantCode {
+				t.Errorf("code = %s, want %s", st.Code(), tt.wantCode)
+			}
+			if st.Message() != tt.err.Error() {
+				t.Errorf("message = %q, want %q", st.Message(), tt.err.Error())
+			}
+
+			details := st.Details()
+			if len(details) != 1 {
+				t.Fatalf("details = %v, want an ErrorInfo", details)
+			}
+			info, ok := details[0].(*errdetails.ErrorInfo)
+			if !ok {
+				t.Fatalf("details = %T, want an ErrorInfo", details[0])
+			}
+			if info.Reason != tt.wantReason || info.Domain != "broker" {
+				t.Errorf("error info = %s, %s, want %s, broker", info.Reason, info.Domain, tt.wantReason)
+			}
+		})
+	}
+}
@@ -5,47 +5,121 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
-	"net/rpc"
+	"sort"
 	"time"
 
 	"github.com/jateen67/broker/event"
 	"github.com/jateen67/broker/logs"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"github.com/jateen67/broker/requestid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
 )
 
 // agreed upon json format that all our microservices will adhere to. doesnt matter what were sending from our various services
+// the action's own payload sits under a key named after the action, e.g. {"action": "log", "log": {...}}
 type RequestPayload struct {
-	Action string      `json:"action"`
-	Auth   AuthPayload `json:"auth,omitempty"`
-	Log    LogPayload  `json:"log,omitempty"`
-	Mail   MailPayload `json:"mail,omitempty"`
+	Action  string          `json:"action"`
+	Payload json.RawMessage `json:"-"`
+	// any other keys the json had. they are ignored, unless the broker is in strict mode
+	unknown []string
 }
 
+// pull the action name out of the json, and keep the payload stored under that name undecoded
+// so that the action registered for it can decode it into its own type
+func (p *RequestPayload) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(b, &fields)
+	if err != nil {
+		return err
+	}
+
+	p.Action = ""
+	p.Payload = nil
+	p.unknown = nil
+
+	if raw, ok := fields["action"]; ok {
+		err = json.Unmarshal(raw, &p.Action)
+		if err != nil {
+			return err
+		}
+	}
+
+	if raw, ok := fields[p.Action]; ok && p.Action != "action" {
+		p.Payload = raw
+	}
+
+	for key := range fields {
+		if key != "action" && key != p.Action {
+			p.unknown = append(p.unknown, key)
+		}
+	}
+	sort.Strings(p.unknown)
+
+	return nil
+}
+
+// write the payload back out under the action's name, the same way it was received
+func (p RequestPayload) MarshalJSON() ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+
+	action, err := json.Marshal(p.Action)
+	if err != nil {
+		return nil, err
+	}
+	fields["action"] = action
+
+	if len(p.Payload) > 0 && p.Action != "action" {
+		fields[p.Action] = p.Payload
+	}
+
+	return json.Marshal(fields)
+}
+
+// returned by the "auth" action when the authentication service turns down the email and password
+var errInvalidCredentials = errors.New("invalid credentials")
+
 // format of the json in our auth service's 'Authenticate' method
 type AuthPayload struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email string `json:"email" validate:"required,email,max=255"`
+	// bcrypt only looks at the first 72 bytes of a password, so a longer one cant be right
+	Password string `json:"password" validate:"required,maxbytes=72"`
 }
 
 // format of the json in our auth service's 'WriteLog' method
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name string `json:"name" validate:"required,max=255"`
+	Data string `json:"data" validate:"max=65536"`
+	// one of "http", "rpc", "grpc" or "amqp" (see logging.go). the configured default is used if its left out
+	Transport string `json:"transport,omitempty" validate:"oneof=http rpc grpc amqp"`
+	// one of the configured severities, INFO if its left out (see logging.go)
+	Severity string `json:"severity,omitempty" validate:"severity"`
+	// extra segments for the routing key of an event sent over amqp, e.g. "auth" for "log.ERROR.auth"
+	Route string `json:"route,omitempty" validate:"max=200,route"`
 }
 
 // format of the json in our mail service's 'SendMail' method
 type MailPayload struct {
-	From    string `json:"from"`
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	Message string `json:"message"`
+	// the mail service's own address is used if its left out
+	From    string `json:"from" validate:"email,max=255"`
+	To      string `json:"to" validate:"required,email,max=255"`
+	Subject string `json:"subject" validate:"required,max=998"`
+	Message string `json:"message" validate:"required"`
 }
 
 type RPCPayload struct {
-	Name string
-	Data string
+	Name      string
+	Data      string
+	Severity  string
+	RequestID string
+	// trace context of the call, since net/rpc has no headers to carry it in
+	TraceContext map[string]string
 }
 
 // method that will be called when we send a post request to "localhost:80/" (will be mapped to 8080 through docker)
@@ -72,48 +146,52 @@ func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// take a different action based on what kind of json we receive and its content
-	switch requestPayload.Action {
-	case "auth":
-		app.authenticate(w, requestPayload.Auth)
-	case "log":
-		app.logEventViaRabbitMQ(w, requestPayload.Log)
-	case "mail":
-		app.sendMail(w, requestPayload.Mail)
-	default:
-		app.errorJSON(w, errors.New("unknown action"))
+	// with /handle?async=true the action runs in the background, and the client polls /jobs/{id} for its result
+	async, err := asyncRequested(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if async {
+		app.submitJob(w, r, requestPayload)
+		return
 	}
 
+	// take a different action based on the action named in the json, using the handler registered for it in actions.go
+	payload, err := app.Actions.dispatch(r.Context(), requestPayload)
+	if err != nil {
+		app.actionErrorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
+func (app *Config) authenticate(ctx context.Context, a AuthPayload) (jsonResponse, error) {
 	// create json that well send to the auth microservice by encoding the email/password json we receive ('a')
 	jsonData, _ := json.MarshalIndent(a, "", "\t")
 
 	// prepare service to send a post request to the /authenicate endpoint defined in the auth-service routes.go file
 	// we will prepare the recently encoded jsonData with the email/password as a request body
-	request, err := http.NewRequest("POST", "http://authentication-service/authenticate", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", serviceURL(authService, "/authenticate"), bytes.NewBuffer(jsonData))
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 
 	// we will actually send the request now and get the response from the auth service
-	client := &http.Client{}
-	res, err := client.Do(request)
+	// the client for it (see clients.go) takes care of the timeout, retries and circuit breaker
+	res, err := app.doIdempotentRequest(authService, request)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 	defer res.Body.Close()
 
 	// make sure we get the correct status code from the auth service
-	if res.StatusCode == http.StatusUnauthorized {
-		app.errorJSON(w, errors.New("nvalid credentials"))
-		return
+	// it turns down a wrong email or password with either a 400 or a 401
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusBadRequest {
+		return jsonResponse{}, errInvalidCredentials
 	} else if res.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, errors.New("error calling auth service"))
-		return
+		return jsonResponse{}, unexpectedStatus("error calling auth service", res.StatusCode)
 	}
 
 	// create a variable that we will read response's Body (that we get from the auth service) into
@@ -126,14 +204,12 @@ func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
 	// check for error when decoding the jsons's body (res.Body) into 'jsonFromService'. if the 'mold' isnt the same its an error
 	err = dec.Decode(&jsonFromService)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 
 	// check if the response json contains some Error value in it
 	if jsonFromService.Error {
-		app.errorJSON(w, err, http.StatusUnauthorized)
-		return
+		return jsonResponse{}, withStatus(withCode(errors.New(jsonFromService.Message), codeInvalidCredentials), http.StatusUnauthorized)
 	}
 
 	// after all these checks, we know that we have a valid login, so we send back the user a payload with good info
@@ -142,74 +218,76 @@ func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
 	payload.Message = "Authenticated!"
 	payload.Data = jsonFromService.Data // as defined in the auth-service's Authenticate function, this will be our User
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return payload, nil
 }
 
 // log item via json
-func (app *Config) logItem(w http.ResponseWriter, entry LogPayload) {
+func (app *Config) logItem(ctx context.Context, entry LogPayload) (jsonResponse, error) {
+	// the logger service only cares about the name, data and severity
+	entry.Transport = ""
+	entry.Route = ""
+	entry.Severity = severityOf(entry)
+
 	// create json that well send to the log microservice by encoding the name/data json we receive ('entry')
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 
 	// prepare service to send a post request to the /log endpoint defined in the logger-service routes.go file
 	// we will prepare the recently encoded jsonData with the name/data as a request body
-	request, err := http.NewRequest("POST", "http://logger-service/log", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", serviceURL(loggerService, "/log"), bytes.NewBuffer(jsonData))
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
 
 	// we will actually send the request now and get the response from the log service
-	client := &http.Client{}
-	res, err := client.Do(request)
+	// the client for it (see clients.go) takes care of the timeout, retries and circuit breaker
+	res, err := app.doRequest(loggerService, request)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 	defer res.Body.Close()
 
 	// make sure we get the correct status code from the log service
 	if res.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, unexpectedStatus("error calling logger service", res.StatusCode)
 	}
 
-	// after all these checks, we know that we have a valid log, so we send back the user a payload with good info
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Logged!"
+	// read the logger service's reply so that we can pass along what it said
+	var jsonFromService jsonResponse
+	err = json.NewDecoder(res.Body).Decode(&jsonFromService)
+	if err != nil {
+		return jsonResponse{}, err
+	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	// after all these checks, we know that we have a valid log, so we send back the user a payload with good info
+	return logResponse(transportHTTP, "Logged via HTTP!", jsonFromService.Message), nil
 }
 
-func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
+func (app *Config) sendMail(ctx context.Context, msg MailPayload) (jsonResponse, error) {
 	// create json that well send to the mail microservice by encoding the json we receive ('msg')
 	jsonData, _ := json.MarshalIndent(msg, "", "\t")
 
 	// prepare service to send a post request to the /send endpoint defined in the mail-service routes.go file
 	// we will prepare the recently encoded jsonData with the from/to/subject/message as a request body
-	request, err := http.NewRequest("POST", "http://mailer-service/send", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", serviceURL(mailerService, "/send"), bytes.NewBuffer(jsonData))
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
 
 	// we will actually send the request now and get the response from the mail service
-	client := &http.Client{}
-	res, err := client.Do(request)
+	// the client for it (see clients.go) takes care of the timeout, retries and circuit breaker
+	res, err := app.doRequest(mailerService, request)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 	defer res.Body.Close()
 
 	// make sure we get the correct status code from the mail service
 	if res.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, errors.New("error calling mail service"))
-		return
+		return jsonResponse{}, unexpectedStatus("error calling mail service", res.StatusCode)
 	}
 
 	// after all these checks, we know that we have a valid mail send, so we send back the user a payload with good info
@@ -217,41 +295,62 @@ func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
 	payload.Error = false
 	payload.Message = "Message sent to " + msg.To + "!"
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return payload, nil
 }
 
-// function to handle logging an item by emitting an event to rabbitmq
-func (app *Config) logEventViaRabbitMQ(w http.ResponseWriter, l LogPayload) {
-	err := app.pushToQueue(l.Name, l.Data)
+// function to handle logging an item by emitting an event to rabbitmq.
+// if rabbitmq is down, the event is kept in the outbox and pushed once it is back (see outbox.go)
+func (app *Config) logEventViaRabbitMQ(ctx context.Context, l LogPayload) (jsonResponse, error) {
+	key := routingKey(l)
+	body := eventBody(l)
+
+	// while older events are waiting in the outbox, this one has to wait behind them
+	if app.outboxWaiting() {
+		return app.logEventViaOutbox(ctx, body, key)
+	}
+
+	err := app.pushToQueue(ctx, body, key)
+	if event.Unavailable(err) {
+		log.Println("rabbitmq is unavailable, keeping the event in the outbox:", err)
+		return app.logEventViaOutbox(ctx, body, key)
+	}
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 
 	// if error is passed then we send back json response
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Logged via RabbitMQ!"
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return logResponse(transportAMQP, "Logged via RabbitMQ!", "pushed to logs_topic as "+key), nil
 }
 
-// utility function that will be used every time we need to push something to the queue
-func (app *Config) pushToQueue(name, msg string) error {
-	// get emitter
-	emitter, err := event.NewEventEmitter(app.Rabbit)
+// keep an event in the outbox, and tell the caller that it will be pushed later
+func (app *Config) logEventViaOutbox(ctx context.Context, body, key string) (jsonResponse, error) {
+	seq, err := app.queueEvent(ctx, body, key)
 	if err != nil {
-		return err
+		return jsonResponse{}, err
 	}
 
+	return logResponse(transportAMQP, "Queued for RabbitMQ!",
+		fmt.Sprintf("kept in the outbox as entry %d, to be pushed to logs_topic as %s", seq, key)), nil
+}
+
+// the event pushed to rabbitmq for a log entry
+func eventBody(l LogPayload) string {
 	// payload to push to queue
 	payload := LogPayload{
-		Name: name,
-		Data: msg,
+		Name:     l.Name,
+		Data:     l.Data,
+		Severity: severityOf(l),
 	}
 
 	// encode payload so we can push json to queue
 	j, _ := json.MarshalIndent(&payload, "", "\t")
-	err = emitter.Push(string(j), "log.INFO")
+	return string(j)
+}
+
+// utility function that will be used every time we need to push something to the queue
+// the id of the request being handled is sent along in the message's headers
+func (app *Config) pushToQueue(ctx context.Context, body, key string) error {
+	err := app.Emitter.Push(ctx, body, key)
 	if err != nil {
 		return err
 	}
@@ -259,72 +358,102 @@ func (app *Config) pushToQueue(name, msg string) error {
 	return nil
 }
 
-func (app *Config) logItemViaRPC(w http.ResponseWriter, l LogPayload) {
-	// create an rpc client
-	client, err := rpc.Dial("tcp", "logger-service:5001")
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-
+func (app *Config) logItemViaRPC(ctx context.Context, l LogPayload) (jsonResponse, error) {
 	// now we need to create some kind of payload
 	// create a type that exactly matches the one that the rpc server expects to get
 	rpcPayload := RPCPayload{
-		Name: l.Name,
-		Data: l.Data,
+		Name:         l.Name,
+		Data:         l.Data,
+		Severity:     severityOf(l),
+		RequestID:    requestid.FromContext(ctx),
+		TraceContext: map[string]string{},
 	}
 
+	// net/rpc isnt instrumented for us, so start the client span by hand and put its context in the payload
+	ctx, span := tracer.Start(ctx, "RPCServer.LogInfo", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("rpc.system", "net/rpc"), attribute.String("rpc.method", "RPCServer.LogInfo")))
+	defer span.End()
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(rpcPayload.TraceContext))
+
 	// get some kind of result back
 	var result string
 	// call the method (created in logger-service rpc.go file) with the payload and get back the result (also from the method)
-	err = client.Call("RPCServer.LogInfo", rpcPayload, &result)
+	// the call goes over one of the long-lived connections kept open by app.LogRPC
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	err := app.LogRPC.Call(ctx, "RPCServer.LogInfo", rpcPayload, &result)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return jsonResponse{}, err
 	}
 
 	// if error is passed then we send back json response
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = result
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return logResponse(transportRPC, "Logged via RPC!", result), nil
+}
+
+// log item via grpc
+func (app *Config) logItemViaGRPC(ctx context.Context, l LogPayload) (jsonResponse, error) {
+	// create client on one of the long-lived connections kept open by app.LogGRPC
+	c := logs.NewLogServiceClient(app.LogGRPC.Conn())
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	// send the id of the request being handled in the call's metadata
+	if id := requestid.FromContext(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestid.MetadataKey, id)
+	}
+
+	res, err := c.WriteLog(ctx, &logs.LogRequest{
+		LogEntry: &logs.Log{
+			Name:     l.Name,
+			Data:     l.Data,
+			Severity: severityOf(l),
+		},
+	})
+	if err != nil {
+		return jsonResponse{}, err
+	}
+
+	// if error is passed then we send back json response
+	return logResponse(transportGRPC, "Logged via gRPC!", res.GetResult()), nil
 }
 
+// grpc route kept for the front-end. same as sending the "log" action with the "grpc" transport
 func (app *Config) LogItemViaGRPC(w http.ResponseWriter, r *http.Request) {
-	var requestPayload RequestPayload
+	// like the "log" action, this needs a logged in user
+	if app.RequireAuth {
+		if err := requireIdentity(r.Context()); err != nil {
+			app.actionErrorJSON(w, err)
+			return
+		}
+	}
 
-	err := app.readJSON(w, r, &requestPayload)
+	// the log entry is stored under the "log" key of the request, whatever its "action" says
+	var body struct {
+		Log json.RawMessage `json:"log"`
+	}
+
+	err := app.readJSON(w, r, &body)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	conn, err := grpc.Dial("logger-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	// checked the same way as the "log" action's
+	requestPayload := RequestPayload{Action: "log", Payload: body.Log}
+	_, entry, err := app.Actions.prepare(r.Context(), requestPayload)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.actionErrorJSON(w, err)
 		return
 	}
-	defer conn.Close()
-
-	// create client
-	c := logs.NewLogServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
 
-	_, err = c.WriteLog(ctx, &logs.LogRequest{
-		LogEntry: &logs.Log{
-			Name: requestPayload.Log.Name,
-			Data: requestPayload.Log.Data,
-		},
-	})
+	payload, err := app.logItemViaGRPC(r.Context(), *entry.(*LogPayload))
 	if err != nil {
-		app.errorJSON(w, err)
+		app.actionErrorJSON(w, err)
 		return
 	}
 
-	// if error is passed then we send back json response
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Logged via gRPC!"
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
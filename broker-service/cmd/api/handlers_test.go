@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jateen67/broker/resilient"
+	"github.com/jateen67/broker/validate"
+)
+
+func TestLogItemViaGRPCReadsTheLogKey(t *testing.T) {
+	app := &Config{}
+	app.registerActions()
+	registerLogRules(defaultSeverities)
+
+	// the entry is only made invalid by its severity, so a "name is required" error would mean it was never read
+	body := `{"log": {"name": "event", "data": "hi", "severity": "NOPE"}}`
+	r := httptest.NewRequest(http.MethodPost, "/log-grpc", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	app.LogItemViaGRPC(w, r)
+
+	var res struct {
+		Data struct {
+			Fields []struct {
+				Field string `json:"field"`
+			} `json:"fields"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a 422, got %d: %s", w.Code, w.Body)
+	}
+	if len(res.Data.Fields) != 1 || res.Data.Fields[0].Field != "log.severity" {
+		t.Fatalf("expected only log.severity to be invalid, got %s", w.Body)
+	}
+}
+
+func TestActionErrorJSONKeepsTheFirstVersionsStatusCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		// the status the first version of the api has always sent, and the one /v2 sends with its code
+		wantV1   int
+		wantV2   int
+		wantCode string
+	}{
+		{name: "plain error", err: errors.New("bad"), wantV1: http.StatusBadRequest, wantV2: http.StatusBadRequest, wantCode: codeBadRequest},
+		{name: "unknown action", err: &unknownActionError{Action: "nope"}, wantV1: http.StatusBadRequest, wantV2: http.StatusBadRequest, wantCode: codeUnknownAction},
+		{name: "downstream error", err: unexpectedStatus("error calling mail service", http.StatusInternalServerError), wantV1: http.StatusBadRequest, wantV2: http.StatusBadGateway, wantCode: codeDownstreamError},
+		{name: "downstream timeout", err: context.DeadlineExceeded, wantV1: http.StatusBadRequest, wantV2: http.StatusGatewayTimeout, wantCode: codeDownstreamTimeout},
+		{name: "open breaker", err: downstreamError(resilient.ErrCircuitOpen), wantV1: http.StatusServiceUnavailable, wantV2: http.StatusServiceUnavailable, wantCode: codeDownstreamUnavailable},
+		{name: "invalid payload", err: withStatus(validate.Errors{{Field: "email"}}, http.StatusUnprocessableEntity), wantV1: http.StatusUnprocessableEntity, wantV2: http.StatusUnprocessableEntity, wantCode: codeValidationFailed},
+		{name: "invalid credentials", err: withStatus(withCode(errors.New("invalid credentials"), codeInvalidCredentials), http.StatusUnauthorized), wantV1: http.StatusUnauthorized, wantV2: http.StatusUnauthorized, wantCode: codeInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &Config{}
+
+			w := httptest.NewRecorder()
+			w.Header().Set(apiVersionHeader, "1")
+			app.actionErrorJSON(w, tt.err)
+			if w.Code != tt.wantV1 {
+				t.Errorf("v1 status = %d, want %d", w.Code, tt.wantV1)
+			}
+
+			w = httptest.NewRecorder()
+			w.Header().Set(apiVersionHeader, "2")
+			app.actionErrorJSON(w, tt.err)
+
+			var p problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantV2 || p.Code != tt.wantCode {
+				t.Errorf("v2 answered %d %q, want %d %q", w.Code, p.Code, tt.wantV2, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuthPayloadLimitsThePasswordInBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{name: "72 bytes", password: strings.Repeat("a", 72), valid: true},
+		{name: "73 bytes", password: strings.Repeat("a", 73)},
+		// 40 characters, but 80 bytes, which bcrypt would cut short
+		{name: "multi-byte characters", password: strings.Repeat("é", 40)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate.Struct(AuthPayload{Email: "admin@example.com", Password: tt.password})
+			if tt.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected the password to be turned down")
+			}
+		})
+	}
+}
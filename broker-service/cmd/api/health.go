@@ -0,0 +1,202 @@
+// checking that the broker and everything it depends on is up
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// how long each dependency gets to answer before it counts as down
+const healthTimeout = 2 * time.Second
+
+const (
+	healthUp       = "up"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// something the broker depends on, and how to check that it is up
+type dependency struct {
+	name string
+	// the broker cant do its job without a critical dependency, so it isnt ready while one is down.
+	// the others only degrade it, e.g. the mailer being down only breaks the "mail" action
+	critical bool
+	probe    func(ctx context.Context) error
+}
+
+// the result of checking one dependency
+type dependencyHealth struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	// up if every dependency is up, down if a critical one is down, and degraded otherwise
+	Status       string                      `json:"status"`
+	Dependencies map[string]dependencyHealth `json:"dependencies,omitempty"`
+}
+
+// everything the broker depends on
+func (app *Config) dependencies() []dependency {
+	return []dependency{
+		// every action that logs over amqp, and the default log transport, goes through rabbitmq
+		{name: "rabbitmq", critical: true, probe: app.probeRabbit},
+		{name: authService, probe: app.probePing(authService)},
+		{name: loggerService, probe: app.probePing(loggerService)},
+		{name: mailerService, probe: app.probePing(mailerService)},
+		{name: loggerService + "-grpc", probe: app.probeLoggerGRPC},
+		{name: loggerService + "-rpc", probe: app.probeLoggerRPC},
+	}
+}
+
+// check every dependency at the same time, giving each of them healthTimeout to answer
+func checkDependencies(ctx context.Context, deps []dependency) healthReport {
+	results := make([]dependencyHealth, len(deps))
+
+	var wg sync.WaitGroup
+	for i, dep := range deps {
+		wg.Add(1)
+		go func(i int, dep dependency) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := dep.probe(ctx)
+
+			results[i] = dependencyHealth{
+				Status:    healthUp,
+				Critical:  dep.critical,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = healthDown
+				results[i].Error = err.Error()
+			}
+		}(i, dep)
+	}
+	wg.Wait()
+
+	report := healthReport{
+		Status:       healthUp,
+		Dependencies: make(map[string]dependencyHealth, len(deps)),
+	}
+	for i, dep := range deps {
+		report.Dependencies[dep.name] = results[i]
+
+		if results[i].Status == healthUp {
+			continue
+		}
+		if dep.critical {
+			report.Status = healthDown
+		} else if report.Status == healthUp {
+			report.Status = healthDegraded
+		}
+	}
+
+	return report
+}
+
+// method that will be called when we send a get request to "localhost:80/health" (will be mapped to 8080 through docker)
+// checks every dependency and shows how each of them is doing. answers with a 503 if a critical one is down
+func (app *Config) Health(w http.ResponseWriter, r *http.Request) {
+	app.writeHealth(w, checkDependencies(r.Context(), app.dependencies()))
+}
+
+// method that will be called when we send a get request to "localhost:80/health/live"
+// the broker is alive as long as it can answer at all; restarting it wont bring a dependency back
+func (app *Config) Liveness(w http.ResponseWriter, r *http.Request) {
+	app.writeHealth(w, healthReport{Status: healthUp})
+}
+
+// method that will be called when we send a get request to "localhost:80/health/ready"
+// the broker is only ready for traffic while its critical dependencies are up, and it isnt shutting down
+func (app *Config) Readiness(w http.ResponseWriter, r *http.Request) {
+	if app.draining.Load() {
+		app.writeHealth(w, healthReport{Status: healthDown})
+		return
+	}
+
+	var critical []dependency
+	for _, dep := range app.dependencies() {
+		if dep.critical {
+			critical = append(critical, dep)
+		}
+	}
+
+	app.writeHealth(w, checkDependencies(r.Context(), critical))
+}
+
+func (app *Config) writeHealth(w http.ResponseWriter, report healthReport) {
+	status := http.StatusOK
+	if report.Status == healthDown {
+		status = http.StatusServiceUnavailable
+	}
+
+	payload := jsonResponse{
+		Error:   report.Status == healthDown,
+		Message: fmt.Sprintf("broker is %s", report.Status),
+		Data:    report,
+	}
+
+	app.writeJSON(w, status, payload)
+}
+
+func (app *Config) probeRabbit(ctx context.Context) error {
+	if app.Rabbit == nil || !app.Rabbit.Connected() {
+		return errors.New("not connected to rabbitmq")
+	}
+
+	return nil
+}
+
+// hit the /ping endpoint of a downstream service
+func (app *Config) probePing(service string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, "GET", serviceURL(service, "/ping"), nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := app.Probe.Do(request)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s answered with status %d", service, res.StatusCode)
+		}
+
+		return nil
+	}
+}
+
+// ask the standard grpc health service of the logger whether it is serving
+func (app *Config) probeLoggerGRPC(ctx context.Context) error {
+	res, err := healthpb.NewHealthClient(app.LogGRPC.Conn()).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("logger grpc server is %s", res.Status)
+	}
+
+	return nil
+}
+
+// call the logger's net/rpc ping method
+func (app *Config) probeLoggerRPC(ctx context.Context) error {
+	var reply string
+	return app.LogRPC.Call(ctx, loggerRPCPing, "ping", &reply)
+}
@@ -49,6 +49,9 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data any, header
 		return err
 	}
 
+	// json unless the caller asked for something more specific, like problem+json
+	w.Header().Set("Content-Type", "application/json")
+
 	// check if any headers were included as parameters to this function
 	if len(headers) > 0 {
 		for key, value := range headers[0] {
@@ -58,7 +61,6 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data any, header
 	}
 
 	// write the data out
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 	if err != nil {
@@ -77,6 +79,11 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 		statusCode = status[0]
 	}
 
+	// the /v2 api sends errors as problem+json (see problems.go)
+	if w.Header().Get(apiVersionHeader) == "2" {
+		return app.writeProblem(w, err, statusCode)
+	}
+
 	var payload jsonResponse
 	payload.Error = true
 	payload.Message = err.Error()
@@ -0,0 +1,184 @@
+// de-duplicating retried submissions with the Idempotency-Key header
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jateen67/broker/idempotency"
+	"github.com/jateen67/broker/jwtauth"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// sent on a response that is a replay of the one stored for the key
+	idempotentReplayedHeader = "Idempotent-Replayed"
+
+	// longest key we accept
+	maxIdempotencyKeyLength = 255
+	// how long the first response for a key is replayed for
+	idempotencyTTL = 24 * time.Hour
+	// how long a key stays claimed by a request that never finishes, e.g. because the broker crashed.
+	// longer than any request can take
+	idempotencyLockTTL = 2 * time.Minute
+)
+
+// pick where idempotency keys are kept with the IDEMPOTENCY_STORE env variable:
+//   - "memory" (the default) keeps them in this broker's memory
+//   - "postgres" keeps them in the database at IDEMPOTENCY_DSN
+//   - "mongo" keeps them in the mongo server at IDEMPOTENCY_DSN
+func newIdempotencyStore(ctx context.Context) (idempotency.Store, error) {
+	dsn := os.Getenv("IDEMPOTENCY_DSN")
+
+	switch store := os.Getenv("IDEMPOTENCY_STORE"); store {
+	case "memory", "":
+		return idempotency.NewMemoryStore(), nil
+	case "postgres":
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		return idempotency.NewPostgresStore(ctx, db)
+	case "mongo":
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+		if err != nil {
+			return nil, err
+		}
+
+		return idempotency.NewMongoStore(ctx, client.Database("broker").Collection("idempotency_keys"))
+	default:
+		return nil, fmt.Errorf("unknown IDEMPOTENCY_STORE %q, expected memory, postgres or mongo", store)
+	}
+}
+
+// requests sent with an Idempotency-Key header are only run once. the response to the first one is stored,
+// and sent back (with an Idempotent-Replayed header) to every later request with the same key.
+// a request that comes in while the first one is still running gets a 409, and one that reuses a key for
+// a different request gets a 422 (see idempotencyScope for requests sent without an access token). responses with a 5xx status arent stored, so that those requests can be retried
+func (app *Config) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			app.errorJSON(w, fmt.Errorf("%s must be at most %d characters long", idempotencyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		// read the body so it can be fingerprinted, then put it back for the handler
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1048576))
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// keys are only shared between requests from the same user
+		fingerprint := requestFingerprint(r, body)
+		scoped := idempotencyScope(r, fingerprint) + ":" + key
+
+		owner, stored, err := app.Idempotency.Begin(r.Context(), scoped, fingerprint, idempotencyLockTTL)
+		switch {
+		case errors.Is(err, idempotency.ErrInProgress):
+			app.errorJSON(w, err, http.StatusConflict)
+			return
+		case errors.Is(err, idempotency.ErrMismatch):
+			app.errorJSON(w, err, http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			// without the store we cant tell whether this is a retry, so dont risk running it twice
+			log.Println("error checking idempotency key:", err)
+			app.errorJSON(w, errors.New("unable to check the idempotency key, try again later"), http.StatusServiceUnavailable)
+			return
+		case stored != nil:
+			w.Header().Set("Content-Type", stored.ContentType)
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// the request may have been cancelled by now, but the key still needs to be settled
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// only settle the key if we still hold it. a request that ran past idempotencyLockTTL may have lost
+		// it to a retry, whose response is the one to keep
+		if rec.status >= http.StatusInternalServerError {
+			err = app.Idempotency.Release(ctx, scoped, owner)
+		} else {
+			err = app.Idempotency.Complete(ctx, scoped, owner, idempotency.Response{
+				Status:      rec.status,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			}, idempotencyTTL)
+		}
+		if err != nil {
+			log.Println("error storing idempotency key:", err)
+		}
+	})
+}
+
+// the user a key belongs to. requests sent without an access token cant be told apart by who sent them,
+// so their keys belong to the request itself: only someone sending the very same request, e.g. the same
+// email and password to log in with, gets its response back. a key reused for a different anonymous
+// request is then just a new key, rather than a 422
+func idempotencyScope(r *http.Request, fingerprint string) string {
+	if identity, ok := jwtauth.IdentityFrom(r.Context()); ok {
+		return "user-" + strconv.Itoa(identity.UserID)
+	}
+
+	return "anonymous-" + fingerprint
+}
+
+// identifies a request, so that a key sent again with a different request can be caught
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// passes a response through to the client, and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
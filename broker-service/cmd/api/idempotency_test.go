@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jateen67/broker/idempotency"
+	"github.com/jateen67/broker/jwtauth"
+)
+
+func TestIdempotentScopesAnonymousKeysToTheRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		// the bodies of the two requests sent with the same key
+		first, second string
+		// whether both requests are sent with an access token for the same user
+		identified bool
+		wantReplay bool
+		wantStatus int
+	}{
+		{name: "anonymous, same request", first: `{"a": 1}`, second: `{"a": 1}`, wantReplay: true, wantStatus: http.StatusAccepted},
+		{name: "anonymous, different request", first: `{"a": 1}`, second: `{"a": 2}`, wantStatus: http.StatusAccepted},
+		{name: "same user, same request", first: `{"a": 1}`, second: `{"a": 1}`, identified: true, wantReplay: true, wantStatus: http.StatusAccepted},
+		{name: "same user, different request", first: `{"a": 1}`, second: `{"a": 2}`, identified: true, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &Config{Idempotency: idempotency.NewMemoryStore()}
+
+			calls := 0
+			handler := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusAccepted)
+			}))
+
+			send := func(body string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodPost, "/handle", bytes.NewBufferString(body))
+				r.Header.Set(idempotencyHeader, "retry-me")
+				if tt.identified {
+					r = r.WithContext(jwtauth.WithIdentity(r.Context(), jwtauth.Identity{UserID: 1}))
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				return w
+			}
+
+			send(tt.first)
+			w := send(tt.second)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if replayed := w.Header().Get(idempotentReplayedHeader) == "true"; replayed != tt.wantReplay {
+				t.Errorf("replayed = %t, want %t", replayed, tt.wantReplay)
+			}
+
+			wantCalls := 2
+			if tt.wantReplay || tt.wantStatus != http.StatusAccepted {
+				wantCalls = 1
+			}
+			if calls != wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, wantCalls)
+			}
+		})
+	}
+}
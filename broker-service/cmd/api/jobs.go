@@ -0,0 +1,151 @@
+// running /handle requests in the background, for clients that dont want to wait on slow actions like mail
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jateen67/broker/jobs"
+	"github.com/jateen67/broker/jwtauth"
+	"github.com/jateen67/broker/requestid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the queue background jobs wait in, and the workers that run them
+func newJobQueue() *jobs.Queue {
+	return jobs.NewQueue(jobs.Options{
+		Workers:   8,
+		QueueSize: 100,
+		// finished jobs can be looked up for this long
+		Retention: 15 * time.Minute,
+		// enough for the mailer's 25 second timeout, with room to spare
+		Timeout: time.Minute,
+	})
+}
+
+// a job as sent back by /handle?async=true and /jobs/{id}
+type jobView struct {
+	jobs.Job
+	Code int `json:"code,omitempty"` // for failed jobs, the status code /handle would have answered with
+	// for failed jobs, the machine readable code of the error (see problems.go)
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+func newJobView(w http.ResponseWriter, job jobs.Job) jobView {
+	view := jobView{Job: job}
+	if job.Status == jobs.Failed {
+		view.Code = versionedErrorStatus(w, job.Err)
+		view.ErrorCode = actionErrorCode(job.Err)
+	}
+
+	return view
+}
+
+// whether the client asked for the request to be run in the background, with /handle?async=true
+func asyncRequested(r *http.Request) (bool, error) {
+	async := r.URL.Query().Get("async")
+	if async == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(async)
+	if err != nil {
+		return false, fmt.Errorf("async must be true or false, got %q", async)
+	}
+
+	return b, nil
+}
+
+// queue an action to be run in the background and answer straight away with the job, and where to find it
+func (app *Config) submitJob(w http.ResponseWriter, r *http.Request, req RequestPayload) {
+	// turn away requests that would fail anyway, such as ones with an invalid payload, before they take up room in the queue
+	_, _, err := app.Actions.prepare(r.Context(), req)
+	if err != nil {
+		app.actionErrorJSON(w, err)
+		return
+	}
+
+	var owner int
+	if identity, ok := jwtauth.IdentityFrom(r.Context()); ok {
+		owner = identity.UserID
+	}
+
+	// the request is over long before the job runs, so the job gets a context of its own
+	// that carries over what the action needs from the request's
+	requestCtx := r.Context()
+	job, err := app.Jobs.Submit(req.Action, owner, func(ctx context.Context) (any, error) {
+		res, err := app.Actions.dispatch(detachRequest(ctx, requestCtx), req)
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		w.Header().Set("Retry-After", "1")
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, jobs.ErrClosed) {
+		// the broker is shutting down, so another one should take the request
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	location := "/jobs/" + job.ID
+	w.Header().Set("Location", location)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("job accepted, check %s for its result", location),
+		Data:    newJobView(w, job),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// copy the request id, the caller's identity and the trace of a request onto ctx
+func detachRequest(ctx, request context.Context) context.Context {
+	if id := requestid.FromContext(request); id != "" {
+		ctx = requestid.WithID(ctx, id)
+	}
+
+	if identity, ok := jwtauth.IdentityFrom(request); ok {
+		ctx = jwtauth.WithIdentity(ctx, identity)
+	}
+
+	return trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(request))
+}
+
+// method that will be called when we send a get request to "localhost:80/jobs/{id}" (will be mapped to 8080 through docker)
+// shows the status of a background job, and its result or error once it has finished
+func (app *Config) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.Jobs.Get(chi.URLParam(r, "id"))
+
+	// a job submitted by a logged in user can only be seen by that user.
+	// anyone else is told it doesnt exist, rather than that it isnt theirs
+	if ok && job.Owner != 0 {
+		identity, loggedIn := jwtauth.IdentityFrom(r.Context())
+		ok = loggedIn && identity.UserID == job.Owner
+	}
+
+	if !ok {
+		app.errorJSON(w, errors.New("job not found, or it finished too long ago to still be kept"), http.StatusNotFound)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   job.Status == jobs.Failed,
+		Message: fmt.Sprintf("job %s", job.Status),
+		Data:    newJobView(w, job),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,161 @@
+// picks which of the four transports a "log" action is sent to the logger service with
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/jateen67/broker/validate"
+)
+
+// the transports the "log" action can use to reach the logger service
+const (
+	transportHTTP = "http" // json over http to logger-service/log
+	transportRPC  = "rpc"  // net/rpc to logger-service:5001
+	transportGRPC = "grpc" // grpc to logger-service:50001
+	transportAMQP = "amqp" // event pushed to rabbitmq and picked up by the listener service
+)
+
+// used when neither the request nor the LOG_TRANSPORT env variable picks a transport
+const defaultLogTransport = transportAMQP
+
+// the severity of an entry that doesnt name one. it is always allowed
+const defaultSeverity = "INFO"
+
+// the severities allowed when LOG_SEVERITIES isnt set
+var defaultSeverities = []string{"DEBUG", defaultSeverity, "WARNING", "ERROR"}
+
+// events sent over amqp are routed by "log.<severity>", followed by the entry's route if it has one.
+// the listener service binds to the keys it wants with wildcards, e.g. "log.ERROR.#"
+const maxRouteSegments = 4
+
+// a segment of a routing key. dots separate segments, and * and # are wildcards in bindings, so neither can be used
+var routeSegment = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// the severities log entries can have, from the comma separated LOG_SEVERITIES env variable
+func logSeverities() ([]string, error) {
+	value := os.Getenv("LOG_SEVERITIES")
+	if value == "" {
+		return defaultSeverities, nil
+	}
+
+	var severities []string
+	hasDefault := false
+	for _, severity := range strings.Split(value, ",") {
+		severity = strings.TrimSpace(severity)
+		if !routeSegment.MatchString(severity) {
+			return nil, fmt.Errorf("LOG_SEVERITIES: invalid severity %q", severity)
+		}
+		if severity == defaultSeverity {
+			hasDefault = true
+		}
+		severities = append(severities, severity)
+	}
+	if !hasDefault {
+		return nil, fmt.Errorf("LOG_SEVERITIES: must include %s, which entries without a severity get", defaultSeverity)
+	}
+
+	return severities, nil
+}
+
+// add the "severity" and "route" rules the "log" action's payload is checked with (see validate.Rule)
+func registerLogRules(severities []string) {
+	validate.Rule("severity", func(v any) (string, bool) {
+		for _, severity := range severities {
+			if v == severity {
+				return "", true
+			}
+		}
+		return "must be one of " + strings.Join(severities, ", "), false
+	})
+
+	validate.Rule("route", func(v any) (string, bool) {
+		segments := strings.Split(v.(string), ".")
+		if len(segments) > maxRouteSegments {
+			return fmt.Sprintf("must have at most %d segments", maxRouteSegments), false
+		}
+		for _, segment := range segments {
+			if !routeSegment.MatchString(segment) {
+				return "must be dot separated segments of letters, digits, - and _", false
+			}
+		}
+		return "", true
+	})
+}
+
+// the severity of an entry, or the default if it doesnt have one
+func severityOf(l LogPayload) string {
+	if l.Severity == "" {
+		return defaultSeverity
+	}
+
+	return l.Severity
+}
+
+// the routing key an entry is pushed to rabbitmq with, e.g. "log.INFO" or "log.ERROR.auth"
+func routingKey(l LogPayload) string {
+	key := "log." + severityOf(l)
+	if l.Route != "" {
+		key += "." + l.Route
+	}
+
+	return key
+}
+
+// every transport answers with this in the Data field of its response, so they can be compared side by side
+type logResult struct {
+	Transport string `json:"transport"`
+	Result    string `json:"result"` // what the logger service (or rabbitmq) told us
+}
+
+// map of each transport's name to the function that logs through it
+func (app *Config) logTransports() map[string]func(context.Context, LogPayload) (jsonResponse, error) {
+	return map[string]func(context.Context, LogPayload) (jsonResponse, error){
+		transportHTTP: app.logItem,
+		transportRPC:  app.logItemViaRPC,
+		transportGRPC: app.logItemViaGRPC,
+		transportAMQP: app.logEventViaRabbitMQ,
+	}
+}
+
+// check that a transport name is one we know how to use
+func validLogTransport(transport string) error {
+	switch transport {
+	case transportHTTP, transportRPC, transportGRPC, transportAMQP:
+		return nil
+	default:
+		return fmt.Errorf("unknown log transport %q, expected one of: %s", transport,
+			strings.Join([]string{transportHTTP, transportRPC, transportGRPC, transportAMQP}, ", "))
+	}
+}
+
+// handler for the "log" action. uses the transport named in the payload, or the configured default if there isnt one
+func (app *Config) logItemVia(ctx context.Context, l LogPayload) (jsonResponse, error) {
+	transport := l.Transport
+	if transport == "" {
+		transport = app.LogTransport
+	}
+
+	err := validLogTransport(transport)
+	if err != nil {
+		return jsonResponse{}, withStatus(err, http.StatusBadRequest)
+	}
+
+	return app.logTransports()[transport](ctx, l)
+}
+
+// build the response every transport sends back once the entry has been logged
+func logResponse(transport, message, result string) jsonResponse {
+	return jsonResponse{
+		Error:   false,
+		Message: message,
+		Data: logResult{
+			Transport: transport,
+			Result:    result,
+		},
+	}
+}
@@ -0,0 +1,85 @@
+// prometheus metrics for the broker service, served on /metrics
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jateen67/broker/logstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of http requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "How long http requests took to handle, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	brokerActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_actions_total",
+		Help: "Number of actions run by the broker, by action and result (success or failure).",
+	}, []string{"action", "result"})
+)
+
+// counts every request and times how long it took, labelled with the route that matched
+// (e.g. "/handle") rather than the raw path, so the number of series stays bounded
+func (app *Config) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		// a handler that never wrote a status code sent a 200
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// report how many clients are streaming the logs, and how many events they missed by falling behind
+func registerStreamMetrics(hub *logstream.Hub) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "broker_log_stream_subscribers",
+		Help: "Number of clients streaming log events.",
+	}, func() float64 { return float64(hub.Subscribers()) })
+
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "broker_log_stream_dropped_total",
+		Help: "Number of log events dropped because a streaming client couldnt keep up.",
+	}, func() float64 { return float64(hub.Dropped()) })
+}
+
+// count an action that was run. actions that were never registered are counted under "unknown",
+// so that clients sending made-up action names cant create new series
+func recordAction(action string, known bool, err error) {
+	if !known {
+		action = "unknown"
+	}
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	brokerActions.WithLabelValues(action, result).Inc()
+}
@@ -0,0 +1,120 @@
+// middleware used by the broker's routes
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jateen67/broker/jwtauth"
+	"github.com/jateen67/broker/requestid"
+)
+
+// returned when an action that needs a logged in user is called without a valid access token
+var errUnauthenticated = errors.New("a valid access token is required for this action")
+
+// returned when the access token sent with a request cant be used
+var errInvalidToken = errors.New("invalid access token")
+
+// gives every request an id, or keeps the one the client sent in the X-Request-ID header.
+// the id is passed on to every service the request reaches and sent back in the response's X-Request-ID header
+func (app *Config) assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		w.Header().Set(requestid.Header, id)
+
+		next.ServeHTTP(w, r.WithContext(requestid.WithID(r.Context(), id)))
+	})
+}
+
+// verifies the access token in the Authorization header, if there is one, and puts the caller's identity
+// in the request's context. requests without a token, or with one that cant be used (e.g. because it expired),
+// are let through without an identity, because whether one is needed depends on the action; the action registry
+// turns them away unless the action is public. that way a client still sending an old token can log in again
+func (app *Config) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(app.verifyToken(r.Context(), header)))
+	})
+}
+
+// check the "Bearer <token>" sent with a request. the caller's identity goes in the returned context if the token
+// is good, and the reason it was turned down if it isnt (see requireIdentity)
+func (app *Config) verifyToken(ctx context.Context, header string) context.Context {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return context.WithValue(ctx, tokenErrorKey{}, fmt.Errorf("%w: authorization must be of the form 'Bearer <token>'", errInvalidToken))
+	}
+
+	identity, err := app.Verifier.Verify(ctx, token)
+	if err != nil {
+		return context.WithValue(ctx, tokenErrorKey{}, errInvalidToken)
+	}
+
+	return jwtauth.WithIdentity(ctx, identity)
+}
+
+type tokenErrorKey struct{}
+
+// check that the caller sent a valid access token. the error has a 401 status, and says why the token
+// was turned down if one was sent
+func requireIdentity(ctx context.Context) error {
+	if _, ok := jwtauth.IdentityFrom(ctx); ok {
+		return nil
+	}
+
+	if err, ok := ctx.Value(tokenErrorKey{}).(error); ok {
+		return withStatus(err, http.StatusUnauthorized)
+	}
+
+	return withStatus(errUnauthenticated, http.StatusUnauthorized)
+}
+
+// response header naming the version of the api that answered
+const apiVersionHeader = "API-Version"
+
+// tags every response with the version of the api that answered it. errorJSON reads the tag
+// to send errors the way that version does, as problem+json from /v2 on (see problems.go)
+func apiVersion(version string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(apiVersionHeader, version)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// when the unversioned routes were deprecated in favour of /v2, as an rfc 9745 date (the unix time after an "@")
+var v1DeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// unversioned routes that were replaced by a different /v2 route, rather than the same one under /v2
+var v2Successors = map[string]string{
+	// the same as sending the "log" action with the "grpc" transport
+	"/log-grpc": "/v2/handle",
+}
+
+// marks the unversioned routes as deprecated, and points clients at the /v2 route that replaces each of them
+func deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor, ok := v2Successors[r.URL.Path]
+		if !ok {
+			successor = "/v2" + r.URL.Path
+		}
+
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", v1DeprecatedAt.Unix()))
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jateen67/broker/jwtauth"
+)
+
+func TestAuthenticateTokenLetsBadTokensReachPublicActions(t *testing.T) {
+	// no keys are ever fetched, since none of the tokens below get as far as needing one
+	app := &Config{
+		RequireAuth: true,
+		Verifier:    jwtauth.NewVerifier(jwtauth.NewKeySet("http://127.0.0.1:1/jwks", http.DefaultClient)),
+	}
+	app.registerActions()
+
+	tests := []struct {
+		name   string
+		header string
+		action string
+		want   error // nil if the action should be allowed
+	}{
+		{"no token, public action", "", "auth", nil},
+		{"no token, private action", "", "log", errUnauthenticated},
+		{"expired or garbage token, public action", "Bearer not.a.token", "auth", nil},
+		{"expired or garbage token, private action", "Bearer not.a.token", "log", errInvalidToken},
+		{"malformed header, public action", "Basic abc", "auth", nil},
+		{"malformed header, private action", "Basic abc", "mail", errInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got error
+			reached := false
+			handler := app.authenticateToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+				_, got = app.Actions.authorize(r.Context(), tt.action)
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/handle", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if !reached {
+				t.Fatal("the request never reached the handler")
+			}
+			if tt.want == nil && got != nil {
+				t.Fatalf("expected %q to be allowed, got %v", tt.action, got)
+			}
+			if tt.want != nil && !errors.Is(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			if tt.want != nil && actionErrorStatus(got) != http.StatusUnauthorized {
+				t.Fatalf("expected a 401, got %d", actionErrorStatus(got))
+			}
+		})
+	}
+}
+
+func TestRequireIdentity(t *testing.T) {
+	ctx := jwtauth.WithIdentity(context.Background(), jwtauth.Identity{UserID: 1})
+	if err := requireIdentity(ctx); err != nil {
+		t.Fatalf("expected a caller with an identity to be let through, got %v", err)
+	}
+}
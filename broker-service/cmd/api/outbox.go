@@ -0,0 +1,141 @@
+// keeping log events in an outbox on disk while rabbitmq is down, and pushing them once it is back
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jateen67/broker/event"
+	"github.com/jateen67/broker/outbox"
+	"github.com/jateen67/broker/requestid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// used when OUTBOX_PATH isnt set
+const defaultOutboxPath = "outbox.db"
+
+// how long the relay gives rabbitmq to take each event from the outbox
+const relayTimeout = 30 * time.Second
+
+// open the outbox in the file at OUTBOX_PATH. it should be on a volume, so that events still waiting
+// in it when the broker is stopped are pushed by the next one
+func openOutbox() (*outbox.Outbox, error) {
+	path := os.Getenv("OUTBOX_PATH")
+	if path == "" {
+		path = defaultOutboxPath
+	}
+
+	o, err := outbox.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("OUTBOX_PATH: %w", err)
+	}
+
+	return o, nil
+}
+
+// whether events are waiting in the outbox. new events go in behind them, so that they arent pushed out of order
+func (app *Config) outboxWaiting() bool {
+	empty, err := app.Outbox.Empty()
+	if err != nil {
+		log.Println("error reading the outbox:", err)
+		return false
+	}
+
+	return !empty
+}
+
+// put an event in the outbox for the relay to push later. the request's id and trace go with it
+func (app *Config) queueEvent(ctx context.Context, body, key string) (uint64, error) {
+	entry := outbox.Entry{
+		RoutingKey: key,
+		Body:       body,
+		RequestID:  requestid.FromContext(ctx),
+		Trace:      map[string]string{},
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(entry.Trace))
+
+	return app.Outbox.Add(entry)
+}
+
+// push the events in the outbox to rabbitmq, in order, until ctx is done
+func (app *Config) relayOutbox(ctx context.Context) {
+	app.Outbox.Relay(ctx, app.relayEvent, func(err error) bool {
+		// rabbitmq turning an event away wont change by trying again, but anything else might.
+		// an event no queue takes is dropped too, the same as it is reported to the caller when it is pushed
+		// straight away (see logEventViaRabbitMQ): the listener service's queue is durable and outlives it,
+		// so no queue being bound to the event's routing key means nothing is set up to log it
+		var unroutable *event.UnroutableError
+		return !errors.Is(err, event.ErrNacked) && !errors.As(err, &unroutable)
+	})
+}
+
+// push one event from the outbox, waiting for rabbitmq if we arent connected to it
+func (app *Config) relayEvent(ctx context.Context, e outbox.Entry) error {
+	if err := app.Rabbit.Wait(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, relayTimeout)
+	defer cancel()
+
+	// carry on the trace, and the request id, of the request that logged the event
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(e.Trace))
+	if e.RequestID != "" {
+		ctx = requestid.WithID(ctx, e.RequestID)
+	}
+
+	err := app.Emitter.Push(ctx, e.Body, e.RoutingKey)
+	if err == nil {
+		log.Printf("pushed outbox entry %d, logged %s ago\n", e.Seq, time.Since(e.CreatedAt).Round(time.Millisecond))
+	}
+
+	return err
+}
+
+// method that will be called when we send a get request to "localhost:80/outbox" (will be mapped to 8080 through docker).
+// how many log events are waiting in the outbox for rabbitmq, and how long the oldest of them has been waiting
+func (app *Config) OutboxStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.Outbox.Stats()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: strconv.Itoa(stats.Depth) + " events waiting in the outbox",
+		Data:    stats,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// report how far behind the outbox is
+func registerOutboxMetrics(o *outbox.Outbox) {
+	stats := func() outbox.Stats {
+		s, err := o.Stats()
+		if err != nil {
+			log.Println("error reading the outbox:", err)
+		}
+		return s
+	}
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "broker_outbox_depth",
+		Help: "Number of log events waiting in the outbox for rabbitmq.",
+	}, func() float64 { return float64(stats().Depth) })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "broker_outbox_oldest_age_seconds",
+		Help: "How long the oldest log event in the outbox has been waiting, 0 when it is empty.",
+	}, func() float64 { return stats().OldestAge })
+}
@@ -0,0 +1,301 @@
+// stable error codes, and the rfc 7807 problem+json errors the /v2 api sends them in
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jateen67/broker/event"
+	"github.com/jateen67/broker/idempotency"
+	"github.com/jateen67/broker/jobs"
+	"github.com/jateen67/broker/logstream"
+	"github.com/jateen67/broker/requestid"
+	"github.com/jateen67/broker/resilient"
+	"github.com/jateen67/broker/validate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// machine readable codes for every kind of error the broker sends back. clients should match on these rather
+// than on messages, which can change. once a code has been published it must keep its meaning
+const (
+	codeBadRequest            = "bad_request"
+	codeValidationFailed      = "validation_failed"
+	codeUnknownAction         = "unknown_action"
+	codeUnauthenticated       = "unauthenticated"
+	codeInvalidToken          = "invalid_token"
+	codeInvalidCredentials    = "invalid_credentials"
+	codeForbidden             = "forbidden"
+	codeNotFound              = "not_found"
+	codeMethodNotAllowed      = "method_not_allowed"
+	codeConflict              = "conflict"
+	codePayloadTooLarge       = "payload_too_large"
+	codeIdempotencyKeyInUse   = "idempotency_key_in_use"
+	codeIdempotencyKeyReused  = "idempotency_key_reused"
+	codeQueueFull             = "queue_full"
+	codeTooManySubscribers    = "too_many_subscribers"
+	codeInternal              = "internal_error"
+	codeServiceUnavailable    = "service_unavailable"
+	codeDownstreamError       = "downstream_error"
+	codeDownstreamUnavailable = "downstream_unavailable"
+	codeDownstreamTimeout     = "downstream_timeout"
+	codeEventUnroutable       = "event_unroutable"
+)
+
+// a kind of error: its code, the status code it is sent with, and a short summary that doesnt change between occurrences
+type problemType struct {
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+}
+
+// every kind of error, as listed by /v2/problems
+var problemTypes = []problemType{
+	{codeBadRequest, http.StatusBadRequest, "The request is malformed"},
+	{codeValidationFailed, http.StatusUnprocessableEntity, "The payload broke one or more rules"},
+	{codeUnknownAction, http.StatusBadRequest, "No such action"},
+	{codeUnauthenticated, http.StatusUnauthorized, "An access token is required"},
+	{codeInvalidToken, http.StatusUnauthorized, "The access token is invalid"},
+	{codeInvalidCredentials, http.StatusUnauthorized, "The email or password is wrong"},
+	{codeForbidden, http.StatusForbidden, "Not allowed"},
+	{codeNotFound, http.StatusNotFound, "Not found"},
+	{codeMethodNotAllowed, http.StatusMethodNotAllowed, "The route doesnt take this method"},
+	{codeConflict, http.StatusConflict, "The request conflicts with another one"},
+	{codePayloadTooLarge, http.StatusRequestEntityTooLarge, "The request is too large"},
+	{codeIdempotencyKeyInUse, http.StatusConflict, "A request with this idempotency key is still running"},
+	{codeIdempotencyKeyReused, http.StatusUnprocessableEntity, "The idempotency key was already used for a different request"},
+	{codeQueueFull, http.StatusServiceUnavailable, "Too many background jobs are waiting"},
+	{codeTooManySubscribers, http.StatusServiceUnavailable, "Too many clients are streaming the logs"},
+	{codeInternal, http.StatusInternalServerError, "Something went wrong in the broker"},
+	{codeServiceUnavailable, http.StatusServiceUnavailable, "The broker cant take the request right now"},
+	{codeDownstreamError, http.StatusBadGateway, "A downstream service failed the request"},
+	{codeDownstreamUnavailable, http.StatusServiceUnavailable, "A downstream service is unavailable"},
+	{codeDownstreamTimeout, http.StatusGatewayTimeout, "A downstream service took too long to answer"},
+	{codeEventUnroutable, http.StatusServiceUnavailable, "Nothing is listening for the event"},
+}
+
+func lookupProblemType(code string) (problemType, bool) {
+	for _, pt := range problemTypes {
+		if pt.Code == code {
+			return pt, true
+		}
+	}
+
+	return problemType{}, false
+}
+
+// wrap an error so that it is reported with the given code, and the status code that goes with it
+func withCode(err error, code string) error {
+	pt, ok := lookupProblemType(code)
+	if !ok {
+		pt = problemType{Code: code, Status: http.StatusInternalServerError}
+	}
+
+	return &statusError{Status: pt.Status, Code: code, Err: err}
+}
+
+// describeError works out the status code and error code an error is sent back with. status is the status
+// code the caller picked for it, or 0 to work it out from the error, falling back to a 400
+func describeError(err error, status int) (int, string) {
+	var se *statusError
+	if errors.As(err, &se) {
+		if se.Code != "" {
+			return se.Status, se.Code
+		}
+
+		// the action picked the status, but what it wrapped can still have a code of its own
+		_, code := describeError(se.Err, se.Status)
+		return se.Status, code
+	}
+
+	code := knownErrorCode(err)
+	if code == "" {
+		code = codeForStatus(status)
+	}
+
+	if status == 0 {
+		pt, _ := lookupProblemType(code)
+		status = pt.Status
+	}
+
+	return status, code
+}
+
+// the code of an error the broker, or something it calls, is known to return
+func knownErrorCode(err error) string {
+	var unknown *unknownActionError
+	var invalid validate.Errors
+	var netErr net.Error
+	var unroutable *event.UnroutableError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &unknown):
+		return codeUnknownAction
+	case errors.As(err, &invalid):
+		return codeValidationFailed
+	case errors.Is(err, errUnauthenticated):
+		return codeUnauthenticated
+	case errors.Is(err, errInvalidToken):
+		return codeInvalidToken
+	case errors.Is(err, errInvalidCredentials):
+		return codeInvalidCredentials
+	case errors.Is(err, idempotency.ErrInProgress):
+		return codeIdempotencyKeyInUse
+	case errors.Is(err, idempotency.ErrMismatch):
+		return codeIdempotencyKeyReused
+	case errors.Is(err, jobs.ErrQueueFull):
+		return codeQueueFull
+	case errors.Is(err, logstream.ErrTooManySubscribers):
+		return codeTooManySubscribers
+	case errors.Is(err, resilient.ErrCircuitOpen), errors.Is(err, rpc.ErrShutdown), errors.Is(err, event.ErrNotConnected):
+		return codeDownstreamUnavailable
+	case errors.As(err, &unroutable):
+		return codeEventUnroutable
+	case errors.Is(err, event.ErrNacked):
+		return codeDownstreamError
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, event.ErrConfirmTimeout):
+		return codeDownstreamTimeout
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return codeDownstreamTimeout
+		}
+		return codeDownstreamUnavailable
+	}
+
+	// errors from the logger's grpc server
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		switch st.Code() {
+		case codes.Unavailable:
+			return codeDownstreamUnavailable
+		case codes.DeadlineExceeded:
+			return codeDownstreamTimeout
+		default:
+			return codeDownstreamError
+		}
+	}
+
+	return ""
+}
+
+// the code for an error that is only known by its status code
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusRequestEntityTooLarge:
+		return codePayloadTooLarge
+	case http.StatusUnprocessableEntity:
+		return codeValidationFailed
+	case http.StatusBadGateway:
+		return codeDownstreamError
+	case http.StatusServiceUnavailable:
+		return codeServiceUnavailable
+	case http.StatusGatewayTimeout:
+		return codeDownstreamTimeout
+	}
+
+	if status >= http.StatusInternalServerError {
+		return codeInternal
+	}
+
+	return codeBadRequest
+}
+
+// an error sent back by the /v2 api, as described by rfc 7807
+type problem struct {
+	// identifies the kind of error. it can be fetched for a description of it
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// what went wrong this time
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// for validation_failed, every field that broke a rule
+	Errors []validate.FieldError `json:"errors,omitempty"`
+	// for unknown_action, the actions that do exist
+	AvailableActions []string `json:"available_actions,omitempty"`
+}
+
+const problemContentType = "application/problem+json"
+
+func newProblem(err error, status int) problem {
+	status, code := describeError(err, status)
+
+	pt, ok := lookupProblemType(code)
+	if !ok {
+		pt.Title = http.StatusText(status)
+	}
+
+	p := problem{
+		Type:   "/v2/problems/" + code,
+		Title:  pt.Title,
+		Status: status,
+		Detail: err.Error(),
+		Code:   code,
+	}
+
+	var invalid validate.Errors
+	if errors.As(err, &invalid) {
+		p.Errors = invalid
+	}
+
+	var unknown *unknownActionError
+	if errors.As(err, &unknown) {
+		p.AvailableActions = unknown.Available
+	}
+
+	return p
+}
+
+// write an error as problem+json
+func (app *Config) writeProblem(w http.ResponseWriter, err error, status int) error {
+	p := newProblem(err, status)
+	// set by assignRequestID on every response
+	p.RequestID = w.Header().Get(requestid.Header)
+
+	return app.writeJSON(w, p.Status, p, http.Header{"Content-Type": {problemContentType}})
+}
+
+// method that will be called when we send a get request to "localhost:80/v2/problems" (will be mapped to 8080 through docker)
+// lists every kind of error the api can send back
+func (app *Config) ListProblems(w http.ResponseWriter, r *http.Request) {
+	payload := jsonResponse{
+		Error:   false,
+		Message: "problem types",
+		Data:    problemTypes,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// method that will be called when we send a get request to "localhost:80/v2/problems/{code}", the type of a problem
+func (app *Config) GetProblem(w http.ResponseWriter, r *http.Request) {
+	pt, ok := lookupProblemType(chi.URLParam(r, "code"))
+	if !ok {
+		app.errorJSON(w, errors.New("no such problem type"), http.StatusNotFound)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: pt.Title,
+		Data:    pt,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -2,22 +2,28 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/jateen67/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
+	// start a span for every request, continuing the caller's trace if it sent one
+	mux.Use(tracing.Middleware(serviceName))
+
 	// specify who is able to connect
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Request-ID", "Idempotency-Key"},
+		ExposedHeaders:   []string{"Link", "Location", "X-Request-ID", "Idempotent-Replayed", "API-Version", "Deprecation"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -25,15 +31,92 @@ func (app *Config) routes() http.Handler {
 	// easily make sure that the service is running by hitting the endpoint to get a response
 	mux.Use(middleware.Heartbeat("/ping"))
 
-	// add routes that use handlers, which will be called when we access these routes
-	// post request to localhost:80 will run the Broker method (will be mapped to 8080 through docker)
-	mux.Post("/", app.Broker)
+	// count and time every request (see metrics.go)
+	mux.Use(app.recordMetrics)
+
+	// tag every request with an id that follows it through all of the other services
+	mux.Use(app.assignRequestID)
+
+	// the first version of the api, which sends errors in a jsonResponse. it still works, but is deprecated in favour of /v2
+	mux.Group(func(mux chi.Router) {
+		mux.Use(apiVersion("1"))
+		mux.Use(deprecated)
+
+		// post request to localhost:80 will run the Broker method (will be mapped to 8080 through docker)
+		mux.Post("/", app.Broker)
+
+		// grpc route just for ease of reference
+		mux.With(app.authenticateToken).Post("/log-grpc", app.LogItemViaGRPC)
+
+		app.apiRoutes(mux)
+	})
+
+	// the current version of the api. the same routes, but errors are sent as problem+json with a stable code (see problems.go)
+	mux.Route("/v2", func(mux chi.Router) {
+		mux.Use(apiVersion("2"))
+
+		mux.Post("/", app.Broker)
+		app.apiRoutes(mux)
+
+		// every kind of error the api sends back, by code
+		mux.Get("/problems", app.ListProblems)
+		mux.Get("/problems/{code}", app.GetProblem)
+
+		// so that even a wrong url gets a problem back
+		mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			app.errorJSON(w, fmt.Errorf("no route for %s", r.URL.Path), http.StatusNotFound)
+		})
+		mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+			app.errorJSON(w, fmt.Errorf("%s is not allowed on %s", r.Method, r.URL.Path), http.StatusMethodNotAllowed)
+		})
+	})
 
-	// grpc route just for ease of reference
-	mux.Post("/log-grpc", app.LogItemViaGRPC)
+	// prometheus metrics
+	mux.Handle("/metrics", promhttp.Handler())
 
-	// a single point of entry that will handle all requests from all other microservices
-	mux.Post("/handle", app.HandleSubmission)
+	// state of the circuit breakers guarding calls to the other services
+	mux.Get("/breakers", app.Breakers)
+
+	// the broker and every dependency it has, checked at once (see health.go).
+	// orchestrators should use /health/live to decide when to restart the broker,
+	// and /health/ready to decide whether to send it traffic
+	mux.Get("/health", app.Health)
+	mux.Get("/health/live", app.Liveness)
+	mux.Get("/health/ready", app.Readiness)
+
+	// instances of the downstream services, and which of them have been ejected
+	mux.Get("/services", app.Services)
+
+	// how many log events are waiting for rabbitmq to come back, and for how long (see outbox.go)
+	mux.Get("/outbox", app.OutboxStats)
 
 	return mux
 }
+
+// the routes every version of the api has
+func (app *Config) apiRoutes(mux chi.Router) {
+	// routes that run actions; the caller's access token is checked before the action runs
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.authenticateToken)
+
+		// a single point of entry that will handle all requests from all other microservices
+		// retries that send the same Idempotency-Key header are only run once (see idempotency.go),
+		// and a sample of the requests can be recorded to be replayed later (see capture.go)
+		mux.With(app.captureTraffic, app.idempotent).Post("/handle", app.HandleSubmission)
+
+		// run many of the same requests that /handle takes in one call
+		mux.With(app.captureTraffic, app.idempotent).Post("/handle/batch", app.HandleBatch)
+
+		// status and result of a request sent to /handle?async=true
+		mux.Get("/jobs/{id}", app.GetJob)
+	})
+
+	// log events as they happen, over server-sent events or a websocket (see stream.go)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(tokenFromQuery)
+		mux.Use(app.authenticateToken)
+
+		mux.Get("/logs/stream", app.StreamLogs)
+		mux.Get("/logs/ws", app.StreamLogsWS)
+	})
+}
@@ -0,0 +1,83 @@
+// stopping the broker without dropping the requests it is in the middle of
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// how long in-flight work gets to finish once the broker is told to stop, before it is cut off.
+// docker compose waits 30 seconds (see stop_grace_period) before killing us, which leaves time to close connections
+const shutdownTimeout = 20 * time.Second
+
+// shutdown stops the broker taking new work, then waits for the http requests, grpc calls and background jobs
+// it is running to finish. whatever is still running after shutdownTimeout is cut off
+func (app *Config) shutdown(srv *http.Server, grpcServer *grpc.Server, grpcHealth *health.Server) {
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// stop /health/ready and the grpc health service from sending new traffic our way
+	app.draining.Store(true)
+	grpcHealth.Shutdown()
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	// closes the listener, so no new requests come in, then waits for the ones being handled.
+	// log streams end as soon as this starts (see RegisterOnShutdown in main.go)
+	go func() {
+		defer wg.Done()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("error draining http requests:", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		stopGRPC(ctx, grpcServer)
+	}()
+
+	// jobs are accepted with a 202, so the client has been promised they will run
+	go func() {
+		defer wg.Done()
+		if err := app.Jobs.Shutdown(ctx); err != nil {
+			log.Println("error draining background jobs:", err)
+		}
+	}()
+
+	wg.Wait()
+
+	log.Printf("drained in-flight work in %s", time.Since(start).Round(time.Millisecond))
+}
+
+// stopGRPC stops the grpc server taking new calls and waits for the calls it is running to finish.
+// if ctx is done first, the calls that are left are cut off
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Println("error draining grpc calls:", ctx.Err())
+		s.Stop()
+		<-stopped
+	}
+}
+
+// a server that stopped because it was shut down isnt an error
+func serverStopped(err error) bool {
+	return err == nil || errors.Is(err, http.ErrServerClosed) || errors.Is(err, grpc.ErrServerStopped)
+}
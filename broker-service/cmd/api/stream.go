@@ -0,0 +1,299 @@
+// streaming log events to clients as they happen, over server-sent events or websockets
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jateen67/broker/event"
+	"github.com/jateen67/broker/logstream"
+)
+
+const (
+	// the tail binds to every log event; each client then picks the ones it wants with ?topic=
+	tailTopic = "log.#"
+	// how long to wait before binding the tail again after it failed for some other reason than rabbitmq going away
+	tailRetry = 5 * time.Second
+
+	// how often an idle stream gets a keep-alive, so that proxies dont close it
+	streamHeartbeat = 15 * time.Second
+	// a client that takes longer than this to take a write is too slow, and gets disconnected
+	streamWriteTimeout = 10 * time.Second
+)
+
+// hand every log event pushed to rabbitmq to the clients streaming them, until ctx is done.
+// the tail is only bound while someone is streaming, so that copies of the events dont pile up in rabbitmq
+// for nobody. it binds to logs_tail rather than logs_topic, so it never hides that the listener service isnt there
+// to take an event (see event.EmitterOptions)
+func (app *Config) tailLogs(ctx context.Context) {
+	tail := event.NewTail(app.Rabbit)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-app.LogStream.Watched():
+		}
+
+		// while rabbitmq is down, wait for the connection to come back rather than retrying
+		err := app.Rabbit.Wait(ctx)
+		if err == nil {
+			err = app.tailUntilIdle(ctx, tail)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// everyone stopped streaming
+			continue
+		}
+
+		log.Println("error tailing logs, retrying:", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tailRetry):
+		}
+	}
+}
+
+// listen to the tail until ctx is done or the last client stops streaming
+func (app *Config) tailUntilIdle(ctx context.Context, tail event.Tail) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-app.LogStream.Idle():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return tail.Listen(ctx, []string{tailTopic}, func(msg event.Message) {
+		app.LogStream.Publish(logstream.Event{
+			Topic:     msg.RoutingKey,
+			Name:      msg.Payload.Name,
+			Data:      msg.Payload.Data,
+			RequestID: msg.RequestID,
+			Time:      msg.Timestamp,
+		})
+	})
+}
+
+// browsers cant set headers on an EventSource or a WebSocket, so the streams also take the
+// access token as ?access_token=. it is moved into the Authorization header for authenticateToken
+func tokenFromQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// subscribe to the topics the client asked for with ?topic=, e.g. ?topic=log.ERROR&topic=log.WARNING.
+// topics can use the same wildcards as rabbitmq bindings, and default to every log event
+func (app *Config) subscribe(w http.ResponseWriter, r *http.Request) (*logstream.Subscription, bool) {
+	if app.RequireAuth {
+		if err := requireIdentity(r.Context()); err != nil {
+			app.actionErrorJSON(w, err)
+			return nil, false
+		}
+	}
+
+	var topics []string
+	for _, value := range r.URL.Query()["topic"] {
+		for _, topic := range strings.Split(value, ",") {
+			topic = strings.TrimSpace(topic)
+			if topic == "" {
+				continue
+			}
+
+			if err := logstream.ValidPattern(topic); err != nil {
+				app.errorJSON(w, err)
+				return nil, false
+			}
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 {
+		topics = []string{tailTopic}
+	}
+
+	sub, err := app.LogStream.Subscribe(topics)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	return sub, true
+}
+
+// sent to a client that couldnt keep up, in place of the events it missed
+type droppedNotice struct {
+	Dropped uint64 `json:"dropped"`
+}
+
+// method that will be called when we send a get request to "localhost:80/logs/stream" (will be mapped to 8080 through docker)
+// streams log events as server-sent events. every event is sent as a "log" event, and a client that falls
+// behind gets a "dropped" event saying how many it missed
+func (app *Config) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	sub, ok := app.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// stop nginx and the like from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	rc := http.NewResponseController(w)
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if dropped := sub.Dropped(); dropped > 0 {
+				err = writeSSE(w, "dropped", droppedNotice{Dropped: dropped})
+			}
+			if err == nil {
+				err = writeSSE(w, "log", ev)
+			}
+		}
+
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, name string, data any) error {
+	out, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, out)
+	return err
+}
+
+// what a websocket client gets: either a log event, or a notice of how many events it missed
+type streamMessage struct {
+	Type    string           `json:"type"`
+	Event   *logstream.Event `json:"event,omitempty"`
+	Dropped uint64           `json:"dropped,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// the cors middleware lets every origin call the broker, and the access token is checked either way
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// method that will be called when we open a websocket to "localhost:80/logs/ws" (will be mapped to 8080 through docker)
+// streams the same events as /logs/stream, one json message per event
+func (app *Config) StreamLogsWS(w http.ResponseWriter, r *http.Request) {
+	sub, ok := app.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already sent the client an error
+		return
+	}
+	defer conn.Close()
+
+	// clients only send control messages, but they still need reading for pongs and close frames to be seen.
+	// a client that stops answering pings is gone
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+		})
+
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+		case ev, ok := <-sub.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "broker is shutting down"),
+					time.Now().Add(streamWriteTimeout))
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if dropped := sub.Dropped(); dropped > 0 {
+				err = conn.WriteJSON(streamMessage{Type: "dropped", Dropped: dropped})
+			}
+			if err == nil {
+				err = conn.WriteJSON(streamMessage{Type: "log", Event: &ev})
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import "go.opentelemetry.io/otel"
+
+// name our spans are reported under (see the tracing module for how they are exported)
+const serviceName = "broker-service"
+
+// tracer used for the spans we start by hand (actions, rpc calls, rabbitmq publishes)
+var tracer = otel.Tracer("github.com/jateen67/broker")
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jateen67/broker/requestid"
+)
+
+// talks to the broker's /v2 api, which reports errors as problem+json
+type client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+// the broker's answer to a request
+type response struct {
+	Status    int
+	RequestID string
+	// the json the broker sent back, as it was sent
+	Body json.RawMessage
+	// how long the broker took to answer
+	Took time.Duration
+}
+
+// the jsonResponse every successful request gets back
+type brokerResponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// the problem+json every failed request gets back (see the broker's problems.go)
+type problem struct {
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Code   string `json:"code"`
+	Errors []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (p *problem) Error() string {
+	msg := p.Title
+	if p.Detail != "" {
+		msg = p.Detail
+	}
+	if p.Code != "" {
+		msg += " (" + p.Code + ")"
+	}
+	for _, e := range p.Errors {
+		msg += fmt.Sprintf("\n  %s: %s", e.Field, e.Message)
+	}
+
+	return msg
+}
+
+// a request to the broker in the envelope /handle takes, e.g. {"action": "log", "log": {...}}
+func envelope(action string, payload any) map[string]any {
+	return map[string]any{"action": action, action: payload}
+}
+
+// send body, encoded as json, to path on the broker. a response with an error status is returned
+// along with a *problem describing it
+func (c *client) do(method, path string, body any) (*response, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	start := time.Now()
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &response{
+		Status:    res.StatusCode,
+		RequestID: res.Header.Get(requestid.Header),
+		Body:      b,
+		Took:      time.Since(start),
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		p := &problem{Status: res.StatusCode, Title: http.StatusText(res.StatusCode)}
+		if strings.Contains(res.Header.Get("Content-Type"), "json") {
+			_ = json.Unmarshal(b, p)
+		}
+		return r, p
+	}
+
+	return r, nil
+}
+
+// run a single action through /v2/handle
+func (c *client) handle(action string, payload any) (*response, error) {
+	return c.do(http.MethodPost, "/v2/handle", envelope(action, payload))
+}
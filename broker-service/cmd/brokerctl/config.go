@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// the broker of a local docker compose setup (see project/docker-compose.yml)
+const defaultURL = "http://localhost:8080"
+
+// where to find a broker, and how to talk to it
+type profile struct {
+	URL   string `json:"url"`
+	Token string `json:"token,omitempty"`
+	// "table" or "json"
+	Output string `json:"output,omitempty"`
+}
+
+// the config file, holding a profile for every environment, e.g. "local" and "staging"
+type config struct {
+	// the profile used when -profile isnt given
+	Current  string              `json:"current,omitempty"`
+	Profiles map[string]*profile `json:"profiles"`
+
+	path string
+}
+
+// the config file is $BROKERCTL_CONFIG, or brokerctl/config.json in the user's config directory
+func configPath() (string, error) {
+	if path := os.Getenv("BROKERCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "brokerctl", "config.json"), nil
+}
+
+// read the config file. one that doesnt exist yet is empty
+func loadConfig() (*config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config{Profiles: map[string]*profile{}, path: path}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*profile{}
+	}
+
+	return cfg, nil
+}
+
+// write the config file back. it can hold access tokens, so only the user can read it
+func (c *config) save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, append(b, '\n'), 0o600)
+}
+
+// the profile called name, or the current one if name is empty. with no profiles at all, the default broker is used
+func (c *config) profile(name string) (string, profile, error) {
+	if name == "" {
+		name = c.Current
+	}
+	if name == "" {
+		return "", profile{URL: defaultURL}, nil
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return "", profile{}, fmt.Errorf("no profile called %q in %s", name, c.path)
+	}
+
+	return name, *p, nil
+}
+
+func (c *config) names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// point BROKERCTL_CONFIG at a config file that doesnt exist yet
+func tempConfig(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "brokerctl", "config.json")
+	t.Setenv("BROKERCTL_CONFIG", path)
+
+	return path
+}
+
+func TestLoadConfigWithoutAFile(t *testing.T) {
+	tempConfig(t)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, p, err := cfg.profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "" || p != (profile{URL: defaultURL}) {
+		t.Fatalf("profile = %q, %+v, want the default broker", name, p)
+	}
+	if _, _, err := cfg.profile("staging"); err == nil {
+		t.Fatal("got a profile that doesnt exist")
+	}
+}
+
+func TestConfigSaveAndLoad(t *testing.T) {
+	path := tempConfig(t)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Current = "staging"
+	cfg.Profiles["local"] = &profile{URL: defaultURL}
+	cfg.Profiles["staging"] = &profile{URL: "https://broker.staging", Token: "secret", Output: outputJSON}
+	if err := cfg.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// it holds tokens, so nobody else gets to read it
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("saved with %v, want -rw-------", perm)
+	}
+
+	loaded, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, cfg) {
+		t.Fatalf("loaded %+v, want %+v", loaded, cfg)
+	}
+	if got := loaded.names(); !reflect.DeepEqual(got, []string{"local", "staging"}) {
+		t.Fatalf("names = %v", got)
+	}
+
+	// the current profile is used unless another is asked for
+	name, p, err := loaded.profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "staging" || p.URL != "https://broker.staging" || p.Token != "secret" {
+		t.Fatalf("profile = %q, %+v, want staging", name, p)
+	}
+	if name, p, err = loaded.profile("local"); err != nil || name != "local" || p.URL != defaultURL {
+		t.Fatalf("profile(local) = %q, %+v, %v", name, p, err)
+	}
+}
+
+func TestLoadConfigBadFile(t *testing.T) {
+	path := tempConfig(t)
+	os.MkdirAll(filepath.Dir(path), 0o700)
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("err = %v, want one naming %s", err, path)
+	}
+}
+
+func TestProfileCommands(t *testing.T) {
+	tempConfig(t)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first profile becomes the current one
+	if err := runProfile(cfg, []string{"set", "local"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runProfile(cfg, []string{"set", "staging", "-url", "https://broker.staging", "-o", "json"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Current != "local" {
+		t.Fatalf("current = %q, want the first profile", cfg.Current)
+	}
+
+	// changing a profile keeps what isnt changed
+	if err := runProfile(cfg, []string{"set", "staging", "-token", "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runProfile(cfg, []string{"use", "staging"}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, p, err := loaded.profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (profile{URL: "https://broker.staging", Token: "secret", Output: outputJSON}); name != "staging" || p != want {
+		t.Fatalf("profile = %q, %+v, want staging, %+v", name, p, want)
+	}
+
+	// deleting the current profile goes back to the default broker
+	if err := runProfile(loaded, []string{"delete", "staging"}); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Current != "" || !reflect.DeepEqual(loaded.names(), []string{"local"}) {
+		t.Fatalf("after deleting staging: current %q, profiles %v", loaded.Current, loaded.names())
+	}
+	if _, p, _ := loaded.profile(""); p.URL != defaultURL {
+		t.Fatalf("profile = %+v, want the default broker", p)
+	}
+
+	for _, args := range [][]string{
+		{"use", "prod"},
+		{"delete", "prod"},
+		{"set", "prod", "-o", "yaml"},
+		{"set", "prod", "-url", ""},
+	} {
+		if err := runProfile(loaded, args); err == nil {
+			t.Errorf("profile %s didnt fail", strings.Join(args, " "))
+		}
+	}
+	if _, ok := loaded.Profiles["prod"]; ok {
+		t.Fatal("a profile that failed to be set was saved")
+	}
+}
@@ -0,0 +1,441 @@
+// brokerctl runs the broker's actions from the command line, instead of hand crafting curl requests to /handle.
+//
+//	brokerctl ping
+//	brokerctl auth -email admin@example.com -save
+//	pass show broker | brokerctl auth -email admin@example.com -password-stdin
+//	brokerctl log -transport grpc -severity ERROR -route auth "login failed" "wrong password for admin@example.com"
+//	brokerctl mail -to you@there.com -subject hello "hi there"
+//	brokerctl batch -mode fail-fast < payloads.ndjson
+//	brokerctl -profile staging -o json log event "something happened"
+//
+// brokers are reached through profiles kept in $BROKERCTL_CONFIG (brokerctl/config.json in the user's config
+// directory by default), managed with "brokerctl profile". without any, the local docker compose broker is used
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const usage = `usage: brokerctl [flags] <command> [command flags] [args]
+
+commands:
+  ping      check that the broker is up, and how long it takes to answer
+  auth      log in, and optionally save the access token to the profile
+  log       write a log entry, over http, rpc, grpc or amqp
+  mail      send an email
+  batch     run a batch of /handle payloads read from stdin, as a json array or one per line
+  profile   list, add, change, pick or delete profiles
+
+run "brokerctl <command> -h" for the command's flags
+
+flags:
+`
+
+// everything a command needs: where the broker is and how to print what it says
+type env struct {
+	cfg    *config
+	name   string // the profile in use, "" if there isnt one
+	output string
+	client *client
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	profileName := flag.String("profile", os.Getenv("BROKERCTL_PROFILE"), "profile to use (default $BROKERCTL_PROFILE, then the current profile)")
+	brokerURL := flag.String("url", "", "url of the broker, instead of the profile's")
+	token := flag.String("token", "", "access token to send, instead of the profile's")
+	output := flag.String("o", "", `how to print results, "table" or "json" (default the profile's, then "table")`)
+	timeout := flag.Duration("timeout", 30*time.Second, "how long to wait for the broker")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	// profiles can be managed without picking one
+	if command == "profile" {
+		err := runProfile(cfg, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	name, p, err := cfg.profile(*profileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if *brokerURL != "" {
+		p.URL = *brokerURL
+	}
+	if *token != "" {
+		p.Token = *token
+	}
+	if *output != "" {
+		p.Output = *output
+	}
+	if p.Output == "" {
+		p.Output = outputTable
+	}
+	if err := validOutput(p.Output); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+
+	e := &env{
+		cfg:    cfg,
+		name:   name,
+		output: p.Output,
+		client: &client{
+			url:   strings.TrimSuffix(p.URL, "/"),
+			token: p.Token,
+			http:  &http.Client{Timeout: *timeout},
+		},
+	}
+
+	commands := map[string]func(*env, []string){
+		"ping":  runPing,
+		"auth":  runAuth,
+		"log":   runLog,
+		"mail":  runMail,
+		"batch": runBatch,
+	}
+
+	run, ok := commands[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+	run(e, args)
+}
+
+// print a successful response the way -o asks for
+func (e *env) print(r *response) {
+	if e.output == outputJSON {
+		printJSON(os.Stdout, r.Body)
+		return
+	}
+
+	printTable(os.Stdout, r)
+}
+
+// a flag set for a command, whose usage names the command and its arguments
+func commandFlags(name, args, about string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: brokerctl %s [flags] %s\n\n%s\n\nflags:\n", name, args, about)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+func runPing(e *env, args []string) {
+	fs := commandFlags("ping", "", "calls the broker's /ping heartbeat, which answers without calling any other service")
+	fs.Parse(args)
+
+	r, err := e.client.do(http.MethodGet, "/ping", nil)
+	if err != nil {
+		fail(e.output, r, err)
+	}
+
+	if e.output == outputJSON {
+		out, _ := json.Marshal(map[string]any{
+			"url":     e.client.url,
+			"status":  r.Status,
+			"took_ms": float64(r.Took.Microseconds()) / 1000,
+		})
+		printJSON(os.Stdout, out)
+		return
+	}
+
+	fmt.Printf("%s answered %d in %s\n", e.client.url, r.Status, r.Took.Round(time.Millisecond))
+}
+
+func runAuth(e *env, args []string) {
+	fs := commandFlags("auth", "", `logs in with an email and password, and prints the user and their tokens.
+the password is read from stdin with -password-stdin, then $BROKER_PASSWORD, and is otherwise asked for`)
+	email := fs.String("email", "", "email to log in with")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	save := fs.Bool("save", false, "save the access token to the profile, so that later commands send it")
+	fs.Parse(args)
+
+	if *email == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	password, err := readPassword(*passwordStdin)
+	if err != nil {
+		fail(e.output, nil, err)
+	}
+
+	r, err := e.client.handle("auth", map[string]string{"email": *email, "password": password})
+	if err != nil {
+		fail(e.output, r, err)
+	}
+
+	if *save {
+		if err := e.saveToken(r); err != nil {
+			fail(e.output, nil, err)
+		}
+	}
+
+	e.print(r)
+}
+
+// the password to log in with. there is no flag that takes it, so that it never shows up in ps or the shell's history
+func readPassword(fromStdin bool) (string, error) {
+	if fromStdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		password := strings.TrimRight(string(b), "\r\n")
+		if password == "" {
+			return "", errors.New("no password on stdin")
+		}
+		return password, nil
+	}
+
+	if password := os.Getenv("BROKER_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no password, pipe it in with -password-stdin or set $BROKER_PASSWORD")
+	}
+
+	// ask for it without echoing what is typed
+	fmt.Fprint(os.Stderr, "password: ")
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", errors.New("no password given")
+	}
+
+	return string(b), nil
+}
+
+// keep the access token from a login in the profile in use
+func (e *env) saveToken(r *response) error {
+	var res struct {
+		Data struct {
+			AccessToken string `json:"access_token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(r.Body, &res); err != nil || res.Data.AccessToken == "" {
+		return errors.New("the broker didnt send back an access token")
+	}
+	if e.name == "" {
+		return errors.New(`no profile to save the token to, add one with "brokerctl profile set"`)
+	}
+
+	e.cfg.Profiles[e.name].Token = res.Data.AccessToken
+	if err := e.cfg.save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "saved the access token to profile %q\n", e.name)
+	return nil
+}
+
+func runLog(e *env, args []string) {
+	fs := commandFlags("log", "<name> [data...]", "writes a log entry through the broker. the data is the rest of the arguments, or stdin if there are none")
+	transport := fs.String("transport", "", `how the broker reaches the logger service: "http", "rpc", "grpc" (what /log-grpc did) or "amqp" (default the broker's)`)
+	severity := fs.String("severity", "", "severity of the entry, e.g. ERROR (default INFO)")
+	route := fs.String("route", "", `extra segments for the routing key of an event sent over amqp, e.g. "auth"`)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := argsOrStdin(fs.Args()[1:])
+	if err != nil {
+		fail(e.output, nil, err)
+	}
+
+	r, err := e.client.handle("log", map[string]string{
+		"name":      fs.Arg(0),
+		"data":      data,
+		"transport": *transport,
+		"severity":  *severity,
+		"route":     *route,
+	})
+	if err != nil {
+		fail(e.output, r, err)
+	}
+
+	e.print(r)
+}
+
+func runMail(e *env, args []string) {
+	fs := commandFlags("mail", "[message...]", "sends an email through the broker. the message is the arguments, or stdin if there are none")
+	from := fs.String("from", "", "sender (default the mail service's own address)")
+	to := fs.String("to", "", "recipient")
+	subject := fs.String("subject", "", "subject line")
+	fs.Parse(args)
+
+	if *to == "" || *subject == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	message, err := argsOrStdin(fs.Args())
+	if err != nil {
+		fail(e.output, nil, err)
+	}
+
+	r, err := e.client.handle("mail", map[string]string{
+		"from":    *from,
+		"to":      *to,
+		"subject": *subject,
+		"message": message,
+	})
+	if err != nil {
+		fail(e.output, r, err)
+	}
+
+	e.print(r)
+}
+
+func runBatch(e *env, args []string) {
+	fs := commandFlags("batch", "< payloads", `runs the /handle payloads read from stdin, e.g. {"action": "log", "log": {"name": "event", "data": "hi"}},
+given as a json array or one per line`)
+	mode := fs.String("mode", "", `"best-effort" to run every item, or "fail-fast" to stop at the first failure (default best-effort)`)
+	ordered := fs.Bool("ordered", false, "run the items one after the other, in order")
+	concurrency := fs.Int("concurrency", 0, "how many items run at once (default the broker's)")
+	fs.Parse(args)
+
+	items, err := readPayloads(os.Stdin)
+	if err != nil {
+		fail(e.output, nil, err)
+	}
+	if len(items) == 0 {
+		fail(e.output, nil, errors.New("no payloads on stdin"))
+	}
+
+	query := url.Values{}
+	if *mode != "" {
+		query.Set("mode", *mode)
+	}
+	if *ordered {
+		query.Set("ordered", "true")
+	}
+	if *concurrency > 0 {
+		query.Set("concurrency", strconv.Itoa(*concurrency))
+	}
+
+	path := "/v2/handle/batch"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	r, err := e.client.do(http.MethodPost, path, items)
+	if err != nil {
+		fail(e.output, r, err)
+	}
+
+	if e.output == outputJSON {
+		printJSON(os.Stdout, r.Body)
+		return
+	}
+	printBatchTable(os.Stdout, r)
+}
+
+// read payloads given as a json array, or as json values one after another (e.g. one per line)
+func readPayloads(r io.Reader) ([]json.RawMessage, error) {
+	br := bufio.NewReader(r)
+
+	// peek past any leading whitespace to see which it is
+	for {
+		b, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	dec := json.NewDecoder(br)
+
+	if b, _ := br.Peek(1); b[0] == '[' {
+		var items []json.RawMessage
+		if err := dec.Decode(&items); err != nil {
+			return nil, fmt.Errorf("reading payloads: %w", err)
+		}
+		return items, nil
+	}
+
+	var items []json.RawMessage
+	for {
+		var item json.RawMessage
+		err := dec.Decode(&item)
+		if errors.Is(err, io.EOF) {
+			return items, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading payload %d: %w", len(items)+1, err)
+		}
+		items = append(items, item)
+	}
+}
+
+// the arguments joined with spaces, or everything piped to stdin if there arent any
+func argsOrStdin(args []string) (string, error) {
+	if len(args) > 0 {
+		return strings.Join(args, " "), nil
+	}
+
+	// dont wait for someone to type it
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return "", nil
+	}
+
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
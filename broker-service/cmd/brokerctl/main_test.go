@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "array", input: `[{"action":"log"}, {"action":"mail"}]`, want: []string{`{"action":"log"}`, `{"action":"mail"}`}},
+		{name: "array after whitespace", input: "\n\t  [{\"action\":\"log\"}]\n", want: []string{`{"action":"log"}`}},
+		{name: "empty array", input: `[]`, want: []string{}},
+		{name: "one per line", input: "{\"action\":\"log\"}\n{\"action\":\"mail\"}\n", want: []string{`{"action":"log"}`, `{"action":"mail"}`}},
+		{name: "one after another", input: `{"action":"log"} {"action":"mail"}`, want: []string{`{"action":"log"}`, `{"action":"mail"}`}},
+		{name: "nothing", input: "", want: nil},
+		{name: "only whitespace", input: " \n\n", want: nil},
+		{name: "bad array", input: `[{"action":"log"},`, wantErr: true},
+		{name: "bad line", input: "{\"action\":\"log\"}\n{\"action\":\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := readPayloads(strings.NewReader(tt.input))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %d payloads, want an error", len(items))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			if items != nil {
+				got = []string{}
+			}
+			for _, item := range items {
+				got = append(got, string(item))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("payloads = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPayloadsSaysWhichLineIsBad(t *testing.T) {
+	_, err := readPayloads(strings.NewReader("{}\n{}\nnope\n"))
+	if err == nil || !strings.Contains(err.Error(), "payload 3") {
+		t.Fatalf("err = %v, want one naming payload 3", err)
+	}
+
+	var syntax *json.SyntaxError
+	_, err = readPayloads(strings.NewReader("[nope]"))
+	if !errors.As(err, &syntax) {
+		t.Fatalf("err = %v, want a json syntax error", err)
+	}
+}
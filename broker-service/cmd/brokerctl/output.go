@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// how results are printed
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func validOutput(output string) error {
+	if output != outputTable && output != outputJSON {
+		return fmt.Errorf("unknown output %q, expected %q or %q", output, outputTable, outputJSON)
+	}
+
+	return nil
+}
+
+// print the json the broker sent back, indented
+func printJSON(w io.Writer, body []byte) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		// not json, so print it as it came
+		w.Write(body)
+		fmt.Fprintln(w)
+		return
+	}
+
+	out.WriteByte('\n')
+	out.WriteTo(w)
+}
+
+// print the broker's message, then every field of its data on a row of its own, e.g. "user.email  a@b.com"
+func printTable(w io.Writer, r *response) {
+	var res brokerResponse
+	if err := decode(r.Body, &res); err != nil {
+		printJSON(w, r.Body)
+		return
+	}
+
+	fmt.Fprintln(w, res.Message)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var rows [][2]string
+	flatten("", res.Data, &rows)
+	for _, row := range rows {
+		fmt.Fprintf(tw, "  %s\t%s\n", row[0], row[1])
+	}
+	if r.RequestID != "" {
+		fmt.Fprintf(tw, "  request id\t%s\n", r.RequestID)
+	}
+	tw.Flush()
+}
+
+// print a row for every item of a batch
+func printBatchTable(w io.Writer, r *response) {
+	var res struct {
+		Message string `json:"message"`
+		Data    struct {
+			Results []struct {
+				Index     int    `json:"index"`
+				Action    string `json:"action"`
+				Status    string `json:"status"`
+				Code      int    `json:"code"`
+				ErrorCode string `json:"error_code"`
+				Message   string `json:"message"`
+				Error     string `json:"error"`
+			} `json:"results"`
+		} `json:"data"`
+	}
+	if err := decode(r.Body, &res); err != nil {
+		printJSON(w, r.Body)
+		return
+	}
+
+	fmt.Fprintln(w, res.Message)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tACTION\tSTATUS\tCODE\tMESSAGE")
+	for _, item := range res.Data.Results {
+		message := item.Message
+		if item.Error != "" {
+			message = item.Error
+		}
+		code := item.ErrorCode
+		if code == "" && item.Code != 0 {
+			code = fmt.Sprint(item.Code)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", item.Index, item.Action, item.Status, code, message)
+	}
+	tw.Flush()
+}
+
+// print an error, as problem+json with -o json, and exit
+func fail(output string, r *response, err error) {
+	if output == outputJSON && r != nil && len(r.Body) > 0 {
+		printJSON(os.Stdout, r.Body)
+	} else {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		if r != nil && r.RequestID != "" {
+			fmt.Fprintln(os.Stderr, "request id:", r.RequestID)
+		}
+	}
+
+	os.Exit(1)
+}
+
+// turn nested json into rows of dotted keys and their values, sorted by key
+func flatten(prefix string, v any, rows *[][2]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			flatten(joinKey(prefix, key), v[key], rows)
+		}
+	case []any:
+		for i, item := range v {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), item, rows)
+		}
+	case nil:
+		if prefix != "" {
+			*rows = append(*rows, [2]string{prefix, "-"})
+		}
+	case string:
+		*rows = append(*rows, [2]string{prefix, v})
+	default:
+		b, _ := json.Marshal(v)
+		*rows = append(*rows, [2]string{prefix, string(b)})
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+// decode json without turning numbers into floats, so that ids print the way they were sent
+func decode(b []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrintJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "json", body: `{"error":false,"data":{"id":1}}`, want: "{\n  \"error\": false,\n  \"data\": {\n    \"id\": 1\n  }\n}\n"},
+		{name: "not json", body: "bad gateway", want: "bad gateway\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printJSON(&out, []byte(tt.body))
+			if got := out.String(); got != tt.want {
+				t.Fatalf("printed\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		requestID string
+		want      string
+	}{
+		{
+			name:      "nested data",
+			body:      `{"error":false,"message":"authenticated","data":{"user":{"id":12345678901234567,"email":"a@b.com","active":true},"roles":["admin","ops"],"expires":null}}`,
+			requestID: "req-1",
+			want: "authenticated\n" +
+				"  expires      -\n" +
+				"  roles[0]     admin\n" +
+				"  roles[1]     ops\n" +
+				"  user.active  true\n" +
+				"  user.email   a@b.com\n" +
+				"  user.id      12345678901234567\n" +
+				"  request id   req-1\n",
+		},
+		{name: "no data", body: `{"error":false,"message":"logged"}`, want: "logged\n"},
+		// whatever the broker sent is still shown
+		{name: "not json", body: "bad gateway", want: "bad gateway\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printTable(&out, &response{Body: []byte(tt.body), RequestID: tt.requestID})
+			if got := out.String(); got != tt.want {
+				t.Fatalf("printed\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintBatchTable(t *testing.T) {
+	body := `{"message":"1 of 3 succeeded","data":{"results":[
+		{"index":0,"action":"log","status":"succeeded","code":202,"message":"logged"},
+		{"index":1,"action":"auth","status":"failed","code":401,"error_code":"invalid_credentials","error":"invalid credentials"},
+		{"index":2,"action":"mail","status":"skipped","code":0,"message":"cancelled"}
+	]}}`
+
+	var out bytes.Buffer
+	printBatchTable(&out, &response{Body: []byte(body)})
+
+	want := "1 of 3 succeeded\n" +
+		"INDEX  ACTION  STATUS     CODE                 MESSAGE\n" +
+		"0      log     succeeded  202                  logged\n" +
+		"1      auth    failed     invalid_credentials  invalid credentials\n" +
+		"2      mail    skipped                         cancelled\n"
+	if got := out.String(); got != want {
+		t.Fatalf("printed\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestValidOutput(t *testing.T) {
+	for _, output := range []string{outputTable, outputJSON} {
+		if err := validOutput(output); err != nil {
+			t.Errorf("validOutput(%q) = %v", output, err)
+		}
+	}
+	for _, output := range []string{"", "yaml", "JSON"} {
+		if err := validOutput(output); err == nil {
+			t.Errorf("validOutput(%q) didnt fail", output)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+const profileUsage = `usage: brokerctl profile <list | set | use | delete> [args]
+
+  list                    show every profile, marking the current one with a *
+  set <name> [flags]      add a profile, or change one. the first profile added becomes the current one
+  use <name>              make a profile the current one
+  delete <name>           remove a profile
+`
+
+// manage the profiles in the config file
+func runProfile(cfg *config, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, profileUsage)
+		os.Exit(2)
+	}
+
+	switch command, args := args[0], args[1:]; command {
+	case "list":
+		return listProfiles(cfg)
+	case "set":
+		return setProfile(cfg, args)
+	case "use", "delete":
+		if len(args) != 1 {
+			fmt.Fprint(os.Stderr, profileUsage)
+			os.Exit(2)
+		}
+		if _, ok := cfg.Profiles[args[0]]; !ok {
+			return fmt.Errorf("no profile called %q", args[0])
+		}
+
+		if command == "use" {
+			cfg.Current = args[0]
+		} else {
+			delete(cfg.Profiles, args[0])
+			if cfg.Current == args[0] {
+				cfg.Current = ""
+			}
+		}
+		return cfg.save()
+	default:
+		fmt.Fprint(os.Stderr, profileUsage)
+		os.Exit(2)
+	}
+
+	return nil
+}
+
+func listProfiles(cfg *config) error {
+	if len(cfg.Profiles) == 0 {
+		fmt.Printf("no profiles in %s, using %s\n", cfg.path, defaultURL)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tNAME\tURL\tOUTPUT\tTOKEN")
+	for _, name := range cfg.names() {
+		p := cfg.Profiles[name]
+
+		current := ""
+		if name == cfg.Current {
+			current = "*"
+		}
+		token := "no"
+		if p.Token != "" {
+			token = "yes"
+		}
+		output := p.Output
+		if output == "" {
+			output = outputTable
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", current, name, p.URL, output, token)
+	}
+
+	return tw.Flush()
+}
+
+func setProfile(cfg *config, args []string) error {
+	if len(args) == 0 || args[0] == "" || args[0][0] == '-' {
+		fmt.Fprint(os.Stderr, profileUsage)
+		os.Exit(2)
+	}
+	name := args[0]
+
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		p = &profile{URL: defaultURL}
+	}
+
+	fs := flag.NewFlagSet("profile set", flag.ExitOnError)
+	fs.StringVar(&p.URL, "url", p.URL, "url of the broker")
+	fs.StringVar(&p.Token, "token", p.Token, `access token to send. "brokerctl auth -save" sets it too`)
+	fs.StringVar(&p.Output, "o", p.Output, `how to print results, "table" or "json"`)
+	fs.Parse(args[1:])
+
+	if p.URL == "" {
+		return errors.New("a profile needs a url")
+	}
+	if p.Output != "" {
+		if err := validOutput(p.Output); err != nil {
+			return err
+		}
+	}
+
+	cfg.Profiles[name] = p
+	if cfg.Current == "" {
+		cfg.Current = name
+	}
+
+	return cfg.save()
+}
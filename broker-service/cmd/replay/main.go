@@ -0,0 +1,263 @@
+// replay sends requests recorded by the broker (see CAPTURE_SAMPLE) to a broker again, and reports
+// every response that differs from the recorded one.
+//
+//	go run ./cmd/replay -target http://localhost:8080 -speed 2 capture.ndjson
+//
+// requests are sent at the pace they were recorded at, scaled by -speed, or one after another with -speed 0.
+// requests that had secrets redacted from them are skipped unless -redacted is given, since they will fail.
+// it exits with status 1 if any response differs
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jateen67/broker/capture"
+	"github.com/jateen67/broker/requestid"
+)
+
+type options struct {
+	target   string
+	speed    float64
+	token    string
+	action   string
+	ignore   map[string]bool
+	redacted bool
+	verbose  bool
+	client   *http.Client
+}
+
+// what happened to a recorded request when it was sent again
+type outcome struct {
+	line   int
+	record capture.Record
+	// the id the replayed request was sent with, to find it in the target broker's logs
+	requestID string
+	diffs     []string
+	err       error
+}
+
+func main() {
+	var opts options
+	var ignore string
+	var timeout time.Duration
+
+	flag.StringVar(&opts.target, "target", "http://localhost:8080", "base url of the broker to send the requests to")
+	flag.Float64Var(&opts.speed, "speed", 1, "how much faster than recorded to send the requests. 0 sends them one after another")
+	flag.StringVar(&opts.token, "token", os.Getenv("BROKER_TOKEN"), "access token to send the requests with (default $BROKER_TOKEN)")
+	flag.StringVar(&opts.action, "action", "", "only replay requests for this action")
+	flag.StringVar(&ignore, "ignore", "id,request_id,created_at,started_at,finished_at", "comma separated json keys whose values arent compared")
+	flag.BoolVar(&opts.redacted, "redacted", false, "send requests that had secrets redacted from them too")
+	flag.BoolVar(&opts.verbose, "v", false, "report responses that match as well")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "how long to wait for each response")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] capture.ndjson...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if opts.speed < 0 {
+		log.Fatal("-speed cant be negative")
+	}
+
+	opts.target = strings.TrimSuffix(opts.target, "/")
+	opts.ignore = map[string]bool{}
+	for _, key := range strings.Split(ignore, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			opts.ignore[key] = true
+		}
+	}
+	opts.client = &http.Client{Timeout: timeout}
+
+	var records []capture.Record
+	for _, path := range flag.Args() {
+		recs, err := readRecords(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		records = append(records, recs...)
+	}
+
+	sent, matched, differed, failed, skipped := 0, 0, 0, 0, 0
+	for o := range replay(records, opts) {
+		switch {
+		case errors.Is(o.err, errSkipped):
+			skipped++
+			continue
+		case o.err != nil:
+			failed++
+			fmt.Printf("#%d %s %s: %s\n", o.line, o.record.Method, o.record.URI, o.err)
+		case len(o.diffs) > 0:
+			differed++
+			fmt.Printf("#%d %s %s (%s): differs, request id %s\n", o.line, o.record.Method, o.record.URI, actionOf(o.record), o.requestID)
+			for _, diff := range o.diffs {
+				fmt.Println("    " + diff)
+			}
+		default:
+			matched++
+			if opts.verbose {
+				fmt.Printf("#%d %s %s (%s): matches\n", o.line, o.record.Method, o.record.URI, actionOf(o.record))
+			}
+		}
+		sent++
+	}
+
+	fmt.Printf("sent %d requests: %d matched, %d differed, %d failed. skipped %d\n", sent, matched, differed, failed, skipped)
+	if differed > 0 || failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// read every record in a capture file, or from stdin if path is "-"
+func readRecords(path string) ([]capture.Record, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var records []capture.Record
+	reader := capture.NewReader(r)
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", path, len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+var errSkipped = errors.New("skipped")
+
+// send every record to the target, at the pace they were recorded at scaled by opts.speed.
+// outcomes are sent on the channel as the responses come in, which is closed once they all have
+func replay(records []capture.Record, opts options) <-chan outcome {
+	outcomes := make(chan outcome)
+
+	go func() {
+		defer close(outcomes)
+
+		var wg sync.WaitGroup
+		start := time.Now()
+		var first time.Time
+
+		for i, record := range records {
+			line := i + 1
+			if reason := skip(record, opts); reason != "" {
+				if opts.verbose {
+					fmt.Printf("#%d skipped: %s\n", line, reason)
+				}
+				outcomes <- outcome{line: line, record: record, err: errSkipped}
+				continue
+			}
+
+			// one after another
+			if opts.speed == 0 {
+				outcomes <- send(line, record, opts)
+				continue
+			}
+
+			// keep the gaps between requests, so that concurrent requests are sent concurrently again
+			if first.IsZero() {
+				first = record.Time
+			}
+			offset := time.Duration(float64(record.Time.Sub(first)) / opts.speed)
+			time.Sleep(time.Until(start.Add(offset)))
+
+			wg.Add(1)
+			go func(line int, record capture.Record) {
+				defer wg.Done()
+				outcomes <- send(line, record, opts)
+			}(line, record)
+		}
+
+		wg.Wait()
+	}()
+
+	return outcomes
+}
+
+// why a record isnt replayed, or "" if it is
+func skip(record capture.Record, opts options) string {
+	if opts.action != "" && record.Action != opts.action {
+		return "action is " + actionOf(record)
+	}
+	if record.Redacted && !opts.redacted {
+		return "secrets were redacted from the request"
+	}
+
+	return ""
+}
+
+// send one recorded request, and compare the response with the recorded one
+func send(line int, record capture.Record, opts options) outcome {
+	o := outcome{line: line, record: record}
+
+	req, err := http.NewRequestWithContext(context.Background(), record.Method, opts.target+record.URI, bytes.NewReader(record.Request))
+	if err != nil {
+		o.err = err
+		return o
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range record.Header {
+		req.Header.Set(name, value)
+	}
+	if opts.token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+	}
+	// a new id, so that the replayed request isnt mistaken for the recorded one in the logs
+	o.requestID = requestid.New()
+	req.Header.Set(requestid.Header, o.requestID)
+
+	res, err := opts.client.Do(req)
+	if err != nil {
+		o.err = err
+		return o
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		o.err = err
+		return o
+	}
+
+	if res.StatusCode != record.Status {
+		o.diffs = append(o.diffs, fmt.Sprintf("status: %d != %d", record.Status, res.StatusCode))
+	}
+
+	// the recorded response had its secrets redacted, so redact this one the same way before comparing them
+	got, _ := capture.Redact(body)
+	o.diffs = append(o.diffs, capture.Diff(record.Response, got, opts.ignore)...)
+
+	return o
+}
+
+func actionOf(record capture.Record) string {
+	if record.Action == "" {
+		return "no action"
+	}
+
+	return record.Action
+}
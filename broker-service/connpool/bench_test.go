@@ -0,0 +1,184 @@
+package connpool
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/jateen67/broker/logs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// stands in for the logger service's RPCServer
+type benchRPCServer struct{}
+
+// net/rpc only registers methods whose argument types are exported
+type RPCPayload struct {
+	Name string
+	Data string
+}
+
+func (s *benchRPCServer) LogInfo(payload RPCPayload, res *string) error {
+	*res = "Processed payload via RPC: " + payload.Name + "!"
+	return nil
+}
+
+func (s *benchRPCServer) Ping(payload string, res *string) error {
+	*res = "pong"
+	return nil
+}
+
+// stands in for the logger service's LogServer
+type benchLogServer struct {
+	logs.UnimplementedLogServiceServer
+}
+
+func (s *benchLogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.LogResponse, error) {
+	return &logs.LogResponse{Result: "logged via grpc"}, nil
+}
+
+func startRPCServer(b testing.TB) string {
+	b.Helper()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("RPCServer", new(benchRPCServer)); err != nil {
+		b.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { lis.Close() })
+
+	go server.Accept(lis)
+
+	return lis.Addr().String()
+}
+
+func startGRPCServer(b testing.TB) string {
+	b.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	logs.RegisterLogServiceServer(server, &benchLogServer{})
+	b.Cleanup(server.Stop)
+
+	go server.Serve(lis)
+
+	return lis.Addr().String()
+}
+
+var benchPayload = RPCPayload{Name: "event", Data: "benchmark"}
+
+// what the broker used to do: dial, call and (at best) close for every request
+func BenchmarkRPCDialPerRequest(b *testing.B) {
+	addr := startRPCServer(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			client, err := rpc.Dial("tcp", addr)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			var result string
+			if err := client.Call("RPCServer.LogInfo", benchPayload, &result); err != nil {
+				b.Error(err)
+				return
+			}
+			client.Close()
+		}
+	})
+}
+
+func BenchmarkRPCPool(b *testing.B) {
+	addr := startRPCServer(b)
+
+	pool := NewRPCPool(addr, "RPCServer.Ping", Options{Size: 4})
+	b.Cleanup(func() { pool.Close() })
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var result string
+			if err := pool.Call(context.Background(), "RPCServer.LogInfo", benchPayload, &result); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// send one log entry over a grpc connection
+func writeLogGRPC(conn *grpc.ClientConn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := logs.NewLogServiceClient(conn).WriteLog(ctx, &logs.LogRequest{
+		LogEntry: &logs.Log{Name: benchPayload.Name, Data: benchPayload.Data},
+	})
+
+	return err
+}
+
+// what the broker used to do: a blocking dial for every request
+func dialAndWriteLogGRPC(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return writeLogGRPC(conn)
+}
+
+func BenchmarkGRPCDialPerRequest(b *testing.B) {
+	addr := startGRPCServer(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := dialAndWriteLogGRPC(addr); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkGRPCPool(b *testing.B) {
+	addr := startGRPCServer(b)
+
+	pool, err := NewGRPCPool(addr, Options{Size: 4}, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { pool.Close() })
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := writeLogGRPC(pool.Conn()); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package connpool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// a small pool of long-lived grpc connections to one server.
+// grpc reconnects each connection by itself when it drops, so the pool only has to spread calls
+// across the connections and skip the ones whose health checks are failing
+type GRPCPool struct {
+	target   string
+	opts     Options
+	dialOpts []grpc.DialOption
+	conns    []atomic.Pointer[pooledConn]
+	healthy  []atomic.Bool
+	next     atomic.Uint32
+	stop     chan struct{}
+	done     chan struct{}
+	// connections that were replaced and are waiting for their calls to finish before they are closed
+	retiring sync.WaitGroup
+}
+
+// a connection in the pool, with a count of the unary calls still running on it
+type pooledConn struct {
+	*grpc.ClientConn
+	calls atomic.Int64
+}
+
+// counts the calls made on the connection, so that a replaced connection isnt closed under them
+func (c *pooledConn) countCalls(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	c.calls.Add(1)
+	defer c.calls.Add(-1)
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func NewGRPCPool(target string, opts Options, dialOpts ...grpc.DialOption) (*GRPCPool, error) {
+	opts = opts.withDefaults()
+
+	p := &GRPCPool{
+		target:   target,
+		opts:     opts,
+		dialOpts: dialOpts,
+		conns:    make([]atomic.Pointer[pooledConn], opts.Size),
+		healthy:  make([]atomic.Bool, opts.Size),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for i := range p.conns {
+		conn, err := p.dial()
+		if err != nil {
+			p.closeConns()
+			return nil, err
+		}
+		p.conns[i].Store(conn)
+		p.healthy[i].Store(true)
+	}
+
+	go p.healthLoop()
+
+	return p, nil
+}
+
+// Conn returns the next healthy connection in round-robin order.
+// if none are healthy it still returns one, so the caller gets grpc's own error back
+func (p *GRPCPool) Conn() *grpc.ClientConn {
+	start := p.next.Add(1)
+
+	for i := 0; i < len(p.conns); i++ {
+		idx := (int(start) + i) % len(p.conns)
+		if p.healthy[idx].Load() {
+			return p.conns[idx].Load().ClientConn
+		}
+	}
+
+	return p.conns[int(start)%len(p.conns)].Load().ClientConn
+}
+
+// number of connections whose last health check passed
+func (p *GRPCPool) Healthy() int {
+	count := 0
+	for i := range p.healthy {
+		if p.healthy[i].Load() {
+			count++
+		}
+	}
+
+	return count
+}
+
+// stop health checking and close every connection, including the replaced ones still waiting on their calls
+func (p *GRPCPool) Close() error {
+	close(p.stop)
+	<-p.done
+	p.retiring.Wait()
+
+	return p.closeConns()
+}
+
+func (p *GRPCPool) closeConns() error {
+	var firstErr error
+	for i := range p.conns {
+		conn := p.conns[i].Load()
+		if conn == nil {
+			continue
+		}
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// check every connection on each tick of the health interval
+func (p *GRPCPool) healthLoop() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.opts.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for i := range p.conns {
+				healthy := p.check(p.conns[i].Load().ClientConn)
+				p.healthy[i].Store(healthy)
+
+				if !healthy && p.opts.Resolve != nil {
+					p.redial(i)
+				}
+			}
+		}
+	}
+}
+
+// no WithBlock here: the connection is made in the background and retried with backoff,
+// so the broker can start before the server is up
+func (p *GRPCPool) dial() (*pooledConn, error) {
+	conn := &pooledConn{}
+
+	dialOpts := append([]grpc.DialOption{grpc.WithChainUnaryInterceptor(conn.countCalls)}, p.dialOpts...)
+	cc, err := grpc.Dial(p.opts.addr(p.target), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	conn.ClientConn = cc
+
+	return conn, nil
+}
+
+// replace an unhealthy connection with one to a newly picked address. it stays marked
+// unhealthy until it passes a health check of its own.
+// the new connection is swapped in first, so new calls go to it, and the old one is only
+// closed once the calls already running on it are done
+func (p *GRPCPool) redial(i int) {
+	conn, err := p.dial()
+	if err != nil {
+		return
+	}
+
+	if old := p.conns[i].Swap(conn); old != nil {
+		p.retiring.Add(1)
+		go p.retire(old)
+	}
+}
+
+// how often a replaced connection is checked for calls that are still running
+const retirePoll = 100 * time.Millisecond
+
+// close a replaced connection once no calls are running on it, or once CloseGrace has passed,
+// whichever comes first. closing the pool closes it straight away.
+// the first check waits a poll, so that a caller that picked the connection just before it was
+// replaced has time to start its call
+func (p *GRPCPool) retire(conn *pooledConn) {
+	defer p.retiring.Done()
+	defer conn.Close()
+
+	grace := time.NewTimer(p.opts.CloseGrace)
+	defer grace.Stop()
+
+	ticker := time.NewTicker(retirePoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-grace.C:
+			return
+		case <-ticker.C:
+			if conn.calls.Load() == 0 {
+				return
+			}
+		}
+	}
+}
+
+// ask the server's grpc health service whether it is serving.
+// a server without a health service is taken to be healthy as long as it answered at all
+func (p *GRPCPool) check(conn *grpc.ClientConn) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.HealthTimeout)
+	defer cancel()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return status.Code(err) == codes.Unimplemented
+	}
+
+	return res.GetStatus() == healthpb.HealthCheckResponse_SERVING
+}
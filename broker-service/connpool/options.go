@@ -0,0 +1,52 @@
+// package connpool keeps long-lived, health-checked grpc and net/rpc connections
+// to another service, so the broker doesnt have to dial a new one for every request
+package connpool
+
+import "time"
+
+// settings shared by the grpc and rpc pools
+type Options struct {
+	// number of connections kept open
+	Size int
+	// how often every connection is health checked
+	HealthInterval time.Duration
+	// how long a single health check may take
+	HealthTimeout time.Duration
+	// how long dialling a new connection may take (rpc pool only; grpc dials in the background)
+	DialTimeout time.Duration
+	// how long a grpc connection that was replaced is kept open for the calls still running on it (grpc pool only)
+	CloseGrace time.Duration
+	// picks the address every new connection is dialled to, e.g. one instance of a service after the other.
+	// when it is set, the address given to the pool is ignored, and grpc connections that fail their health
+	// check are replaced by one dialled to a newly picked address
+	Resolve func() string
+}
+
+// the address to dial a new connection to
+func (o Options) addr(fixed string) string {
+	if o.Resolve != nil {
+		return o.Resolve()
+	}
+
+	return fixed
+}
+
+func (o Options) withDefaults() Options {
+	if o.Size <= 0 {
+		o.Size = 4
+	}
+	if o.HealthInterval <= 0 {
+		o.HealthInterval = 10 * time.Second
+	}
+	if o.HealthTimeout <= 0 {
+		o.HealthTimeout = time.Second
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 2 * time.Second
+	}
+	if o.CloseGrace <= 0 {
+		o.CloseGrace = 30 * time.Second
+	}
+
+	return o
+}
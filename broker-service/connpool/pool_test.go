@@ -0,0 +1,184 @@
+package connpool
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jateen67/broker/logs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// a log server whose WriteLog doesnt answer until release is closed
+type slowLogServer struct {
+	logs.UnimplementedLogServiceServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowLogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.LogResponse, error) {
+	s.started <- struct{}{}
+
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return &logs.LogResponse{Result: "logged via grpc"}, nil
+}
+
+func startSlowGRPCServer(t *testing.T) (string, *slowLogServer) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &slowLogServer{started: make(chan struct{}, 1), release: make(chan struct{})}
+	server := grpc.NewServer()
+	logs.RegisterLogServiceServer(server, slow)
+	t.Cleanup(server.Stop)
+
+	go server.Serve(lis)
+
+	return lis.Addr().String(), slow
+}
+
+// start a call on the pool's only connection and wait for the server to get it.
+// the returned channel gets the call's error
+func startSlowCall(t *testing.T, pool *GRPCPool, slow *slowLogServer) (*grpc.ClientConn, chan error) {
+	t.Helper()
+
+	conn := pool.Conn()
+	result := make(chan error, 1)
+	go func() {
+		_, err := logs.NewLogServiceClient(conn).WriteLog(context.Background(), &logs.LogRequest{LogEntry: &logs.Log{Name: "event"}})
+		result <- err
+	}()
+
+	select {
+	case <-slow.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the call never reached the server")
+	}
+
+	return conn, result
+}
+
+func waitShutdown(t *testing.T, conn *grpc.ClientConn) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for state := conn.GetState(); state != connectivity.Shutdown; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			t.Fatal("the replaced connection was never closed")
+		}
+	}
+}
+
+func newTestGRPCPool(t *testing.T, addr string, grace time.Duration) *GRPCPool {
+	t.Helper()
+
+	// the health checks are left to the test, which redials by hand
+	pool, err := NewGRPCPool(addr, Options{Size: 1, HealthInterval: time.Hour, CloseGrace: grace, Resolve: func() string { return addr }},
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	return pool
+}
+
+func TestGRPCRedialLetsRunningCallsFinish(t *testing.T) {
+	addr, slow := startSlowGRPCServer(t)
+	pool := newTestGRPCPool(t, addr, time.Minute)
+
+	old, result := startSlowCall(t, pool, slow)
+	pool.redial(0)
+
+	if pool.Conn() == old {
+		t.Fatal("the connection wasnt replaced")
+	}
+	if old.GetState() == connectivity.Shutdown {
+		t.Fatal("the replaced connection was closed while a call was running on it")
+	}
+
+	close(slow.release)
+	if err := <-result; err != nil {
+		t.Fatalf("the running call failed: %v", err)
+	}
+
+	waitShutdown(t, old)
+
+	// new calls go to the new connection
+	if err := writeLogGRPC(pool.Conn()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGRPCRedialClosesAfterTheGracePeriod(t *testing.T) {
+	addr, slow := startSlowGRPCServer(t)
+	defer close(slow.release)
+	pool := newTestGRPCPool(t, addr, 50*time.Millisecond)
+
+	old, result := startSlowCall(t, pool, slow)
+	pool.redial(0)
+
+	waitShutdown(t, old)
+	if err := <-result; err == nil {
+		t.Fatal("the call outlived the grace period")
+	}
+}
+
+func TestRPCPoolCall(t *testing.T) {
+	addr := startRPCServer(t)
+
+	pool := NewRPCPool(addr, "RPCServer.Ping", Options{Size: 2})
+	defer pool.Close()
+
+	// every caller shares the clients, however many dial at once
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var result string
+			if err := pool.Call(context.Background(), "RPCServer.LogInfo", benchPayload, &result); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if connected := pool.Connected(); connected != 2 {
+		t.Fatalf("%d slots connected, want 2", connected)
+	}
+}
+
+func TestRPCPoolDialGivesUpWithTheContext(t *testing.T) {
+	addr := startRPCServer(t)
+
+	pool := NewRPCPool(addr, "", Options{Size: 1, DialTimeout: time.Minute})
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var result string
+	if err := pool.Call(ctx, "RPCServer.LogInfo", benchPayload, &result); err == nil {
+		t.Fatal("expected the dial to fail with the context")
+	}
+	if connected := pool.Connected(); connected != 0 {
+		t.Fatalf("%d slots connected after the dial was cancelled", connected)
+	}
+}
@@ -0,0 +1,205 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a small pool of long-lived net/rpc clients to one server.
+// an rpc.Client can carry many calls at once, so each slot holds a single client that is
+// shared by everyone who picks that slot. a client whose connection breaks is thrown away
+// and a new one is dialled the next time the slot is used (or by the health check)
+type RPCPool struct {
+	addr  string
+	opts  Options
+	slots []*rpcSlot
+	next  atomic.Uint32
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+type rpcSlot struct {
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+// NewRPCPool creates the pool without dialling anything; connections are made on first use.
+// healthMethod is an rpc method taking and returning a string (e.g. "RPCServer.Ping") that is called
+// on every health interval. leave it empty to only check that the connection is still open
+func NewRPCPool(addr string, healthMethod string, opts Options) *RPCPool {
+	opts = opts.withDefaults()
+
+	p := &RPCPool{
+		addr:  addr,
+		opts:  opts,
+		slots: make([]*rpcSlot, opts.Size),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for i := range p.slots {
+		p.slots[i] = &rpcSlot{}
+	}
+
+	go p.healthLoop(healthMethod)
+
+	return p
+}
+
+// Call invokes an rpc method on one of the pooled clients, giving up when ctx is done
+func (p *RPCPool) Call(ctx context.Context, method string, args any, reply any) error {
+	slot := p.slots[int(p.next.Add(1))%len(p.slots)]
+
+	client, err := p.client(ctx, slot)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.Done:
+	}
+
+	// a ServerError is returned by the method itself, so the connection is still fine.
+	// anything else means the connection is broken and the slot needs a new client
+	var serverErr rpc.ServerError
+	if call.Error != nil && !errors.As(call.Error, &serverErr) {
+		p.reset(slot, client)
+	}
+
+	return call.Error
+}
+
+// number of slots that currently hold an open client
+func (p *RPCPool) Connected() int {
+	count := 0
+	for _, slot := range p.slots {
+		slot.mu.Lock()
+		if slot.client != nil {
+			count++
+		}
+		slot.mu.Unlock()
+	}
+
+	return count
+}
+
+// stop health checking and close every client
+func (p *RPCPool) Close() error {
+	close(p.stop)
+	<-p.done
+
+	var firstErr error
+	for _, slot := range p.slots {
+		slot.mu.Lock()
+		if slot.client != nil {
+			if err := slot.client.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			slot.client = nil
+		}
+		slot.mu.Unlock()
+	}
+
+	return firstErr
+}
+
+// the slot's client, dialling a new one if it doesnt have one.
+// the dial happens without holding the slot's lock, so a slow or dead server only holds up the callers
+// that are dialling, and each of them gives up when its own ctx is done.
+// if two callers dial the same slot at once, the first client to be stored wins and the other is closed
+func (p *RPCPool) client(ctx context.Context, slot *rpcSlot) (*rpc.Client, error) {
+	slot.mu.Lock()
+	client := slot.client
+	slot.mu.Unlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	dialer := net.Dialer{Timeout: p.opts.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.opts.addr(p.addr))
+	if err != nil {
+		return nil, err
+	}
+
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+
+	// the pool was closed while we were dialling, so dont leave a client behind that nobody will close
+	select {
+	case <-p.stop:
+		conn.Close()
+		return nil, net.ErrClosed
+	default:
+	}
+
+	if slot.client != nil {
+		conn.Close()
+		return slot.client, nil
+	}
+	slot.client = rpc.NewClient(conn)
+
+	return slot.client, nil
+}
+
+// throw away a broken client, unless someone already replaced it
+func (p *RPCPool) reset(slot *rpcSlot, broken *rpc.Client) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+
+	if slot.client == broken {
+		slot.client.Close()
+		slot.client = nil
+	}
+}
+
+// ping every slot on each tick of the health interval, reconnecting the ones that fail
+func (p *RPCPool) healthLoop(healthMethod string) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.opts.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for _, slot := range p.slots {
+				p.check(slot, healthMethod)
+			}
+		}
+	}
+}
+
+func (p *RPCPool) check(slot *rpcSlot, healthMethod string) {
+	// the dial is still bounded by DialTimeout
+	client, err := p.client(context.Background(), slot)
+	if err != nil || healthMethod == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.HealthTimeout)
+	defer cancel()
+
+	call := client.Go(healthMethod, "ping", new(string), make(chan *rpc.Call, 1))
+
+	select {
+	case <-ctx.Done():
+		// the server stopped answering, so start over with a fresh connection
+		p.reset(slot, client)
+	case <-call.Done:
+		var serverErr rpc.ServerError
+		if call.Error != nil && !errors.As(call.Error, &serverErr) {
+			p.reset(slot, client)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// returned while the connection to rabbitmq is down and being reconnected
+var ErrNotConnected = errors.New("not connected to rabbitmq")
+
+// returned once the connection has been closed for good
+var ErrConnectionClosed = errors.New("connection to rabbitmq is closed")
+
+// how long to wait before the first attempt to reconnect, doubled after every failed attempt up to reconnectMaxWait.
+// vars so that tests dont have to wait as long
+var (
+	reconnectWait    = 1 * time.Second
+	reconnectMaxWait = 30 * time.Second
+)
+
+// Connection keeps a connection to rabbitmq open. when rabbitmq drops it (e.g. because it restarted),
+// it reconnects in the background with a backoff and declares the logs_topic exchange again.
+// consumers notice the drop when their deliveries channel closes, and call Wait before consuming again
+type Connection struct {
+	url string
+
+	mu   sync.Mutex
+	conn *amqp.Connection // nil while we are reconnecting
+	// closed while we are connected, and replaced with a new one when the connection drops
+	ready  chan struct{}
+	closed bool
+	done   chan struct{}
+}
+
+// NewConnection manages conn, which was dialled with url, and declares the logs_topic exchange on it
+func NewConnection(url string, conn *amqp.Connection) (*Connection, error) {
+	// listen for the connection dropping before anything else, so that a drop is never missed
+	notify := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	if err := setupConnection(conn); err != nil {
+		return nil, err
+	}
+
+	c := &Connection{
+		url:   url,
+		conn:  conn,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	close(c.ready)
+
+	go c.watch(notify)
+
+	return c, nil
+}
+
+// Channel opens a channel on the current connection. it fails with ErrNotConnected while the connection is down
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return nil, ErrConnectionClosed
+	}
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
+	if errors.Is(err, amqp.ErrClosed) {
+		// dropped, but watch hasnt noticed yet
+		return nil, ErrNotConnected
+	}
+
+	return ch, err
+}
+
+// Connected reports whether we are connected to rabbitmq right now
+func (c *Connection) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil && !c.conn.IsClosed()
+}
+
+// Wait blocks until we are connected to rabbitmq, ctx is done, or the connection is closed for good
+func (c *Connection) Wait(ctx context.Context) error {
+	c.mu.Lock()
+	ready, closed := c.ready, c.closed
+	c.mu.Unlock()
+
+	// the connection may still look ready after Close, so that has to be checked first
+	if closed {
+		return ErrConnectionClosed
+	}
+
+	select {
+	case <-ready:
+		return nil
+	case <-c.done:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the connection for good; it isnt reconnected after this
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	return conn.Close()
+}
+
+// wait for the connection to drop, then reconnect, over and over until Close is called.
+// notify is the connection's NotifyClose channel, registered as soon as it was dialled: if it had been
+// registered any later, a connection that dropped in between would close it straight away
+func (c *Connection) watch(notify chan *amqp.Error) {
+	for {
+		// closed without an error when the connection is closed by us, or was already gone by the time
+		// we started listening, so c.closed is what tells them apart
+		err := <-notify
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		c.conn = nil
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+
+		log.Println("lost connection to rabbitmq, reconnecting:", err)
+
+		var conn *amqp.Connection
+		conn, notify = c.reconnect()
+		if conn == nil {
+			return
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return
+		}
+		c.conn = conn
+		close(c.ready)
+		c.mu.Unlock()
+
+		log.Println("reconnected to rabbitmq")
+	}
+}
+
+// dial rabbitmq until it answers, backing off a little more after each attempt. nil if Close is called first.
+// the connection's NotifyClose channel is returned with it, registered before anything else uses the connection
+func (c *Connection) reconnect() (*amqp.Connection, chan *amqp.Error) {
+	wait := reconnectWait
+
+	for {
+		select {
+		case <-c.done:
+			return nil, nil
+		case <-time.After(wait):
+		}
+
+		conn, err := amqp.Dial(c.url)
+		if err == nil {
+			notify := conn.NotifyClose(make(chan *amqp.Error, 1))
+			err = setupConnection(conn)
+			if err == nil {
+				return conn, notify
+			}
+			conn.Close()
+		}
+
+		wait *= 2
+		if wait > reconnectMaxWait {
+			wait = reconnectMaxWait
+		}
+
+		log.Printf("rabbitmq not yet ready, retrying in %s: %v", wait, err)
+	}
+}
+
+// declare the exchange everything is published to, since rabbitmq may have lost it if it restarted
+func setupConnection(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	return declareExchange(channel)
+}
+
+// Unavailable reports whether err means rabbitmq couldnt be reached or didnt answer in time,
+// as opposed to it turning the event away, so that pushing the same event again later may work
+func Unavailable(err error) bool {
+	return errors.Is(err, ErrNotConnected) || errors.Is(err, ErrConnectionClosed) ||
+		errors.Is(err, amqp.ErrClosed) || errors.Is(err, ErrConfirmTimeout)
+}
@@ -0,0 +1,199 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jateen67/amqptest"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// reconnect straight away when the connection is dropped
+func init() {
+	reconnectWait, reconnectMaxWait = 5*time.Millisecond, 20*time.Millisecond
+}
+
+// a stand-in for rabbitmq, and a connection to it
+func startRabbit(t *testing.T) (*amqptest.Server, *Connection) {
+	t.Helper()
+
+	server := amqptest.NewServer()
+	t.Cleanup(server.Close)
+
+	conn, err := amqp.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConnection(server.URL, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return server, c
+}
+
+// wait for cond to hold, failing the test if it doesnt within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectionReconnects(t *testing.T) {
+	server, c := startRabbit(t)
+
+	if !c.Connected() {
+		t.Fatal("not connected after NewConnection")
+	}
+	if err := c.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"logs_tail", "logs_topic"}
+	if got := server.Exchanges(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("declared %v, want %v", got, want)
+	}
+
+	// rabbitmq goes away
+	server.Stop()
+	eventually(t, "the drop is noticed", func() bool { return !c.Connected() })
+
+	if _, err := c.Channel(); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Channel() err = %v, want %v", err, ErrNotConnected)
+	}
+	if !Unavailable(ErrNotConnected) {
+		t.Fatal("ErrNotConnected isnt reported as unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() while disconnected = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// and comes back, having forgotten the exchanges
+	waited := make(chan error, 1)
+	go func() { waited <- c.Wait(context.Background()) }()
+	server.Start()
+
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() didnt return once rabbitmq came back")
+	}
+	if !c.Connected() {
+		t.Fatal("not connected after Wait returned")
+	}
+	if got := server.Exchanges(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("declared %v after reconnecting, want %v", got, want)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.Close()
+}
+
+func TestConnectionClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// whether rabbitmq is down, and the connection reconnecting, when it is closed
+		down bool
+	}{
+		{name: "while connected"},
+		{name: "while reconnecting", down: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, c := startRabbit(t)
+			if tt.down {
+				server.Stop()
+				eventually(t, "the drop is noticed", func() bool { return !c.Connected() })
+			}
+
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// closing twice is fine
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if c.Connected() {
+				t.Fatal("still connected after Close")
+			}
+			if _, err := c.Channel(); !errors.Is(err, ErrConnectionClosed) {
+				t.Fatalf("Channel() err = %v, want %v", err, ErrConnectionClosed)
+			}
+			if err := c.Wait(context.Background()); !errors.Is(err, ErrConnectionClosed) {
+				t.Fatalf("Wait() err = %v, want %v", err, ErrConnectionClosed)
+			}
+
+			// it isnt reconnected once rabbitmq is back
+			dials := server.Dials()
+			if tt.down {
+				server.Start()
+			}
+			time.Sleep(50 * time.Millisecond)
+			if got := server.Dials(); got != dials {
+				t.Fatalf("dialled rabbitmq %d more times after Close", got-dials)
+			}
+		})
+	}
+}
+
+func TestPushWhileDisconnected(t *testing.T) {
+	tests := []struct {
+		name string
+		wait time.Duration
+		// how long rabbitmq stays down after the push starts
+		downFor time.Duration
+		wantErr error
+	}{
+		{name: "fails fast without a wait", downFor: time.Second, wantErr: ErrNotConnected},
+		{name: "waits for rabbitmq to come back", wait: time.Second, downFor: 30 * time.Millisecond},
+		{name: "gives up once the wait is over", wait: 30 * time.Millisecond, downFor: time.Second, wantErr: ErrNotConnected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, c := startRabbit(t)
+			emitter := NewEventEmitter(c, EmitterOptions{Wait: tt.wait})
+			defer emitter.Close()
+
+			server.Stop()
+			eventually(t, "the drop is noticed", func() bool { return !c.Connected() })
+			restart := time.AfterFunc(tt.downFor, server.Start)
+			defer restart.Stop()
+
+			start := time.Now()
+			err := emitter.Push(context.Background(), `{"name":"event"}`, "log.INFO")
+			took := time.Since(start)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wait == 0 && took > 20*time.Millisecond {
+				t.Fatalf("took %s to fail without a wait", took)
+			}
+			if tt.wantErr == nil {
+				eventually(t, "the event reaches rabbitmq", func() bool { return len(server.Published()) > 0 })
+			}
+		})
+	}
+}
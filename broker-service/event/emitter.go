@@ -1,64 +1,217 @@
 package event
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
+	"github.com/jateen67/broker/requestid"
+	"github.com/jateen67/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var (
+	// returned by Push when rabbitmq refused to take the event
+	ErrNacked = errors.New("rabbitmq did not accept the event")
+	// returned by Push when rabbitmq didnt say whether it took the event within the confirm timeout.
+	// the event may or may not have been delivered
+	ErrConfirmTimeout = errors.New("timed out waiting for rabbitmq to confirm the event")
+)
+
+// returned by Push for a mandatory event that no queue is bound to take, e.g. because the listener service
+// doesnt listen for its routing key (see LISTENER_TOPICS) or has never been started
+type UnroutableError struct {
+	RoutingKey string
+	Reason     string
+}
+
+func (e *UnroutableError) Error() string {
+	return fmt.Sprintf("no queue takes events sent to %q (%s)", e.RoutingKey, e.Reason)
+}
+
+type EmitterOptions struct {
+	// how long Push waits for rabbitmq to come back while the connection is down. with 0 it fails straight away
+	Wait time.Duration
+	// wait for rabbitmq to confirm that it has taken every event, so that Push only succeeds once it has
+	Confirm bool
+	// how long to wait for the confirm. defaults to 5 seconds
+	ConfirmTimeout time.Duration
+	// have rabbitmq send back events that no queue is bound to take, and fail Push with an UnroutableError.
+	// rabbitmq only says that an event was routed by confirming it, so this waits for confirms too
+	Mandatory bool
+	// most channels kept open to publish on. when they are all busy, Push waits for one. defaults to 8
+	Channels int
+}
+
+func (o EmitterOptions) withDefaults() EmitterOptions {
+	if o.Channels <= 0 {
+		o.Channels = 8
+	}
+	if o.ConfirmTimeout <= 0 {
+		o.ConfirmTimeout = 5 * time.Second
+	}
+	if o.Mandatory {
+		o.Confirm = true
+	}
+
+	return o
+}
+
+// pushes events to the logs_topic exchange, and a copy of each to logs_tail for the tails. it is safe for many publishers at once, which share a pool of channels
 type Emitter struct {
-	connection *amqp.Connection
+	connection *Connection
+	opts       EmitterOptions
+	pool       *channelPool
 }
 
-// similar to the setup() function in the listener service consumer.go file
-func (e *Emitter) setup() error {
-	channel, err := e.connection.Channel()
-	if err != nil {
-		return err
+// take a channel to publish on, waiting for the connection to come back if it is down and we are allowed to
+func (e *Emitter) channel(ctx context.Context) (*pooledChannel, error) {
+	if e.opts.Wait > 0 && !e.connection.Connected() {
+		ctx, cancel := context.WithTimeout(ctx, e.opts.Wait)
+		defer cancel()
+
+		if err := e.connection.Wait(ctx); err != nil {
+			if errors.Is(err, ErrConnectionClosed) {
+				return nil, err
+			}
+			return nil, ErrNotConnected
+		}
 	}
-	defer channel.Close()
 
-	return declareExchange(channel)
+	return e.pool.get(ctx)
 }
 
-// push event to queue
-func (e *Emitter) Push(event string, severity string) error {
-	channel, err := e.connection.Channel()
+// push event to queue. the id of the request in ctx, if there is one, goes in the message's headers.
+// while the connection to rabbitmq is down, it waits for it to come back or fails with ErrNotConnected (see NewEventEmitter).
+// with confirms on, it only returns nil once rabbitmq has taken the event
+func (e *Emitter) Push(ctx context.Context, event string, routingKey string) (err error) {
+	pc, err := e.channel(ctx)
 	if err != nil {
 		return err
 	}
-	defer channel.Close()
+	defer func() {
+		e.pool.put(pc, channelBroken(err))
+	}()
 
 	log.Println("pushing to channel...")
 
-	err = channel.Publish(
-		"logs_topic", // name of the exchange
-		severity,     // either "log.INFO", "log.WARNING", or "log.ERROR"
-		false,        // is mandatory?
-		false,        // is immediate?
+	// let the listener service pass the request's id on to the logger service
+	headers := amqp.Table{}
+	id := requestid.FromContext(ctx)
+	if id != "" {
+		headers[requestid.MetadataKey] = id
+	}
+
+	// start a producer span and put its context in the message headers, so the listener can continue the trace
+	ctx, span := tracer.Start(ctx, "logs_topic publish", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", "logs_topic"),
+			attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+		))
+	defer span.End()
+	otel.GetTextMapPropagator().Inject(ctx, tracing.HeaderCarrier(headers))
+
+	// identifies the event if rabbitmq sends it back
+	messageID := requestid.New()
+
+	confirm, err := pc.ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"logs_topic",     // name of the exchange
+		routingKey,       // "log." and the severity, e.g. "log.INFO" or "log.ERROR.auth"
+		e.opts.Mandatory, // is mandatory?
+		false,            // is immediate?
 		amqp.Publishing{ // type amqp.Publishing
-			ContentType: "text/plain",
-			Body:        []byte(event), // payload of the message
+			ContentType:   "text/plain",
+			Headers:       headers,
+			CorrelationId: id,
+			MessageId:     messageID,
+			// only persisted if the queue is durable, but then it survives rabbitmq restarting
+			DeliveryMode: amqp.Persistent,
+			Body:         []byte(event), // payload of the message
 		},
 	)
+	if err == nil && confirm != nil {
+		err = e.awaitConfirm(ctx, confirm, pc.returns, messageID)
+	}
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
+	// hand a copy to anyone tailing the events. nobody needs to be, so it isnt mandatory and isnt waited for
+	err = pc.ch.PublishWithContext(
+		ctx,
+		"logs_tail", // name of the exchange
+		routingKey,  // same routing key, so tails can pick events the same way
+		false,       // is mandatory?
+		false,       // is immediate?
+		amqp.Publishing{
+			ContentType:   "text/plain",
+			Headers:       headers,
+			CorrelationId: id,
+			MessageId:     messageID,
+			Body:          []byte(event),
+		},
+	)
+	if err != nil {
+		log.Println("error pushing event to logs_tail:", err)
+	}
+
 	return nil
 }
 
-func NewEventEmitter(conn *amqp.Connection) (Emitter, error) {
-	// create new emitter
-	emitter := Emitter{
-		connection: conn,
-	}
+// wait for rabbitmq to ack or nack an event, then check whether it was sent back as unroutable
+func (e *Emitter) awaitConfirm(ctx context.Context, confirm *amqp.DeferredConfirmation, returns <-chan amqp.Return, messageID string) error {
+	ctx, cancel := context.WithTimeout(ctx, e.opts.ConfirmTimeout)
+	defer cancel()
 
-	// set it up
-	err := emitter.setup()
+	// the confirm may still turn up after we stop waiting, so the channel isnt used again (see channelBroken)
+	acked, err := confirm.WaitContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrConfirmTimeout
+	}
 	if err != nil {
-		return Emitter{}, err
+		return err
+	}
+	if !acked {
+		return ErrNacked
+	}
+
+	// rabbitmq sends the return before the ack, so if there is one it is already here.
+	// any others are late returns for events whose publisher gave up on them
+	for {
+		select {
+		case ret := <-returns:
+			if ret.MessageId == messageID {
+				return &UnroutableError{RoutingKey: ret.RoutingKey, Reason: ret.ReplyText}
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// events are pushed over conn. it declares the exchange they go to once, when it connects,
+// so the emitter never has to. Close must be called once the emitter is no longer needed
+func NewEventEmitter(conn *Connection, opts EmitterOptions) *Emitter {
+	opts = opts.withDefaults()
+
+	return &Emitter{
+		connection: conn,
+		opts:       opts,
+		pool:       newChannelPool(conn, opts.Channels, opts.Confirm),
 	}
+}
 
-	return emitter, nil
+// close the channels the emitter keeps open. pushes after this fail
+func (e *Emitter) Close() {
+	e.pool.close()
 }
@@ -0,0 +1,136 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jateen67/amqptest"
+)
+
+// bind a queue to logs_topic for the routing key, the way the listener service does
+func bindQueue(t *testing.T, c *Connection, routingKey string) {
+	t.Helper()
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare("logs", true, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.QueueBind("logs", routingKey, "logs_topic", false, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    EmitterOptions
+		confirm amqptest.Confirm
+		// routing key a queue is bound to, if any
+		bound   string
+		wantErr error
+	}{
+		{name: "acked", opts: EmitterOptions{Confirm: true}},
+		{name: "nacked", opts: EmitterOptions{Confirm: true}, confirm: amqptest.Nack, wantErr: ErrNacked},
+		{name: "never confirmed", opts: EmitterOptions{Confirm: true, ConfirmTimeout: 30 * time.Millisecond}, confirm: amqptest.NoConfirm, wantErr: ErrConfirmTimeout},
+		// without confirms, Push doesnt know what rabbitmq did with the event
+		{name: "nacked without confirms", confirm: amqptest.Nack},
+		{name: "mandatory and routed", opts: EmitterOptions{Mandatory: true}, bound: "log.#"},
+		{name: "mandatory and unroutable", opts: EmitterOptions{Mandatory: true}, bound: "log.ERROR", wantErr: &UnroutableError{}},
+		{name: "unroutable but not mandatory", opts: EmitterOptions{Confirm: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, c := startRabbit(t)
+			server.SetConfirm(tt.confirm)
+			if tt.bound != "" {
+				bindQueue(t, c, tt.bound)
+			}
+
+			emitter := NewEventEmitter(c, tt.opts)
+			defer emitter.Close()
+
+			err := emitter.Push(context.Background(), `{"name":"event"}`, "log.INFO")
+
+			var unroutable *UnroutableError
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case *UnroutableError:
+				if !errors.As(err, &unroutable) || unroutable.RoutingKey != "log.INFO" {
+					t.Fatalf("err = %v, want an UnroutableError for log.INFO", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Fatalf("err = %v, want %v", err, want)
+				}
+			}
+
+			// only events rabbitmq took are copied to the tails
+			eventually(t, "the event reaches rabbitmq", func() bool { return len(server.Published()) > 0 })
+			published := server.Published()
+			if published[0].Exchange != "logs_topic" || published[0].RoutingKey != "log.INFO" || published[0].Mandatory != tt.opts.Mandatory {
+				t.Fatalf("published %+v", published[0])
+			}
+			if tt.wantErr == nil {
+				eventually(t, "a copy reaches logs_tail", func() bool { return len(server.Published()) == 2 })
+				if tail := server.Published()[1]; tail.Exchange != "logs_tail" || tail.Mandatory {
+					t.Fatalf("copied %+v to the tails", tail)
+				}
+			} else if got := len(server.Published()); got != 1 {
+				t.Fatalf("%d events published, want just the one that failed", got)
+			}
+		})
+	}
+}
+
+func TestPushCarriesOnAfterAConfirmTimesOut(t *testing.T) {
+	server, c := startRabbit(t)
+	server.SetConfirm(amqptest.NoConfirm)
+
+	emitter := NewEventEmitter(c, EmitterOptions{Confirm: true, ConfirmTimeout: 30 * time.Millisecond, Channels: 1})
+	defer emitter.Close()
+
+	if err := emitter.Push(context.Background(), "late", "log.INFO"); !errors.Is(err, ErrConfirmTimeout) {
+		t.Fatalf("err = %v, want %v", err, ErrConfirmTimeout)
+	}
+
+	// the channel still waiting on the confirm is thrown away, which frees up the pool's only place for a new one
+	server.SetConfirm(amqptest.Ack)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := emitter.Push(ctx, "next", "log.INFO"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushMatchesReturnsToTheirEvent(t *testing.T) {
+	server, c := startRabbit(t)
+	bindQueue(t, c, "log.INFO")
+
+	emitter := NewEventEmitter(c, EmitterOptions{Mandatory: true, Channels: 1})
+	defer emitter.Close()
+
+	var unroutable *UnroutableError
+	if err := emitter.Push(context.Background(), "nobody wants this", "log.DEBUG"); !errors.As(err, &unroutable) {
+		t.Fatalf("err = %v, want an UnroutableError", err)
+	}
+
+	// the same channel is used again, and the earlier return isnt taken for this event's
+	for i := 0; i < 3; i++ {
+		if err := emitter.Push(context.Background(), "wanted", "log.INFO"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the unroutable one, then the others and their copies for the tails
+	eventually(t, "every event reaches rabbitmq", func() bool { return len(server.Published()) == 7 })
+}
@@ -2,11 +2,15 @@ package event
 
 import (
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
 )
 
+// tracer used for the spans around publishing and consuming messages
+var tracer = otel.Tracer("github.com/jateen67/broker/event")
+
 // when we call this function in consumer.go, we want it to return nil
 func declareExchange(ch *amqp.Channel) error {
-	return ch.ExchangeDeclare(
+	err := ch.ExchangeDeclare(
 		"logs_topic", // name of the exchange
 		"topic",      // type of the exchange
 		true,         // is this exchange durable?
@@ -15,6 +19,21 @@ func declareExchange(ch *amqp.Channel) error {
 		false,        // no wait?
 		nil,          // any specific arguments?
 	)
+	if err != nil {
+		return err
+	}
+
+	// tails get their copy of every event from here rather than from logs_topic, so that only the
+	// listener service's queue decides whether an event pushed to logs_topic is routed
+	return ch.ExchangeDeclare(
+		"logs_tail", // name of the exchange
+		"topic",     // type of the exchange
+		true,        // is this exchange durable?
+		false,       // do you get rid of it when you are done with it?
+		false,       // exchange just used internally?
+		false,       // no wait?
+		nil,         // any specific arguments?
+	)
 }
 
 func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
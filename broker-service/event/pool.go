@@ -0,0 +1,138 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// a bounded pool of long-lived channels to publish on, so that pushing an event doesnt open and close one every time.
+// each channel is used by one publisher at a time, so its confirms and returns belong to that publisher's event.
+// channels that rabbitmq closed (e.g. because the connection dropped) are thrown away and opened again when needed
+type channelPool struct {
+	conn    *Connection
+	confirm bool
+	// channels waiting to be used
+	idle chan *pooledChannel
+	// one token for every channel that is open, so there are never more than the pool's size
+	tokens    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type pooledChannel struct {
+	ch *amqp.Channel
+	// events rabbitmq sent back as unroutable
+	returns chan amqp.Return
+}
+
+func newChannelPool(conn *Connection, size int, confirm bool) *channelPool {
+	return &channelPool{
+		conn:    conn,
+		confirm: confirm,
+		idle:    make(chan *pooledChannel, size),
+		tokens:  make(chan struct{}, size),
+		done:    make(chan struct{}),
+	}
+}
+
+// get a channel, opening a new one if none are idle and the pool isnt full.
+// when it is full, wait for another publisher to put theirs back until ctx is done
+func (p *channelPool) get(ctx context.Context) (*pooledChannel, error) {
+	for {
+		// prefer a channel that is already open
+		select {
+		case pc := <-p.idle:
+			if pc.ch.IsClosed() {
+				p.discard(pc)
+				continue
+			}
+			return pc, nil
+		default:
+		}
+
+		select {
+		case pc := <-p.idle:
+			if pc.ch.IsClosed() {
+				p.discard(pc)
+				continue
+			}
+			return pc, nil
+		case p.tokens <- struct{}{}:
+			pc, err := p.open()
+			if err != nil {
+				<-p.tokens
+				return nil, err
+			}
+			return pc, nil
+		case <-p.done:
+			return nil, ErrConnectionClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *channelPool) open() (*pooledChannel, error) {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.confirm {
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return nil, err
+		}
+	}
+
+	pc := &pooledChannel{ch: ch}
+	// big enough that a return never blocks the connection while the publisher is busy
+	pc.returns = ch.NotifyReturn(make(chan amqp.Return, 16))
+
+	return pc, nil
+}
+
+// put a channel back once the publisher is done with it. a channel that may be left in a bad state,
+// such as one whose confirm never came, is closed rather than handed to the next publisher
+func (p *channelPool) put(pc *pooledChannel, broken bool) {
+	if broken || pc.ch.IsClosed() {
+		p.discard(pc)
+		return
+	}
+
+	select {
+	case <-p.done:
+		p.discard(pc)
+	case p.idle <- pc:
+	}
+}
+
+// close a channel and give its token back
+func (p *channelPool) discard(pc *pooledChannel) {
+	pc.ch.Close()
+	<-p.tokens
+}
+
+// close every idle channel and turn away new publishers. channels in use are closed when they are put back
+func (p *channelPool) close() {
+	p.closeOnce.Do(func() { close(p.done) })
+
+	for {
+		select {
+		case pc := <-p.idle:
+			p.discard(pc)
+		default:
+			return
+		}
+	}
+}
+
+// whether an error from publishing means that the channel cant be used again
+func channelBroken(err error) bool {
+	var amqpErr *amqp.Error
+	return errors.Is(err, amqp.ErrClosed) || errors.As(err, &amqpErr) ||
+		errors.Is(err, ErrConfirmTimeout) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
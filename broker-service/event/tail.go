@@ -0,0 +1,112 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jateen67/broker/requestid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// a message pushed to logs_topic, as seen by a tail
+type Message struct {
+	RoutingKey string
+	Payload    Payload
+	RequestID  string
+	Timestamp  time.Time
+}
+
+// type used for watching the events pushed to the queue as they happen.
+// it binds its own queue to the logs_tail exchange, which the emitter pushes a copy of every event to, so it
+// neither takes events away from the listener service nor makes events nobody logs look routed
+type Tail struct {
+	conn *Connection
+}
+
+// the exchange is declared by conn, so it is always there to bind to
+func NewTail(conn *Connection) Tail {
+	return Tail{
+		conn: conn,
+	}
+}
+
+// Listen binds a fresh queue to each of the topics and calls handle with every message that arrives on it,
+// until ctx is done or the connection to rabbitmq drops. handle must not block, or the queue backs up in rabbitmq.
+// after a drop, call Wait on the connection and then Listen again to resume
+func (t *Tail) Listen(ctx context.Context, topics []string, handle func(Message)) error {
+	ch, err := t.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	// the queue is exclusive to this channel, so rabbitmq deletes it as soon as we stop listening
+	q, err := declareRandomQueue(ch)
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range topics {
+		err = ch.QueueBind(
+			q.Name,      // name of queue
+			topic,       // topic
+			"logs_tail", // name of the exchange
+			false,       // no wait?
+			nil,         // any specific arguments?
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	messages, err := ch.Consume(
+		q.Name, // name of queue
+		"",     // name of consumer
+		true,   // auto acknowledge?
+		true,   // exclusive?
+		false,  // internal>
+		false,  // no wait?
+		nil,    // any specific arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok := <-messages:
+			if !ok {
+				return errors.New("rabbitmq closed the channel")
+			}
+
+			handle(message(d))
+		}
+	}
+}
+
+func message(d amqp.Delivery) Message {
+	msg := Message{
+		RoutingKey: d.RoutingKey,
+		RequestID:  d.CorrelationId,
+		Timestamp:  d.Timestamp,
+	}
+
+	if id, ok := d.Headers[requestid.MetadataKey].(string); ok && id != "" {
+		msg.RequestID = id
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	// events are pushed as json, but show anything else as it is rather than losing it
+	err := json.Unmarshal(d.Body, &msg.Payload)
+	if err != nil {
+		msg.Payload = Payload{Data: string(d.Body)}
+	}
+
+	return msg
+}
@@ -0,0 +1,96 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// how often the memory store throws away keys that have expired
+const sweepInterval = time.Minute
+
+// MemoryStore keeps keys in memory. they are lost when the broker restarts, and arent shared between brokers
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	record
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[string]memoryEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Begin(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (string, *Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		res, err := entry.replay(fingerprint)
+		return "", res, err
+	}
+
+	owner := newOwner()
+	s.entries[key] = memoryEntry{
+		record:    record{Fingerprint: fingerprint, Owner: owner},
+		expiresAt: now.Add(lockTTL),
+	}
+
+	return owner, nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, key, owner string, res Response, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || entry.Owner != owner {
+		return ErrNotOwner
+	}
+
+	entry.Response = &res
+	entry.expiresAt = time.Now().Add(ttl)
+	s.entries[key] = entry
+
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.Owner == owner {
+		delete(s.entries, key)
+	}
+
+	return nil
+}
+
+// the keys are gone with the broker, so there is nothing to close
+func (s *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// throw away expired keys, at most once every sweepInterval. must be called with the lock held
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
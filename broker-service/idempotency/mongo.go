@@ -0,0 +1,100 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps keys in a mongo collection, so that every broker sees them and they survive restarts
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+type mongoEntry struct {
+	Key         string    `bson:"_id"`
+	Fingerprint string    `bson:"fingerprint"`
+	Owner       string    `bson:"owner"`
+	Response    *Response `bson:"response"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+// NewMongoStore adds a ttl index to the collection, so that mongo deletes keys once they expire
+func NewMongoStore(ctx context.Context, collection *mongo.Collection) (*MongoStore, error) {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoStore{collection: collection}, nil
+}
+
+func (s *MongoStore) Begin(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (string, *Response, error) {
+	now := time.Now()
+	claim := mongoEntry{
+		Key:         key,
+		Fingerprint: fingerprint,
+		Owner:       newOwner(),
+		ExpiresAt:   now.Add(lockTTL),
+	}
+
+	_, err := s.collection.InsertOne(ctx, claim)
+	if err == nil {
+		return claim.Owner, nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return "", nil, err
+	}
+
+	// mongo only deletes expired documents once a minute, so one that has expired may still be there. take it over if so
+	res, err := s.collection.ReplaceOne(ctx, bson.M{"_id": key, "expires_at": bson.M{"$lte": now}}, claim)
+	if err != nil {
+		return "", nil, err
+	}
+	if res.ModifiedCount == 1 {
+		return claim.Owner, nil, nil
+	}
+
+	// someone else holds the key, so see what they did with it
+	var entry mongoEntry
+	err = s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// released in the meantime. its being worked on either way
+		return "", nil, ErrInProgress
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	stored, err := record{Fingerprint: entry.Fingerprint, Response: entry.Response}.replay(fingerprint)
+	return "", stored, err
+}
+
+func (s *MongoStore) Complete(ctx context.Context, key, owner string, res Response, ttl time.Duration) error {
+	update := bson.M{"$set": bson.M{"response": res, "expires_at": time.Now().Add(ttl)}}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": key, "owner": owner}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotOwner
+	}
+
+	return nil
+}
+
+func (s *MongoStore) Release(ctx context.Context, key, owner string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key, "owner": owner})
+	return err
+}
+
+func (s *MongoStore) Close(ctx context.Context) error {
+	return s.collection.Database().Client().Disconnect(ctx)
+}
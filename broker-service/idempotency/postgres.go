@@ -0,0 +1,107 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore keeps keys in a postgres table, so that every broker sees them and they survive restarts
+type PostgresStore struct {
+	db *sql.DB
+}
+
+const createIdempotencyTable = `
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key          text PRIMARY KEY,
+	fingerprint  text NOT NULL,
+	owner        text NOT NULL DEFAULT '',
+	status       integer,
+	content_type text NOT NULL DEFAULT '',
+	body         bytea,
+	expires_at   timestamptz NOT NULL
+)`
+
+// tables created before keys had owners get the column too
+const addIdempotencyOwner = `ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS owner text NOT NULL DEFAULT ''`
+
+// NewPostgresStore creates the idempotency_keys table if it doesnt exist yet
+func NewPostgresStore(ctx context.Context, db *sql.DB) (*PostgresStore, error) {
+	for _, stmt := range []string{createIdempotencyTable, addIdempotencyOwner} {
+		_, err := db.ExecContext(ctx, stmt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) Begin(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (string, *Response, error) {
+	now := time.Now()
+	owner := newOwner()
+
+	// claim the key, or take it over if whoever had it before has expired
+	stmt := `
+		INSERT INTO idempotency_keys (key, fingerprint, owner, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE
+			SET fingerprint = EXCLUDED.fingerprint, owner = EXCLUDED.owner, status = NULL, content_type = '', body = NULL,
+				expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= $5
+		RETURNING key`
+
+	var claimed string
+	err := s.db.QueryRowContext(ctx, stmt, key, fingerprint, owner, now.Add(lockTTL), now).Scan(&claimed)
+	if err == nil {
+		return owner, nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", nil, err
+	}
+
+	// someone else holds the key, so see what they did with it
+	var rec record
+	var status sql.NullInt32
+	var contentType string
+	var body []byte
+
+	query := `SELECT fingerprint, status, content_type, body FROM idempotency_keys WHERE key = $1`
+	err = s.db.QueryRowContext(ctx, query, key).Scan(&rec.Fingerprint, &status, &contentType, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		// released between our two statements. its being worked on either way
+		return "", nil, ErrInProgress
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	if status.Valid {
+		rec.Response = &Response{Status: int(status.Int32), ContentType: contentType, Body: body}
+	}
+
+	stored, err := rec.replay(fingerprint)
+	return "", stored, err
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, key, owner string, res Response, ttl time.Duration) error {
+	stmt := `UPDATE idempotency_keys SET status = $3, content_type = $4, body = $5, expires_at = $6 WHERE key = $1 AND owner = $2`
+
+	result, err := s.db.ExecContext(ctx, stmt, key, owner, res.Status, res.ContentType, res.Body, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotOwner
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key, owner string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND owner = $2`, key, owner)
+	return err
+}
+
+func (s *PostgresStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
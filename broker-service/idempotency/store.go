@@ -0,0 +1,73 @@
+// package idempotency remembers the response sent for each Idempotency-Key, so that a client retrying
+// a request gets the first response back instead of having the request run a second time
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	// the key is held by a request that hasnt finished yet
+	ErrInProgress = errors.New("a request with this idempotency key is still being processed")
+	// the key was already used for a different request
+	ErrMismatch = errors.New("this idempotency key was already used for a different request")
+	// returned by Complete and Release when the claim on the key ran out and another request took it over
+	ErrNotOwner = errors.New("the idempotency key is no longer held by this request")
+)
+
+// the response stored for a key
+type Response struct {
+	Status      int    `json:"status" bson:"status"`
+	ContentType string `json:"content_type" bson:"content_type"`
+	Body        []byte `json:"body" bson:"body"`
+}
+
+// Store keeps track of the keys that have been seen. every store must be safe to use from many brokers at once
+type Store interface {
+	// Begin claims key for a new request, until lockTTL runs out, and returns the owner token that Complete and Release
+	// need to be given. fingerprint identifies the request the key was sent with.
+	// if the key has already been used, Begin returns the response stored for it, ErrInProgress if that
+	// request is still running, or ErrMismatch if it was used for a different request
+	Begin(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (owner string, stored *Response, err error)
+	// Complete stores the response of the request holding key, to be replayed until ttl runs out.
+	// it fails with ErrNotOwner if the key is no longer held by owner
+	Complete(ctx context.Context, key, owner string, res Response, ttl time.Duration) error
+	// Release lets go of a key without storing a response, so that the request can be tried again.
+	// it does nothing if the key is no longer held by owner
+	Release(ctx context.Context, key, owner string) error
+	// Close lets go of the store's connection, if it has one
+	Close(ctx context.Context) error
+}
+
+// what a store knows about a key it has seen before
+type record struct {
+	Fingerprint string
+	// token of the request that claimed the key
+	Owner string
+	// nil while the request holding the key is still running
+	Response *Response
+}
+
+// what Begin returns for a key that has been seen before
+func (r record) replay(fingerprint string) (*Response, error) {
+	if r.Fingerprint != fingerprint {
+		return nil, ErrMismatch
+	}
+	if r.Response == nil {
+		return nil, ErrInProgress
+	}
+
+	return r.Response, nil
+}
+
+// a token that is only known to the request that claimed a key
+func newOwner() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
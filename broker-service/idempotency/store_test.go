@@ -0,0 +1,242 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// every store is put through the same tests. the postgres and mongo stores are only tested when
+// IDEMPOTENCY_TEST_POSTGRES or IDEMPOTENCY_TEST_MONGO hold the dsn of a database they can use
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("IDEMPOTENCY_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("IDEMPOTENCY_TEST_POSTGRES isnt set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewPostgresStore(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close(context.Background())
+
+	testStore(t, store)
+}
+
+func TestMongoStore(t *testing.T) {
+	dsn := os.Getenv("IDEMPOTENCY_TEST_MONGO")
+	if dsn == "" {
+		t.Skip("IDEMPOTENCY_TEST_MONGO isnt set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewMongoStore(context.Background(), client.Database("broker_test").Collection("idempotency_keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close(context.Background())
+
+	testStore(t, store)
+}
+
+const (
+	testLockTTL = time.Minute
+	testTTL     = time.Hour
+)
+
+var testResponse = Response{Status: 202, ContentType: "application/json", Body: []byte(`{"message":"logged"}`)}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	// keys are unique to every run, so that a database store doesnt see the ones from the last run
+	run := newOwner()
+	newKey := func(name string) string {
+		return fmt.Sprintf("test-%s-%s", run, name)
+	}
+
+	// claim a key, failing the test if it cant be
+	claim := func(t *testing.T, key string, lockTTL time.Duration) string {
+		t.Helper()
+
+		owner, stored, err := store.Begin(ctx, key, "fingerprint", lockTTL)
+		if err != nil || stored != nil || owner == "" {
+			t.Fatalf("Begin() = %q, %v, %v, want a claim", owner, stored, err)
+		}
+		return owner
+	}
+
+	tests := []struct {
+		name string
+		// what happens to the key before it is begun again, given the owner of the first claim
+		before      func(t *testing.T, key, owner string)
+		fingerprint string
+		wantClaim   bool
+		wantStored  *Response
+		wantErr     error
+	}{
+		{
+			name:        "still running",
+			before:      func(t *testing.T, key, owner string) {},
+			fingerprint: "fingerprint",
+			wantErr:     ErrInProgress,
+		},
+		{
+			name:        "different request",
+			before:      func(t *testing.T, key, owner string) {},
+			fingerprint: "another fingerprint",
+			wantErr:     ErrMismatch,
+		},
+		{
+			name: "completed",
+			before: func(t *testing.T, key, owner string) {
+				if err := store.Complete(ctx, key, owner, testResponse, testTTL); err != nil {
+					t.Fatal(err)
+				}
+			},
+			fingerprint: "fingerprint",
+			wantStored:  &testResponse,
+		},
+		{
+			name: "completed, then sent with a different request",
+			before: func(t *testing.T, key, owner string) {
+				if err := store.Complete(ctx, key, owner, testResponse, testTTL); err != nil {
+					t.Fatal(err)
+				}
+			},
+			fingerprint: "another fingerprint",
+			wantErr:     ErrMismatch,
+		},
+		{
+			name: "released",
+			before: func(t *testing.T, key, owner string) {
+				if err := store.Release(ctx, key, owner); err != nil {
+					t.Fatal(err)
+				}
+			},
+			fingerprint: "fingerprint",
+			wantClaim:   true,
+		},
+		{
+			name: "released by someone else",
+			before: func(t *testing.T, key, owner string) {
+				if err := store.Release(ctx, key, "not the owner"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			fingerprint: "fingerprint",
+			wantErr:     ErrInProgress,
+		},
+		{
+			name: "completed by someone else",
+			before: func(t *testing.T, key, owner string) {
+				if err := store.Complete(ctx, key, "not the owner", testResponse, testTTL); !errors.Is(err, ErrNotOwner) {
+					t.Fatalf("Complete() = %v, want %v", err, ErrNotOwner)
+				}
+			},
+			fingerprint: "fingerprint",
+			wantErr:     ErrInProgress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := newKey(tt.name)
+			owner := claim(t, key, testLockTTL)
+			tt.before(t, key, owner)
+
+			got, stored, err := store.Begin(ctx, key, tt.fingerprint, testLockTTL)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if (got != "") != tt.wantClaim {
+				t.Errorf("owner = %q, want a claim: %t", got, tt.wantClaim)
+			}
+			if got != "" && got == owner {
+				t.Errorf("the new claim has the same owner as the first")
+			}
+			if (stored == nil) != (tt.wantStored == nil) ||
+				stored != nil && (stored.Status != tt.wantStored.Status || stored.ContentType != tt.wantStored.ContentType || string(stored.Body) != string(tt.wantStored.Body)) {
+				t.Errorf("stored = %+v, want %+v", stored, tt.wantStored)
+			}
+		})
+	}
+
+	t.Run("a claim that ran out is taken over", func(t *testing.T) {
+		key := newKey("ran out")
+		first := claim(t, key, time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		second := claim(t, key, testLockTTL)
+
+		// the first request can no longer settle the key
+		if err := store.Complete(ctx, key, first, testResponse, testTTL); !errors.Is(err, ErrNotOwner) {
+			t.Fatalf("Complete() by the first owner = %v, want %v", err, ErrNotOwner)
+		}
+		if err := store.Release(ctx, key, first); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := store.Begin(ctx, key, "fingerprint", testLockTTL); !errors.Is(err, ErrInProgress) {
+			t.Fatalf("Begin() after the first owner let go = %v, want %v", err, ErrInProgress)
+		}
+
+		if err := store.Complete(ctx, key, second, testResponse, testTTL); err != nil {
+			t.Fatalf("Complete() by the second owner = %v", err)
+		}
+	})
+
+	t.Run("only one of many concurrent requests claims a key", func(t *testing.T) {
+		key := newKey("concurrent")
+
+		const requests = 20
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		claims, inProgress := 0, 0
+
+		for i := 0; i < requests; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				owner, _, err := store.Begin(ctx, key, "fingerprint", testLockTTL)
+
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil && owner != "":
+					claims++
+				case errors.Is(err, ErrInProgress):
+					inProgress++
+				default:
+					t.Errorf("Begin() = %q, %v", owner, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if claims != 1 || inProgress != requests-1 {
+			t.Fatalf("%d claims and %d in progress, want 1 and %d", claims, inProgress, requests-1)
+		}
+	})
+}
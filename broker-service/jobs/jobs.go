@@ -0,0 +1,289 @@
+// package jobs runs work in the background on a fixed number of workers, and keeps the outcome
+// of every job around for a while so that clients can come back for it
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// returned by Submit when every worker is busy and the queue of waiting jobs is full
+var ErrQueueFull = errors.New("too many jobs are waiting to run, try again later")
+
+// returned by Submit once the queue has been shut down
+var ErrClosed = errors.New("job queue is closed")
+
+// where a job is in its life
+type Status string
+
+const (
+	Queued    Status = "queued"
+	Running   Status = "running"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+)
+
+// the work a job does. whatever it returns becomes the job's result
+type Func func(ctx context.Context) (any, error)
+
+// a snapshot of a job
+type Job struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// id of the user that submitted the job, or 0 if it was submitted without logging in
+	Owner  int    `json:"-"`
+	Result any    `json:"result,omitempty"`
+	Err    error  `json:"-"`
+	Error  string `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// finished tells whether the job has stopped running, one way or the other
+func (j Job) Finished() bool {
+	return j.Status == Succeeded || j.Status == Failed
+}
+
+type Options struct {
+	// how many jobs run at once
+	Workers int
+	// how many jobs may wait for a free worker before Submit starts turning them away
+	QueueSize int
+	// how long a finished job is kept before it is forgotten
+	Retention time.Duration
+	// longest a single job may run for
+	Timeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 100
+	}
+	if o.Retention <= 0 {
+		o.Retention = 15 * time.Minute
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = time.Minute
+	}
+
+	return o
+}
+
+// a queue of jobs and the workers that run them
+type Queue struct {
+	opts  Options
+	ctx   context.Context
+	stop  context.CancelFunc
+	queue chan queued
+	wg    sync.WaitGroup
+	// jobs that have been submitted but havent finished yet
+	pending sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	// set once Shutdown is called, after which no more jobs are taken
+	closing bool
+}
+
+type queued struct {
+	id string
+	fn Func
+}
+
+// NewQueue starts the workers, and a janitor that forgets finished jobs once their retention is up
+func NewQueue(opts Options) *Queue {
+	opts = opts.withDefaults()
+	ctx, stop := context.WithCancel(context.Background())
+
+	q := &Queue{
+		opts:  opts,
+		ctx:   ctx,
+		stop:  stop,
+		queue: make(chan queued, opts.QueueSize),
+		jobs:  make(map[string]*Job),
+	}
+
+	q.wg.Add(opts.Workers + 1)
+	for i := 0; i < opts.Workers; i++ {
+		go q.work()
+	}
+	go q.janitor()
+
+	return q
+}
+
+// Submit queues fn to be run by the next free worker, and returns the job straight away
+func (q *Queue) Submit(name string, owner int, fn Func) (Job, error) {
+	job := &Job{
+		ID:        newID(),
+		Name:      name,
+		Status:    Queued,
+		Owner:     owner,
+		CreatedAt: time.Now(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closing || q.ctx.Err() != nil {
+		return Job{}, ErrClosed
+	}
+
+	select {
+	case q.queue <- queued{id: job.ID, fn: fn}:
+	default:
+		return Job{}, ErrQueueFull
+	}
+
+	q.jobs[job.ID] = job
+	q.pending.Add(1)
+
+	return *job, nil
+}
+
+// Get returns a job by its id. jobs that finished longer ago than the retention are not found
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *job, true
+}
+
+// Shutdown stops taking new jobs, and waits for the ones that are queued or running to finish.
+// if ctx is done first, the jobs that are still running have their context cancelled, as with Close
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closing = true
+	q.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		q.pending.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.Close()
+	return err
+}
+
+// Close stops the workers. jobs that are still running have their context cancelled
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.stop()
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case next := <-q.queue:
+			q.run(next)
+		}
+	}
+}
+
+func (q *Queue) run(next queued) {
+	q.update(next.id, func(job *Job) {
+		now := time.Now()
+		job.Status = Running
+		job.StartedAt = &now
+	})
+
+	ctx, cancel := context.WithTimeout(q.ctx, q.opts.Timeout)
+	defer cancel()
+
+	defer q.pending.Done()
+
+	result, err := next.fn(ctx)
+
+	q.update(next.id, func(job *Job) {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Result = result
+		job.Status = Succeeded
+
+		if err != nil {
+			job.Status = Failed
+			job.Err = err
+			job.Error = err.Error()
+		}
+	})
+}
+
+func (q *Queue) update(id string, fn func(job *Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if job, ok := q.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// every so often, forget the jobs that finished longer ago than the retention
+func (q *Queue) janitor() {
+	defer q.wg.Done()
+
+	interval := q.opts.Retention / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.forget(now)
+		}
+	}
+}
+
+// forget the jobs that had finished longer than the retention before now
+func (q *Queue) forget(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > q.opts.Retention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
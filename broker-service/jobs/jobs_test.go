@@ -0,0 +1,206 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// poll the queue until the job is in one of the statuses, failing the test if it takes too long
+func waitFor(t *testing.T, q *Queue, id string, statuses ...Status) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := q.Get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		for _, status := range statuses {
+			if job.Status == status {
+				return job
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s never got to %v", id, statuses)
+	return Job{}
+}
+
+func TestQueueRunsJobs(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	tests := []struct {
+		name       string
+		fn         Func
+		wantStatus Status
+		wantResult any
+		wantErr    error
+	}{
+		{
+			name:       "succeeds",
+			fn:         func(ctx context.Context) (any, error) { return "done", nil },
+			wantStatus: Succeeded,
+			wantResult: "done",
+		},
+		{
+			name:       "fails",
+			fn:         func(ctx context.Context) (any, error) { return nil, errBoom },
+			wantStatus: Failed,
+			wantErr:    errBoom,
+		},
+		{
+			name: "runs out of time",
+			fn: func(ctx context.Context) (any, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			wantStatus: Failed,
+			wantErr:    context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(Options{Workers: 1, Timeout: 50 * time.Millisecond})
+			defer q.Close()
+
+			submitted, err := q.Submit(tt.name, 7, tt.fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if submitted.Status != Queued || submitted.Owner != 7 || submitted.ID == "" {
+				t.Fatalf("submitted %+v", submitted)
+			}
+
+			job := waitFor(t, q, submitted.ID, Succeeded, Failed)
+			if job.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", job.Status, tt.wantStatus)
+			}
+			if job.Result != tt.wantResult {
+				t.Errorf("result = %v, want %v", job.Result, tt.wantResult)
+			}
+			if !errors.Is(job.Err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", job.Err, tt.wantErr)
+			}
+			if job.StartedAt == nil || job.FinishedAt == nil || !job.Finished() {
+				t.Errorf("job %+v isnt marked as finished", job)
+			}
+		})
+	}
+}
+
+func TestSubmitTurnsJobsAwayWhenTheQueueIsFull(t *testing.T) {
+	q := NewQueue(Options{Workers: 1, QueueSize: 1})
+	defer q.Close()
+
+	release := make(chan struct{})
+	block := func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}
+
+	// one job keeps the worker busy, and the next one waits for it
+	running, err := q.Submit("running", 0, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, q, running.ID, Running)
+
+	waiting, err := q.Submit("waiting", 0, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.Submit("turned away", 0, block); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+	}
+
+	close(release)
+	waitFor(t, q, waiting.ID, Succeeded)
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// how long the job takes, and how long Shutdown waits for it
+		jobTakes, wait time.Duration
+		wantErr        error
+		wantStatus     Status
+	}{
+		{name: "waits for running jobs", jobTakes: 20 * time.Millisecond, wait: time.Second, wantStatus: Succeeded},
+		{name: "cancels jobs that take too long", jobTakes: time.Minute, wait: 20 * time.Millisecond, wantErr: context.DeadlineExceeded, wantStatus: Failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(Options{Workers: 1})
+
+			job, err := q.Submit("job", 0, func(ctx context.Context) (any, error) {
+				select {
+				case <-time.After(tt.jobTakes):
+					return nil, nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, q, job.ID, Running)
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.wait)
+			defer cancel()
+
+			if err := q.Shutdown(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Shutdown() = %v, want %v", err, tt.wantErr)
+			}
+			if got, _ := q.Get(job.ID); got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+
+			if _, err := q.Submit("too late", 0, func(ctx context.Context) (any, error) { return nil, nil }); !errors.Is(err, ErrClosed) {
+				t.Errorf("Submit() after Shutdown = %v, want %v", err, ErrClosed)
+			}
+		})
+	}
+}
+
+func TestJanitorForgetsFinishedJobs(t *testing.T) {
+	q := NewQueue(Options{Retention: time.Minute})
+	defer q.Close()
+
+	now := time.Now()
+	old := now.Add(-2 * time.Minute)
+	recent := now.Add(-30 * time.Second)
+
+	q.mu.Lock()
+	q.jobs = map[string]*Job{
+		"old":     {ID: "old", Status: Succeeded, FinishedAt: &old},
+		"failed":  {ID: "failed", Status: Failed, FinishedAt: &old},
+		"recent":  {ID: "recent", Status: Succeeded, FinishedAt: &recent},
+		"running": {ID: "running", Status: Running, StartedAt: &old},
+		"queued":  {ID: "queued", Status: Queued},
+	}
+	q.mu.Unlock()
+
+	q.forget(now)
+
+	tests := []struct {
+		id   string
+		kept bool
+	}{
+		{id: "old", kept: false},
+		{id: "failed", kept: false},
+		{id: "recent", kept: true},
+		{id: "running", kept: true},
+		{id: "queued", kept: true},
+	}
+	for _, tt := range tests {
+		if _, ok := q.Get(tt.id); ok != tt.kept {
+			t.Errorf("job %s kept = %t, want %t", tt.id, ok, tt.kept)
+		}
+	}
+}
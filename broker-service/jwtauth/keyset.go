@@ -0,0 +1,175 @@
+// package jwtauth verifies the access tokens issued by the authentication service,
+// using the public keys it publishes on its jwks endpoint
+package jwtauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dont refetch the key set more often than this when a token names a key we dont know
+const minRefreshInterval = 30 * time.Second
+
+// the public keys of the authentication service, fetched from its jwks endpoint and cached.
+// every key ever fetched is kept, since each fetch may be answered by a different replica of the service
+type KeySet struct {
+	url    string
+	client *http.Client
+	// held by whoever is fetching the key set, so that a burst of unknown key ids only fetches it once
+	fetching chan struct{}
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	lastRefresh time.Time
+}
+
+func NewKeySet(url string, client *http.Client) *KeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	return &KeySet{
+		url:      url,
+		client:   client,
+		fetching: make(chan struct{}, 1),
+		keys:     make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Key returns the public key with the given id. an unknown id triggers a refetch of the key set,
+// since the authentication service may have started signing with a new key
+func (ks *KeySet) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	err := ks.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	key, ok = ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// fetch the key set again, unless it was fetched very recently, and add its keys to the ones we have.
+// tokens are verified with the keys we already have while the fetch runs
+func (ks *KeySet) refresh(ctx context.Context) error {
+	// wait for anyone else fetching it, then use what they got if it was recent enough
+	select {
+	case ks.fetching <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-ks.fetching }()
+
+	ks.mu.RLock()
+	recent := time.Since(ks.lastRefresh) < minRefreshInterval
+	ks.mu.RUnlock()
+	if recent {
+		return nil
+	}
+
+	keys, err := ks.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for kid, key := range keys {
+		ks.keys[kid] = key
+	}
+	ks.lastRefresh = time.Now()
+
+	return nil
+}
+
+// a single key of a json web key set (rfc 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (ks *KeySet) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", ks.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ks.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching key set: unexpected status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		// we only ever sign with rsa, so any other kind of key is skipped
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent is too large")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
@@ -0,0 +1,97 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeySetKeepsTheKeysOfEveryReplica(t *testing.T) {
+	first, second := newTestKey(t, "first"), newTestKey(t, "second")
+	// two replicas of the authentication service, each signing with its own key, answer in turn
+	server := startJWKS(t, []testKey{first}, []testKey{second})
+	ks := NewKeySet(server.URL, nil)
+
+	if _, err := ks.Key(context.Background(), "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next fetch is answered by the other replica
+	ks.lastRefresh = time.Time{}
+	if _, err := ks.Key(context.Background(), "second"); err != nil {
+		t.Fatal(err)
+	}
+
+	// and the first replica's key is still known without another fetch
+	if _, err := ks.Key(context.Background(), "first"); err != nil {
+		t.Fatalf("lost the first replica's key: %v", err)
+	}
+	if got := server.fetches.Load(); got != 2 {
+		t.Fatalf("fetched the key set %d times, want 2", got)
+	}
+}
+
+func TestKeySetDoesntRefetchTooOften(t *testing.T) {
+	server := startJWKS(t, []testKey{newTestKey(t, "known")})
+	ks := NewKeySet(server.URL, nil)
+
+	// a burst of tokens naming keys we dont know only fetches the key set once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ks.Key(context.Background(), "unknown")
+		}()
+	}
+	wg.Wait()
+
+	if _, err := ks.Key(context.Background(), "unknown"); err == nil {
+		t.Fatal("expected an error for an unknown key")
+	}
+	if got := server.fetches.Load(); got != 1 {
+		t.Fatalf("fetched the key set %d times, want 1", got)
+	}
+}
+
+func TestKeySetVerifiesWithKnownKeysWhileFetching(t *testing.T) {
+	server := startJWKS(t, []testKey{newTestKey(t, "known")})
+	ks := NewKeySet(server.URL, nil)
+
+	if _, err := ks.Key(context.Background(), "known"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next fetch hangs until it is released
+	defer server.hold()()
+	ks.lastRefresh = time.Time{}
+
+	go ks.Key(context.Background(), "unknown")
+	for server.fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ks.Key(context.Background(), "known")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a known key was held up by the fetch")
+	}
+
+	// and someone waiting on the fetch gives up with their context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := ks.Key(ctx, "another unknown"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,98 @@
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// who signs the tokens, and who they are meant for. must match the authentication service
+	issuer   = "authentication-service"
+	audience = "broker-service"
+
+	// only access tokens are accepted by the broker; refresh tokens can only be traded for new tokens
+	tokenTypeAccess = "access"
+)
+
+// who made a request, taken from the claims of their access token
+type Identity struct {
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	TokenID   string    `json:"token_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type claims struct {
+	Email string `json:"email"`
+	Type  string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// checks access tokens against the authentication service's public keys
+type Verifier struct {
+	keys *KeySet
+}
+
+func NewVerifier(keys *KeySet) *Verifier {
+	return &Verifier{keys: keys}
+}
+
+// Verify checks a token's signature, expiry, issuer, audience and type, and returns who it was issued to
+func (v *Verifier) Verify(ctx context.Context, token string) (Identity, error) {
+	var c claims
+
+	_, err := jwt.ParseWithClaims(token, &c, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key id")
+		}
+
+		return v.keys.Key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+	)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	// tokens that never expire are not accepted
+	if c.ExpiresAt == nil {
+		return Identity{}, errors.New("token has no expiry")
+	}
+
+	if c.Type != tokenTypeAccess {
+		return Identity{}, fmt.Errorf("expected an %s token, got %q", tokenTypeAccess, c.Type)
+	}
+
+	userID, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid subject %q", c.Subject)
+	}
+
+	return Identity{
+		UserID:    userID,
+		Email:     c.Email,
+		TokenID:   c.ID,
+		ExpiresAt: c.ExpiresAt.Time,
+	}, nil
+}
+
+type contextKey struct{}
+
+// store the caller's identity in a context
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// get the caller's identity back out of a context. ok is false if the caller didnt send a valid token
+func IdentityFrom(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
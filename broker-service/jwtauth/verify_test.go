@@ -0,0 +1,237 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// a key the authentication service signs with, under the given key id
+type testKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, kid string) testKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKey{kid: kid, key: key}
+}
+
+func (k testKey) jwk() jsonWebKey {
+	return jsonWebKey{
+		Kty: "RSA",
+		Kid: k.kid,
+		N:   base64.RawURLEncoding.EncodeToString(k.key.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.PublicKey.E)).Bytes()),
+	}
+}
+
+// sign a token the way the authentication service does, after change has had its way with the claims
+func (k testKey) sign(t *testing.T, change func(c *claims)) string {
+	t.Helper()
+
+	now := time.Now()
+	c := claims{
+		Email: "admin@example.com",
+		Type:  tokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   "1",
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			ID:        "token-id",
+		},
+	}
+	if change != nil {
+		change(&c)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	if k.kid != "" {
+		token.Header["kid"] = k.kid
+	}
+
+	signed, err := token.SignedString(k.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+// a jwks endpoint, standing in for the authentication service. each fetch is answered with the next
+// of the key sets it was given, the way replicas with different keys would take turns answering
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	sets    [][]testKey
+	fetches atomic.Int32
+	// closed to let fetches through, if it is set
+	release chan struct{}
+}
+
+// hold every fetch from now on until the returned func is called
+func (s *jwksServer) hold() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.release = make(chan struct{})
+	return func() { close(s.release) }
+}
+
+func startJWKS(t *testing.T, sets ...[]testKey) *jwksServer {
+	t.Helper()
+
+	s := &jwksServer{sets: sets}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(s.fetches.Add(1)) - 1
+
+		s.mu.Lock()
+		set, release := s.sets[n%len(s.sets)], s.release
+		s.mu.Unlock()
+
+		if release != nil {
+			<-release
+		}
+
+		var res struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		for _, k := range set {
+			res.Keys = append(res.Keys, k.jwk())
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	signer := newTestKey(t, "current")
+	other := newTestKey(t, "other")
+	server := startJWKS(t, []testKey{signer})
+
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) string
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			token: func(t *testing.T) string { return signer.sign(t, nil) },
+		},
+		{
+			name: "expired",
+			token: func(t *testing.T) string {
+				return signer.sign(t, func(c *claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) })
+			},
+			wantErr: "expired",
+		},
+		{
+			name:    "never expires",
+			token:   func(t *testing.T) string { return signer.sign(t, func(c *claims) { c.ExpiresAt = nil }) },
+			wantErr: "no expiry",
+		},
+		{
+			name:    "refresh token",
+			token:   func(t *testing.T) string { return signer.sign(t, func(c *claims) { c.Type = "refresh" }) },
+			wantErr: "expected an access token",
+		},
+		{
+			name: "wrong audience",
+			token: func(t *testing.T) string {
+				return signer.sign(t, func(c *claims) { c.Audience = jwt.ClaimStrings{"mailer-service"} })
+			},
+			wantErr: "audience",
+		},
+		{
+			name:    "wrong issuer",
+			token:   func(t *testing.T) string { return signer.sign(t, func(c *claims) { c.Issuer = "someone-else" }) },
+			wantErr: "issuer",
+		},
+		{
+			name:    "unknown key id",
+			token:   func(t *testing.T) string { return other.sign(t, nil) },
+			wantErr: `unknown signing key "other"`,
+		},
+		{
+			name:    "no key id",
+			token:   func(t *testing.T) string { return testKey{key: signer.key}.sign(t, nil) },
+			wantErr: "no key id",
+		},
+		{
+			name:    "signed with another key under a known key id",
+			token:   func(t *testing.T) string { return testKey{kid: signer.kid, key: other.key}.sign(t, nil) },
+			wantErr: "signature",
+		},
+		{
+			name:    "subject isnt a user id",
+			token:   func(t *testing.T) string { return signer.sign(t, func(c *claims) { c.Subject = "admin" }) },
+			wantErr: "invalid subject",
+		},
+		{
+			name: "not signed with rsa",
+			token: func(t *testing.T) string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Issuer: issuer})
+				token.Header["kid"] = signer.kid
+				signed, _ := token.SignedString([]byte("secret"))
+				return signed
+			},
+			wantErr: "signing method",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(NewKeySet(server.URL, nil))
+
+			id, err := v.Verify(context.Background(), tt.token(t))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id.UserID != 1 || id.Email != "admin@example.com" || id.TokenID != "token-id" || id.ExpiresAt.IsZero() {
+					t.Fatalf("identity = %+v", id)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIdentityContext(t *testing.T) {
+	if _, ok := IdentityFrom(context.Background()); ok {
+		t.Fatal("found an identity in an empty context")
+	}
+
+	want := Identity{UserID: 7, Email: "a@b.com"}
+	got, ok := IdentityFrom(WithIdentity(context.Background(), want))
+	if !ok || got != want {
+		t.Fatalf("got %+v, %t, want %+v", got, ok, want)
+	}
+}
@@ -0,0 +1,210 @@
+// package logstream fans log events out to every client watching them live. each client only gets the
+// events whose topic matches one of its patterns, and a client that cant keep up misses events
+// rather than holding up the others
+package logstream
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// returned by Subscribe when the hub already has as many subscribers as it allows
+var ErrTooManySubscribers = errors.New("too many clients are watching the logs, try again later")
+
+// a log event, as sent to the clients
+type Event struct {
+	// routing key the event was pushed with, e.g. "log.ERROR"
+	Topic     string    `json:"topic"`
+	Name      string    `json:"name"`
+	Data      string    `json:"data"`
+	RequestID string    `json:"request_id,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+type Options struct {
+	// how many events wait for each subscriber before newer ones are dropped. defaults to 64
+	Buffer int
+	// most subscribers at once. defaults to 1000
+	MaxSubscribers int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Buffer <= 0 {
+		o.Buffer = 64
+	}
+	if o.MaxSubscribers <= 0 {
+		o.MaxSubscribers = 1000
+	}
+
+	return o
+}
+
+type Hub struct {
+	opts Options
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+	// watched is closed while there is at least one subscriber, and idle while there are none.
+	// each is replaced with a new one when the other is closed
+	watched chan struct{}
+	idle    chan struct{}
+
+	// events dropped across every subscriber, ever
+	dropped atomic.Uint64
+}
+
+func NewHub(opts Options) *Hub {
+	h := &Hub{
+		opts:    opts.withDefaults(),
+		subs:    make(map[*Subscription]struct{}),
+		watched: make(chan struct{}),
+		idle:    make(chan struct{}),
+	}
+	close(h.idle)
+
+	return h
+}
+
+// Subscribe starts sending the subscriber every event whose topic matches one of the patterns (see Match).
+// no patterns means every event. Close must be called on the subscription once the client goes away
+func (h *Hub) Subscribe(patterns []string) (*Subscription, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"#"}
+	}
+
+	sub := &Subscription{
+		hub:      h,
+		patterns: patterns,
+		events:   make(chan Event, h.opts.Buffer),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, errors.New("the hub has been closed")
+	}
+	if len(h.subs) >= h.opts.MaxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+	h.subs[sub] = struct{}{}
+	if len(h.subs) == 1 {
+		close(h.watched)
+		h.idle = make(chan struct{})
+	}
+
+	return sub, nil
+}
+
+// Publish hands the event to every subscriber that wants it. it never blocks: a subscriber
+// whose buffer is full doesnt get the event, and is told how many it missed instead (see Subscription.Dropped)
+func (h *Hub) Publish(ev Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs {
+		if !sub.wants(ev.Topic) {
+			continue
+		}
+
+		select {
+		case sub.events <- ev:
+		default:
+			sub.dropped.Add(1)
+			h.dropped.Add(1)
+		}
+	}
+}
+
+// number of clients watching right now
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.subs)
+}
+
+// Watched returns a channel that is closed once at least one client is watching, so that
+// events only need fetching while someone wants them
+func (h *Hub) Watched() <-chan struct{} {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.watched
+}
+
+// Idle returns a channel that is closed once no client is watching
+func (h *Hub) Idle() <-chan struct{} {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.idle
+}
+
+// number of events that have been dropped because a subscriber couldnt keep up
+func (h *Hub) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+// end every subscription, and turn away new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		h.remove(sub)
+	}
+}
+
+// end a subscription. h.mu must be held
+func (h *Hub) remove(sub *Subscription) {
+	delete(h.subs, sub)
+	close(sub.events)
+
+	if len(h.subs) == 0 {
+		close(h.idle)
+		h.watched = make(chan struct{})
+	}
+}
+
+// one client watching the logs
+type Subscription struct {
+	hub      *Hub
+	patterns []string
+	events   chan Event
+	dropped  atomic.Uint64
+}
+
+// the events for the subscriber. the channel is closed when the subscription ends
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events the subscriber has missed since the last time it was called
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+// stop sending events to the subscriber. safe to call more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if _, ok := s.hub.subs[s]; ok {
+		s.hub.remove(s)
+	}
+}
+
+func (s *Subscription) wants(topic string) bool {
+	for _, pattern := range s.patterns {
+		if Match(pattern, topic) {
+			return true
+		}
+	}
+
+	return false
+}
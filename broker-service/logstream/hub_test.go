@@ -0,0 +1,168 @@
+package logstream
+
+import (
+	"errors"
+	"testing"
+)
+
+// the topics of the events waiting for sub, without waiting for more
+func received(sub *Subscription) []string {
+	var topics []string
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return topics
+			}
+			topics = append(topics, ev.Topic)
+		default:
+			return topics
+		}
+	}
+}
+
+func TestHubSendsEventsToTheSubscribersThatWantThem(t *testing.T) {
+	h := NewHub(Options{})
+	defer h.Close()
+
+	errorsOnly, err := h.Subscribe([]string{"log.ERROR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	everything, err := h.Subscribe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authOrMail, err := h.Subscribe([]string{"*.*.auth", "log.#.mail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, topic := range []string{"log.INFO", "log.ERROR", "log.ERROR.auth", "log.mail"} {
+		h.Publish(Event{Topic: topic})
+	}
+
+	tests := []struct {
+		name string
+		sub  *Subscription
+		want []string
+	}{
+		{name: "one topic", sub: errorsOnly, want: []string{"log.ERROR"}},
+		{name: "no patterns", sub: everything, want: []string{"log.INFO", "log.ERROR", "log.ERROR.auth", "log.mail"}},
+		{name: "many patterns", sub: authOrMail, want: []string{"log.ERROR.auth", "log.mail"}},
+	}
+	for _, tt := range tests {
+		got := received(tt.sub)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestHubDropsEventsForSlowSubscribers(t *testing.T) {
+	h := NewHub(Options{Buffer: 2})
+	defer h.Close()
+
+	slow, _ := h.Subscribe(nil)
+	fast, _ := h.Subscribe(nil)
+
+	// the slow subscriber never reads, and the fast one reads every event as it comes
+	for i := 0; i < 5; i++ {
+		h.Publish(Event{Topic: "log.INFO"})
+		if got := received(fast); len(got) != 1 {
+			t.Fatalf("the fast subscriber got %d events, want 1", len(got))
+		}
+	}
+
+	if got := received(slow); len(got) != 2 {
+		t.Fatalf("the slow subscriber got %d events, want the 2 that fit in its buffer", len(got))
+	}
+	if got := slow.Dropped(); got != 3 {
+		t.Fatalf("the slow subscriber dropped %d events, want 3", got)
+	}
+	// Dropped counts from the last time it was called
+	if got := slow.Dropped(); got != 0 {
+		t.Fatalf("the slow subscriber dropped %d events since the last call, want 0", got)
+	}
+	if got := fast.Dropped(); got != 0 {
+		t.Fatalf("the fast subscriber dropped %d events, want 0", got)
+	}
+	if got := h.Dropped(); got != 3 {
+		t.Fatalf("the hub dropped %d events, want 3", got)
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	h := NewHub(Options{MaxSubscribers: 1})
+	defer h.Close()
+
+	select {
+	case <-h.Idle():
+	default:
+		t.Fatal("a hub without subscribers isnt idle")
+	}
+
+	sub, err := h.Subscribe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-h.Watched():
+	default:
+		t.Fatal("a hub with a subscriber isnt watched")
+	}
+
+	if _, err := h.Subscribe(nil); !errors.Is(err, ErrTooManySubscribers) {
+		t.Fatalf("err = %v, want %v", err, ErrTooManySubscribers)
+	}
+
+	sub.Close()
+	// closing twice is fine
+	sub.Close()
+
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("the events of a closed subscription werent closed")
+	}
+	if got := h.Subscribers(); got != 0 {
+		t.Fatalf("%d subscribers after the only one left", got)
+	}
+	select {
+	case <-h.Idle():
+	default:
+		t.Fatal("the hub isnt idle once its last subscriber left")
+	}
+
+	// publishing to nobody is fine, and the freed up slot can be taken again
+	h.Publish(Event{Topic: "log.INFO"})
+	again, err := h.Subscribe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := received(again); len(got) != 0 {
+		t.Fatalf("a new subscriber got events published before it came: %v", got)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub(Options{})
+
+	sub, _ := h.Subscribe(nil)
+	h.Close()
+
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("the subscription was still open after the hub closed")
+	}
+	// the subscriber closing its own subscription afterwards is fine
+	sub.Close()
+
+	if _, err := h.Subscribe(nil); err == nil {
+		t.Fatal("a closed hub took a new subscriber")
+	}
+}
@@ -0,0 +1,52 @@
+package logstream
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Match tells whether a topic matches a pattern the way rabbitmq matches routing keys to the bindings of a
+// topic exchange: both are split into words on ".", a "*" in the pattern stands for exactly one word, and a "#"
+// for any number of them (even none). so "log.ERROR" matches "log.ERROR", "log.*" and "#", but not "log"
+func Match(pattern, topic string) bool {
+	return match(strings.Split(pattern, "."), strings.Split(topic, "."))
+}
+
+func match(pattern, topic []string) bool {
+	for len(pattern) > 0 {
+		word := pattern[0]
+		pattern = pattern[1:]
+
+		if word == "#" {
+			// try letting the # take up every possible number of words
+			for i := 0; i <= len(topic); i++ {
+				if match(pattern, topic[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(topic) == 0 || (word != "*" && word != topic[0]) {
+			return false
+		}
+		topic = topic[1:]
+	}
+
+	return len(topic) == 0
+}
+
+// ValidPattern reports an error for a pattern that could never match anything, e.g. "log..ERROR"
+func ValidPattern(pattern string) error {
+	if len(pattern) > 255 {
+		return fmt.Errorf("topic %q is longer than 255 characters", pattern)
+	}
+
+	for _, word := range strings.Split(pattern, ".") {
+		if word == "" {
+			return fmt.Errorf("topic %q has an empty word", pattern)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package logstream
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{pattern: "log.ERROR", topic: "log.ERROR", want: true},
+		{pattern: "log.ERROR", topic: "log.INFO"},
+		{pattern: "log.ERROR", topic: "log"},
+		{pattern: "log", topic: "log.ERROR"},
+		// * is exactly one word
+		{pattern: "log.*", topic: "log.ERROR", want: true},
+		{pattern: "*.ERROR", topic: "log.ERROR", want: true},
+		{pattern: "log.*", topic: "log"},
+		{pattern: "log.*", topic: "log.ERROR.auth"},
+		{pattern: "*", topic: "log", want: true},
+		// # is any number of words, even none
+		{pattern: "#", topic: "log.ERROR", want: true},
+		{pattern: "#", topic: "log", want: true},
+		{pattern: "log.#", topic: "log", want: true},
+		{pattern: "log.#", topic: "log.ERROR.auth", want: true},
+		{pattern: "#.ERROR", topic: "log.ERROR", want: true},
+		{pattern: "#.ERROR", topic: "ERROR", want: true},
+		{pattern: "#.ERROR", topic: "log.ERROR.auth"},
+		{pattern: "log.#.auth", topic: "log.auth", want: true},
+		{pattern: "log.#.auth", topic: "log.ERROR.WARN.auth", want: true},
+		{pattern: "log.#.auth", topic: "log.ERROR.mail"},
+		{pattern: "#.*", topic: "log", want: true},
+		{pattern: "*.#.*", topic: "log", want: false},
+		{pattern: "*.#.*", topic: "log.ERROR", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.topic, func(t *testing.T) {
+			if got := Match(tt.pattern, tt.topic); got != tt.want {
+				t.Fatalf("Match(%q, %q) = %t, want %t", tt.pattern, tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		valid   bool
+	}{
+		{pattern: "log.ERROR", valid: true},
+		{pattern: "log.*", valid: true},
+		{pattern: "#", valid: true},
+		{pattern: ""},
+		{pattern: "log..ERROR"},
+		{pattern: "log."},
+		{pattern: ".log"},
+		{pattern: strings.Repeat("a", 256)},
+	}
+
+	for _, tt := range tests {
+		if err := ValidPattern(tt.pattern); (err == nil) != tt.valid {
+			t.Errorf("ValidPattern(%.20q) = %v, want valid: %t", tt.pattern, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// package outbox keeps events that couldnt be published yet in a file on disk, so that they survive
+// rabbitmq being down and the broker restarting. a Relay publishes them, oldest first, once it can
+package outbox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("events")
+
+// an event waiting to be published
+type Entry struct {
+	// the order entries were added in. set by Add
+	Seq        uint64 `json:"seq"`
+	RoutingKey string `json:"routing_key"`
+	Body       string `json:"body"`
+	RequestID  string `json:"request_id,omitempty"`
+	// trace context of the request that produced the event, so that publishing it later continues the same trace
+	Trace     map[string]string `json:"trace,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// how far behind the outbox is
+type Stats struct {
+	// number of entries waiting to be published
+	Depth int `json:"depth"`
+	// when the oldest entry was added, and how long ago that was. zero while the outbox is empty
+	OldestAt  *time.Time `json:"oldest_at,omitempty"`
+	OldestAge float64    `json:"oldest_age_seconds"`
+}
+
+type Outbox struct {
+	db *bolt.DB
+	// has a value in it whenever entries have been added that the relay may not have seen
+	added chan struct{}
+}
+
+// Open opens the outbox stored in the file at path, creating it if it doesnt exist.
+// only one process can have the file open at a time
+func Open(path string) (*Outbox, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Outbox{db: db, added: make(chan struct{}, 1)}, nil
+}
+
+// Add stores an entry after every other one, and returns its Seq. it is on disk by the time Add returns
+func (o *Outbox) Add(e Entry) (uint64, error) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.Seq = seq
+
+		value, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(key(seq), value)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case o.added <- struct{}{}:
+	default:
+	}
+
+	return e.Seq, nil
+}
+
+// Peek returns up to n of the oldest entries, oldest first, without removing them
+func (o *Outbox) Peek(n int) ([]Entry, error) {
+	var entries []Entry
+
+	err := o.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.First(); k != nil && len(entries) < n; k, v = c.Next() {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// Remove deletes an entry once it has been published
+func (o *Outbox) Remove(seq uint64) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key(seq))
+	})
+}
+
+// Empty reports whether every entry has been published
+func (o *Outbox) Empty() (bool, error) {
+	empty := true
+	err := o.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(bucket).Cursor().First()
+		empty = k == nil
+		return nil
+	})
+
+	return empty, err
+}
+
+// Stats returns how many entries are waiting, and how old the oldest of them is
+func (o *Outbox) Stats() (Stats, error) {
+	var stats Stats
+
+	err := o.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		stats.Depth = b.Stats().KeyN
+
+		_, v := b.Cursor().First()
+		if v == nil {
+			return nil
+		}
+
+		var oldest Entry
+		if err := json.Unmarshal(v, &oldest); err != nil {
+			return err
+		}
+		stats.OldestAt = &oldest.CreatedAt
+		stats.OldestAge = time.Since(oldest.CreatedAt).Seconds()
+
+		return nil
+	})
+
+	return stats, err
+}
+
+// Added returns a channel that has a value in it whenever entries have been added since it was last read
+func (o *Outbox) Added() <-chan struct{} {
+	return o.added
+}
+
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+// keys are big endian so that bolt, which sorts them byte by byte, keeps the entries in the order they were added
+func key(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
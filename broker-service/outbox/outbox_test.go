@@ -0,0 +1,281 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestOutbox(t *testing.T) (*Outbox, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	o, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+
+	return o, path
+}
+
+// add an entry for each body, failing the test if any cant be
+func add(t *testing.T, o *Outbox, bodies ...string) {
+	t.Helper()
+
+	for _, body := range bodies {
+		if _, err := o.Add(Entry{RoutingKey: "log.INFO", Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func bodies(entries []Entry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Body)
+	}
+
+	return out
+}
+
+func TestOutboxKeepsEntriesInOrder(t *testing.T) {
+	o, path := openTestOutbox(t)
+
+	// more than 255 entries, so that keys that sort wrong as text would show up
+	var want []string
+	for i := 0; i < 300; i++ {
+		want = append(want, fmt.Sprintf("entry %d", i))
+	}
+	add(t, o, want...)
+
+	entries, err := o.Peek(len(want) + 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bodies(entries), want) {
+		t.Fatalf("entries came back out of order")
+	}
+	for i, e := range entries {
+		if e.Seq != uint64(i+1) {
+			t.Fatalf("entry %d has seq %d", i, e.Seq)
+		}
+		if e.CreatedAt.IsZero() {
+			t.Fatalf("entry %d has no CreatedAt", i)
+		}
+	}
+
+	// entries survive the outbox being closed and opened again, and new ones still go in behind them
+	o.Close()
+	o, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	seq, err := o.Add(Entry{Body: "after reopening"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != uint64(len(want)+1) {
+		t.Fatalf("seq after reopening = %d, want %d", seq, len(want)+1)
+	}
+
+	stats, err := o.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Depth != len(want)+1 || stats.OldestAt == nil || !stats.OldestAt.Equal(entries[0].CreatedAt) {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestOutboxRemove(t *testing.T) {
+	o, _ := openTestOutbox(t)
+
+	if empty, err := o.Empty(); err != nil || !empty {
+		t.Fatalf("new outbox: Empty() = %t, %v", empty, err)
+	}
+	if stats, _ := o.Stats(); stats.Depth != 0 || stats.OldestAt != nil {
+		t.Fatalf("new outbox: stats = %+v", stats)
+	}
+
+	add(t, o, "first", "second", "third")
+	if err := o.Remove(2); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := o.Peek(10)
+	if got := bodies(entries); !reflect.DeepEqual(got, []string{"first", "third"}) {
+		t.Fatalf("got %v after removing the second entry", got)
+	}
+
+	o.Remove(1)
+	o.Remove(3)
+	if empty, err := o.Empty(); err != nil || !empty {
+		t.Fatalf("Empty() = %t, %v after removing every entry", empty, err)
+	}
+}
+
+// wait for the relay to remove every entry
+func waitEmpty(t *testing.T, o *Outbox) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if empty, err := o.Empty(); err == nil && empty {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatal("entries left in the outbox")
+}
+
+var (
+	errDown     = errors.New("rabbitmq is down")
+	errRejected = errors.New("rabbitmq rejected the event")
+)
+
+// a publish func that fails with the errors it is given, in turn, then succeeds. it records what it published
+type fakePublisher struct {
+	mu        sync.Mutex
+	errs      []error
+	attempts  []string
+	published []string
+	done      chan struct{}
+	// how many entries to publish before closing done
+	want int
+}
+
+func (p *fakePublisher) publish(ctx context.Context, e Entry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.attempts = append(p.attempts, e.Body)
+
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+
+	p.published = append(p.published, e.Body)
+	if len(p.published) == p.want {
+		close(p.done)
+	}
+
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	tests := []struct {
+		name string
+		// what each publish fails with, in turn. nil lets it through
+		errs          []error
+		wantAttempts  []string
+		wantPublished []string
+	}{
+		{
+			name:          "publishes every entry in order",
+			wantAttempts:  []string{"a", "b", "c"},
+			wantPublished: []string{"a", "b", "c"},
+		},
+		{
+			name:          "starts again from an entry that failed",
+			errs:          []error{nil, errDown},
+			wantAttempts:  []string{"a", "b", "b", "c"},
+			wantPublished: []string{"a", "b", "c"},
+		},
+		{
+			name:          "drops entries retry gives up on",
+			errs:          []error{nil, errRejected},
+			wantAttempts:  []string{"a", "b", "c"},
+			wantPublished: []string{"a", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, _ := openTestOutbox(t)
+			add(t, o, "a", "b", "c")
+
+			p := &fakePublisher{errs: tt.errs, done: make(chan struct{}), want: len(tt.wantPublished)}
+			retry := func(err error) bool { return !errors.Is(err, errRejected) }
+
+			ctx, cancel := context.WithCancel(context.Background())
+			relayed := make(chan struct{})
+			go func() {
+				defer close(relayed)
+				o.Relay(ctx, p.publish, retry)
+			}()
+
+			select {
+			case <-p.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the relay")
+			}
+			waitEmpty(t, o)
+			cancel()
+			<-relayed
+
+			if !reflect.DeepEqual(p.attempts, tt.wantAttempts) {
+				t.Errorf("attempts = %v, want %v", p.attempts, tt.wantAttempts)
+			}
+			if !reflect.DeepEqual(p.published, tt.wantPublished) {
+				t.Errorf("published = %v, want %v", p.published, tt.wantPublished)
+			}
+		})
+	}
+}
+
+func TestRelayPublishesEntriesAddedLater(t *testing.T) {
+	o, _ := openTestOutbox(t)
+
+	p := &fakePublisher{done: make(chan struct{}), want: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	relayed := make(chan struct{})
+	go func() {
+		defer close(relayed)
+		o.Relay(ctx, p.publish, func(error) bool { return true })
+	}()
+	defer func() {
+		cancel()
+		<-relayed
+	}()
+
+	add(t, o, "a")
+	time.Sleep(10 * time.Millisecond)
+	add(t, o, "b")
+
+	select {
+	case <-p.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the relay didnt notice the entries being added")
+	}
+}
+
+func TestRelayBacksOff(t *testing.T) {
+	tests := []struct {
+		wait, want time.Duration
+	}{
+		{wait: relayWait, want: 2 * time.Second},
+		{wait: 2 * time.Second, want: 4 * time.Second},
+		{wait: 16 * time.Second, want: maxRelayWait},
+		{wait: maxRelayWait, want: maxRelayWait},
+	}
+
+	for _, tt := range tests {
+		if got := nextWait(tt.wait); got != tt.want {
+			t.Errorf("nextWait(%s) = %s, want %s", tt.wait, got, tt.want)
+		}
+	}
+}
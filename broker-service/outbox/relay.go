@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// entries read from disk at a time
+	relayBatch = 32
+	// how long the relay waits after a failed publish, doubling each time it fails again
+	relayWait    = 1 * time.Second
+	maxRelayWait = 30 * time.Second
+)
+
+// Relay publishes the outbox's entries, oldest first, until ctx is done. each entry is removed once publish
+// succeeds. when it fails and retry says trying again may work, the relay backs off and starts again from that
+// entry, so entries are never published out of order. entries that retry gives up on are dropped
+func (o *Outbox) Relay(ctx context.Context, publish func(context.Context, Entry) error, retry func(error) bool) {
+	wait := relayWait
+
+	for {
+		entries, err := o.Peek(relayBatch)
+		if err != nil {
+			log.Println("error reading the outbox:", err)
+		}
+
+		// wait for more entries if there arent any
+		if err == nil && len(entries) == 0 {
+			select {
+			case <-o.added:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		failed := err != nil
+		for _, e := range entries {
+			err := publish(ctx, e)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && retry(err) {
+				log.Printf("error publishing outbox entry %d, trying again in %s: %s\n", e.Seq, wait, err)
+				failed = true
+				break
+			}
+			if err != nil {
+				log.Printf("dropping outbox entry %d (%s): %s\n", e.Seq, e.RoutingKey, err)
+			}
+
+			if err := o.Remove(e.Seq); err != nil {
+				log.Println("error removing entry from the outbox:", err)
+				failed = true
+				break
+			}
+		}
+
+		if !failed {
+			wait = relayWait
+			continue
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		wait = nextWait(wait)
+	}
+}
+
+// how long to wait after the publish that followed waiting for wait failed too
+func nextWait(wait time.Duration) time.Duration {
+	wait *= 2
+	if wait > maxRelayWait {
+		wait = maxRelayWait
+	}
+
+	return wait
+}
@@ -0,0 +1,56 @@
+// package requestid carries the id of a request through the broker, so that it can be passed on to
+// every service the request touches (over http, grpc, net/rpc and rabbitmq) and end up on the log entry it produced
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const (
+	// http header the id is read from and sent back in
+	Header = "X-Request-ID"
+	// grpc metadata key and amqp message header the id is sent in
+	MetadataKey = "x-request-id"
+
+	// ids sent by clients that are longer than this are replaced with one of ours
+	maxLength = 128
+)
+
+type contextKey struct{}
+
+// generate a new random id
+func New() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// check that an id sent to us is safe to pass on and to store
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, c := range id {
+		// printable ascii only, so the id can go in any header
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// store an id in a context
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// get the id back out of a context. returns "" if there isnt one
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,146 @@
+package resilient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// returned instead of making a call while a dependency's breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// the three states a breaker can be in
+type State string
+
+const (
+	// calls go through as normal
+	StateClosed State = "closed"
+	// the dependency is unhealthy, so calls fail fast without being made
+	StateOpen State = "open"
+	// the open timeout has passed, so a single trial call is let through to see if the dependency is back
+	StateHalfOpen State = "half-open"
+)
+
+// settings for a breaker
+type BreakerOptions struct {
+	// how many failures in a row open the breaker
+	FailureThreshold int
+	// how long the breaker stays open before letting a trial call through
+	OpenTimeout time.Duration
+}
+
+// a circuit breaker that opens after a number of consecutive failures
+type Breaker struct {
+	opts BreakerOptions
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// set while the one trial call allowed in the half-open state is still running
+	trial bool
+}
+
+// point-in-time view of a breaker, used to show its state on the broker's /breakers endpoint
+type BreakerSnapshot struct {
+	State               State      `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+
+	return &Breaker{
+		opts:  opts,
+		state: StateClosed,
+	}
+}
+
+// Allow reports whether a call may be made right now. every call that is allowed
+// has to be followed by a call to Success, Failure or Release
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		// stay open until the timeout has passed, then move on to half-open
+		if time.Since(b.openedAt) < b.opts.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.state = StateHalfOpen
+		b.trial = false
+		fallthrough
+	case StateHalfOpen:
+		// only one trial call at a time
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+	}
+
+	return nil
+}
+
+// record a call that worked. this closes the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = StateClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// record a call that failed. this opens the breaker once the threshold is reached,
+// or straight away if the failed call was the half-open trial
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+
+	if b.state == StateHalfOpen || b.failures >= b.opts.FailureThreshold {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// record a call that ended without telling us anything about the dependency, e.g. because the caller
+// cancelled it. the failures are left as they are, but a half-open trial is given up so another call can be tried
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
+
+// current state of the breaker
+func (b *Breaker) Snapshot() BreakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := BreakerSnapshot{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+	}
+
+	// an open breaker whose timeout has run out will let the next call through
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.opts.OpenTimeout {
+		snapshot.State = StateHalfOpen
+	}
+
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		snapshot.OpenedAt = &openedAt
+	}
+
+	return snapshot
+}
@@ -0,0 +1,140 @@
+package resilient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// how long the breakers in the tests stay open
+const testOpenTimeout = 20 * time.Millisecond
+
+// one thing done to a breaker: "allow" and "deny" call Allow and expect it to let the call through or not,
+// "ok" and "fail" record how a call went, "release" records a call that was cancelled, and "wait" lets the open timeout run out
+type breakerStep string
+
+func TestBreaker(t *testing.T) {
+	tests := []struct {
+		name      string
+		steps     []breakerStep
+		wantState State
+		// consecutive failures at the end
+		wantFailures int
+	}{
+		{
+			name:      "starts closed",
+			steps:     []breakerStep{"allow"},
+			wantState: StateClosed,
+		},
+		{
+			name:         "stays closed below the threshold",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow"},
+			wantState:    StateClosed,
+			wantFailures: 2,
+		},
+		{
+			name:         "a success resets the failures",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow", "ok", "allow", "fail", "allow", "fail", "allow"},
+			wantState:    StateClosed,
+			wantFailures: 2,
+		},
+		{
+			name:         "opens at the threshold",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow", "fail", "deny", "deny"},
+			wantState:    StateOpen,
+			wantFailures: 3,
+		},
+		{
+			name:         "half-open once the timeout runs out",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow", "fail", "wait"},
+			wantState:    StateHalfOpen,
+			wantFailures: 3,
+		},
+		{
+			name:         "only one trial call while half-open",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow", "fail", "wait", "allow", "deny", "deny"},
+			wantState:    StateHalfOpen,
+			wantFailures: 3,
+		},
+		{
+			name:      "a trial that works closes it",
+			steps:     []breakerStep{"allow", "fail", "allow", "fail", "allow", "fail", "wait", "allow", "ok", "allow", "allow"},
+			wantState: StateClosed,
+		},
+		{
+			name:         "a trial that fails opens it again straight away",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow", "fail", "wait", "allow", "fail", "deny"},
+			wantState:    StateOpen,
+			wantFailures: 4,
+		},
+		{
+			name:         "a released call leaves the failures as they are",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow", "release", "allow", "release", "allow"},
+			wantState:    StateClosed,
+			wantFailures: 2,
+		},
+		{
+			name:         "a released trial lets another one through",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow", "fail", "wait", "allow", "release", "allow", "deny"},
+			wantState:    StateHalfOpen,
+			wantFailures: 3,
+		},
+		{
+			name:         "opens again for the whole timeout after a failed trial",
+			steps:        []breakerStep{"allow", "fail", "allow", "fail", "allow", "fail", "wait", "allow", "fail", "wait", "allow", "deny"},
+			wantState:    StateHalfOpen,
+			wantFailures: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBreaker(BreakerOptions{FailureThreshold: 3, OpenTimeout: testOpenTimeout})
+
+			for i, step := range tt.steps {
+				switch step {
+				case "allow":
+					if err := b.Allow(); err != nil {
+						t.Fatalf("step %d: Allow() = %v, want nil", i, err)
+					}
+				case "deny":
+					if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step %d: Allow() = %v, want %v", i, err, ErrCircuitOpen)
+					}
+				case "ok":
+					b.Success()
+				case "fail":
+					b.Failure()
+				case "release":
+					b.Release()
+				case "wait":
+					time.Sleep(testOpenTimeout + 10*time.Millisecond)
+				default:
+					t.Fatalf("unknown step %q", step)
+				}
+			}
+
+			snapshot := b.Snapshot()
+			if snapshot.State != tt.wantState {
+				t.Errorf("state = %s, want %s", snapshot.State, tt.wantState)
+			}
+			if snapshot.ConsecutiveFailures != tt.wantFailures {
+				t.Errorf("consecutive failures = %d, want %d", snapshot.ConsecutiveFailures, tt.wantFailures)
+			}
+			if (snapshot.OpenedAt != nil) != (tt.wantState != StateClosed) {
+				t.Errorf("opened at = %v with the breaker %s", snapshot.OpenedAt, snapshot.State)
+			}
+		})
+	}
+}
+
+func TestNewBreakerDefaults(t *testing.T) {
+	b := NewBreaker(BreakerOptions{})
+
+	if b.opts.FailureThreshold != 5 {
+		t.Errorf("failure threshold = %d, want 5", b.opts.FailureThreshold)
+	}
+	if b.opts.OpenTimeout != 30*time.Second {
+		t.Errorf("open timeout = %s, want 30s", b.opts.OpenTimeout)
+	}
+}
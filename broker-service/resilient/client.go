@@ -0,0 +1,168 @@
+// package resilient wraps the broker's calls to other services with timeouts,
+// retries with jittered backoff and a circuit breaker, so that one unhealthy
+// service cannot tie up the broker
+package resilient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// settings for the client of a single downstream service
+type Options struct {
+	// how long a single attempt may take, including reading the response headers
+	Timeout time.Duration
+	// how many times an idempotent call is retried after the first attempt fails
+	MaxRetries int
+	// backoff before the first retry. doubles for every retry after that, up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Breaker     BreakerOptions
+	// transport used to make the requests. defaults to http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+// http client for a single downstream service
+type Client struct {
+	Name    string
+	opts    Options
+	http    *http.Client
+	breaker *Breaker
+}
+
+func NewClient(name string, opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 2 * time.Second
+	}
+
+	return &Client{
+		Name: name,
+		opts: opts,
+		http: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: opts.Transport,
+		},
+		breaker: NewBreaker(opts.Breaker),
+	}
+}
+
+// Do sends a request that must not be repeated, such as sending an email. it is tried exactly once
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	return c.do(req, 0)
+}
+
+// DoIdempotent sends a request that is safe to repeat. network errors and 5xx responses
+// are retried up to MaxRetries times with jittered backoff
+func (c *Client) DoIdempotent(req *http.Request) (*http.Response, error) {
+	return c.do(req, c.opts.MaxRetries)
+}
+
+// state of the client's circuit breaker
+func (c *Client) Breaker() BreakerSnapshot {
+	return c.breaker.Snapshot()
+}
+
+func (c *Client) do(req *http.Request, retries int) (*http.Response, error) {
+	var res *http.Response
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		// the body was used up by the previous attempt, so get a fresh copy of it
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("%s: cannot retry a request whose body cannot be re-read", c.Name)
+			}
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		res, err = c.attempt(req)
+		if !retryable(res, err) || attempt >= retries || errors.Is(err, ErrCircuitOpen) {
+			break
+		}
+
+		// throw away the failed response before trying again
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		err = sleep(req.Context(), c.backoff(attempt))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Name, err)
+	}
+
+	return res, nil
+}
+
+// a single attempt, guarded by the circuit breaker
+func (c *Client) attempt(req *http.Request) (*http.Response, error) {
+	err := c.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.http.Do(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// the caller gave up on the request (a client that went away, or a batch that was cancelled),
+		// which says nothing about whether the service is healthy
+		c.breaker.Release()
+	case err != nil || res.StatusCode >= http.StatusInternalServerError:
+		c.breaker.Failure()
+	default:
+		c.breaker.Success()
+	}
+
+	return res, err
+}
+
+// network errors and server errors are worth another try, anything else is the caller's problem
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return res.StatusCode >= http.StatusInternalServerError
+}
+
+// "full jitter" backoff: a random wait between zero and the exponential backoff for this attempt
+func (c *Client) backoff(attempt int) time.Duration {
+	backoff := c.opts.BaseBackoff << attempt
+	if backoff <= 0 || backoff > c.opts.MaxBackoff {
+		backoff = c.opts.MaxBackoff
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// wait for d, or until the request is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+package resilient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a downstream service that answers every request with status, after wait (or once the request is given up on)
+func startService(t *testing.T, status int, wait time.Duration) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		select {
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+func TestClientCountsFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		wait   time.Duration
+		// how long the caller waits for the response, and whether it cancels the request itself
+		callerTimeout time.Duration
+		cancel        bool
+		wantState     State
+		wantFailures  int
+	}{
+		{name: "success", status: http.StatusOK, wantState: StateClosed},
+		{name: "client error", status: http.StatusBadRequest, wantState: StateClosed},
+		{name: "server error", status: http.StatusInternalServerError, wantState: StateOpen, wantFailures: 1},
+		{name: "the client's own timeout", status: http.StatusOK, wait: time.Second, wantState: StateOpen, wantFailures: 1},
+		{name: "cancelled by the caller", status: http.StatusOK, wait: time.Second, cancel: true, wantState: StateClosed},
+		{name: "the caller's deadline", status: http.StatusOK, wait: time.Second, callerTimeout: 20 * time.Millisecond, wantState: StateClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := startService(t, tt.status, tt.wait)
+			c := NewClient("test", Options{Timeout: 100 * time.Millisecond, Breaker: BreakerOptions{FailureThreshold: 1}})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.callerTimeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.callerTimeout)
+				defer cancel()
+			}
+			if tt.cancel {
+				time.AfterFunc(20*time.Millisecond, cancel)
+			}
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			if res, err := c.Do(req); err == nil {
+				res.Body.Close()
+			}
+
+			snapshot := c.Breaker()
+			if snapshot.State != tt.wantState || snapshot.ConsecutiveFailures != tt.wantFailures {
+				t.Fatalf("breaker is %s with %d failures, want %s with %d", snapshot.State, snapshot.ConsecutiveFailures, tt.wantState, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestClientStopsRetryingOnceTheBreakerOpens(t *testing.T) {
+	server, calls := startService(t, http.StatusInternalServerError, 0)
+	c := NewClient("test", Options{MaxRetries: 3, BaseBackoff: time.Millisecond, Breaker: BreakerOptions{FailureThreshold: 2}})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	_, err := c.DoIdempotent(req)
+
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want %v", err, ErrCircuitOpen)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("the service was called %d times, want 2", got)
+	}
+}
+
+func TestClientRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewClient("test", Options{MaxRetries: 3, BaseBackoff: time.Millisecond})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	res, err := c.DoIdempotent(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Fatalf("got %d after %d calls, want 200 after 3", res.StatusCode, calls.Load())
+	}
+	if snapshot := c.Breaker(); snapshot.State != StateClosed || snapshot.ConsecutiveFailures != 0 {
+		t.Fatalf("breaker = %+v after the call worked", snapshot)
+	}
+}
@@ -0,0 +1,253 @@
+// package validate checks structs against the rules in their `validate` tags, e.g.
+//
+//	type MailPayload struct {
+//		To      string `json:"to" validate:"required,email"`
+//		Subject string `json:"subject" validate:"required,max=255"`
+//	}
+//
+// the rules are:
+//   - required: the field cant be left empty (its zero value)
+//   - email: the field is a plain email address, like "name@example.com"
+//   - min=n, max=n: the length of a string or slice, or the value of a number, is at least or at most n.
+//     strings are measured in characters
+//   - maxbytes=n: a string is at most n bytes long once encoded as utf-8, for fields that something downstream
+//     limits by size rather than by characters (bcrypt only looks at the first 72 bytes of a password)
+//   - oneof=a b c: the field is one of the listed values
+//
+// rules that depend on the service's configuration can be added with Rule.
+// every rule but required lets an empty field through, so optional fields only get checked when they are set.
+// fields are reported by their json name, so that clients can match errors to what they sent
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// one rule a field broke
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// every rule a struct broke. returned by Struct as an error
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Field + " " + fe.Message
+	}
+
+	return "invalid payload: " + strings.Join(messages, "; ")
+}
+
+// Unknown is the error for a field that the struct doesnt have, for decoders that reject them
+func Unknown(field string) FieldError {
+	return FieldError{Field: field, Rule: "unknown", Message: "is not a known field"}
+}
+
+// Struct checks every field of v, which must be a struct or a pointer to one, against the rules in its tags.
+// it returns nil if they all pass, and Errors otherwise. a tag with a rule that doesnt exist panics,
+// since that is a mistake in the code rather than in the data
+func Struct(v any) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs Errors
+	check(value, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func check(value reflect.Value, prefix string, errs *Errors) {
+	for _, f := range fieldsOf(value.Type()) {
+		field := value.Field(f.index)
+		name := prefix + f.name
+
+		for _, r := range f.rules {
+			if r.name != "required" && field.IsZero() {
+				continue
+			}
+
+			if msg, ok := r.check(field); !ok {
+				*errs = append(*errs, FieldError{Field: name, Rule: r.name, Message: msg})
+				// one error per field is enough to fix it
+				break
+			}
+		}
+
+		// check the fields of nested structs too, as "outer.inner"
+		for field.Kind() == reflect.Pointer && !field.IsNil() {
+			field = field.Elem()
+		}
+		if field.Kind() == reflect.Struct {
+			check(field, name+".", errs)
+		}
+	}
+}
+
+// a field of a struct and its rules
+type structField struct {
+	index int
+	name  string
+	rules []rule
+}
+
+type rule struct {
+	name  string
+	check func(v reflect.Value) (string, bool)
+}
+
+// the fields of each struct type, parsed from their tags the first time the type is checked
+var cache sync.Map
+
+// rules added with Rule, by name
+var custom sync.Map
+
+// Rule adds a rule that tags can use by name. check is given the field's value, and returns the message
+// to report and false if the value breaks the rule. like the built in rules, it isnt called for empty fields.
+// rules must be added before a struct using them is first checked, since tags are only parsed once
+func Rule(name string, check func(v any) (string, bool)) {
+	custom.Store(name, check)
+}
+
+func fieldsOf(t reflect.Type) []structField {
+	if fields, ok := cache.Load(t); ok {
+		return fields.([]structField)
+	}
+
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		var rules []rule
+		if tag := sf.Tag.Get("validate"); tag != "" {
+			for _, spec := range strings.Split(tag, ",") {
+				rules = append(rules, parseRule(t, sf, spec))
+			}
+		}
+
+		fields = append(fields, structField{index: i, name: name, rules: rules})
+	}
+
+	cache.Store(t, fields)
+	return fields
+}
+
+func parseRule(t reflect.Type, sf reflect.StructField, spec string) rule {
+	name, arg, _ := strings.Cut(strings.TrimSpace(spec), "=")
+
+	switch name {
+	case "required":
+		return rule{name: name, check: func(v reflect.Value) (string, bool) {
+			return "is required", !v.IsZero()
+		}}
+	case "email":
+		return rule{name: name, check: func(v reflect.Value) (string, bool) {
+			return "must be a valid email address", isEmail(v.String())
+		}}
+	case "min", "max":
+		n, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validate: %s.%s: %s needs a number, got %q", t.Name(), sf.Name, name, arg))
+		}
+		return sizeRule(name, n)
+	case "maxbytes":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validate: %s.%s: %s needs a whole number, got %q", t.Name(), sf.Name, name, arg))
+		}
+		return rule{name: name, check: func(v reflect.Value) (string, bool) {
+			if v.Kind() != reflect.String {
+				return "", true
+			}
+			return fmt.Sprintf("must be at most %d bytes long", n), len(v.String()) <= n
+		}}
+	case "oneof":
+		allowed := strings.Fields(arg)
+		return rule{name: name, check: func(v reflect.Value) (string, bool) {
+			s := fmt.Sprint(v.Interface())
+			for _, a := range allowed {
+				if s == a {
+					return "", true
+				}
+			}
+			return "must be one of " + strings.Join(allowed, ", "), false
+		}}
+	}
+
+	if check, ok := custom.Load(name); ok {
+		check := check.(func(v any) (string, bool))
+		return rule{name: name, check: func(v reflect.Value) (string, bool) {
+			return check(v.Interface())
+		}}
+	}
+
+	panic(fmt.Sprintf("validate: %s.%s: unknown rule %q", t.Name(), sf.Name, name))
+}
+
+func sizeRule(name string, n float64) rule {
+	return rule{name: name, check: func(v reflect.Value) (string, bool) {
+		var size float64
+		unit := ""
+
+		switch v.Kind() {
+		case reflect.String:
+			size = float64(utf8.RuneCountInString(v.String()))
+			unit = " characters long"
+		case reflect.Slice, reflect.Map, reflect.Array:
+			size = float64(v.Len())
+			unit = " items long"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			size = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			size = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			size = v.Float()
+		default:
+			return "", true
+		}
+
+		limit := strconv.FormatFloat(n, 'f', -1, 64)
+		if name == "min" {
+			return "must be at least " + limit + unit, size >= n
+		}
+		return "must be at most " + limit + unit, size <= n
+	}}
+}
+
+// a bare address like "name@example.com". "Name <name@example.com>" isnt accepted,
+// since the services downstream expect just the address
+func isEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && addr.Name == ""
+}
@@ -0,0 +1,145 @@
+package validate
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testPayload struct {
+	Email    string   `json:"email" validate:"required,email"`
+	Name     string   `json:"name" validate:"min=2,max=5"`
+	Age      int      `json:"age" validate:"min=18,max=130"`
+	Score    float64  `json:"score" validate:"max=1.5"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Secret   string   `json:"secret" validate:"max=4,maxbytes=4"`
+	Severity string   `json:"severity" validate:"oneof=INFO ERROR"`
+	Level    int      `json:"level" validate:"oneof=1 2 3"`
+	Even     int      `json:"even" validate:"even"`
+	Home     *address `json:"home"`
+	Work     address
+	Ignored  string `json:"-" validate:"required"`
+	hidden   string `validate:"required"`
+}
+
+func init() {
+	Rule("even", func(v any) (string, bool) {
+		return "must be even", v.(int)%2 == 0
+	})
+}
+
+// a payload that passes every rule, changed by each test to break one
+func validPayload() testPayload {
+	return testPayload{Email: "name@example.com", Work: address{City: "Toronto"}}
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p *testPayload)
+		// the rules broken, as "field rule"
+		want []string
+	}{
+		{name: "valid", change: func(p *testPayload) {}},
+		// one error per field, even though "" isnt an email either
+		{name: "required", change: func(p *testPayload) { p.Email = "" }, want: []string{"email required"}},
+		{name: "email", change: func(p *testPayload) { p.Email = "not an email" }, want: []string{"email email"}},
+		{name: "email with a name", change: func(p *testPayload) { p.Email = "Name <name@example.com>" }, want: []string{"email email"}},
+		{name: "string too short", change: func(p *testPayload) { p.Name = "a" }, want: []string{"name min"}},
+		{name: "string too long", change: func(p *testPayload) { p.Name = "abcdef" }, want: []string{"name max"}},
+		{name: "length counts characters, not bytes", change: func(p *testPayload) { p.Name = "ééééé" }},
+		{name: "bytes", change: func(p *testPayload) { p.Secret = "éé" }},
+		{name: "too many bytes", change: func(p *testPayload) { p.Secret = "ééé" }, want: []string{"secret maxbytes"}},
+		{name: "number too small", change: func(p *testPayload) { p.Age = 17 }, want: []string{"age min"}},
+		{name: "number too big", change: func(p *testPayload) { p.Age = 131 }, want: []string{"age max"}},
+		{name: "float too big", change: func(p *testPayload) { p.Score = 1.6 }, want: []string{"score max"}},
+		{name: "slice too long", change: func(p *testPayload) { p.Tags = []string{"a", "b", "c"} }, want: []string{"tags max"}},
+		{name: "oneof", change: func(p *testPayload) { p.Severity = "DEBUG" }, want: []string{"severity oneof"}},
+		{name: "oneof a number", change: func(p *testPayload) { p.Level = 4 }, want: []string{"level oneof"}},
+		{name: "custom rule", change: func(p *testPayload) { p.Even = 3 }, want: []string{"even even"}},
+		{name: "nested struct", change: func(p *testPayload) { p.Work.City = "" }, want: []string{"Work.city required"}},
+		{name: "nested pointer", change: func(p *testPayload) { p.Home = &address{} }, want: []string{"home.city required"}},
+		{
+			name: "every broken rule is reported",
+			change: func(p *testPayload) {
+				p.Email = "nope"
+				p.Age = 5
+				p.Severity = "DEBUG"
+			},
+			want: []string{"email email", "age min", "severity oneof"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := validPayload()
+			tt.change(&p)
+
+			err := Struct(&p)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected Errors, got %v", err)
+			}
+
+			var got []string
+			for _, fe := range errs {
+				got = append(got, fe.Field+" "+fe.Rule)
+				if fe.Message == "" {
+					t.Errorf("%s %s has no message", fe.Field, fe.Rule)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructIgnoresWhatIsntAStruct(t *testing.T) {
+	var nilPayload *testPayload
+
+	for _, v := range []any{nil, nilPayload, "string", 42} {
+		if err := Struct(v); err != nil {
+			t.Errorf("Struct(%#v) = %v, want nil", v, err)
+		}
+	}
+}
+
+func TestErrorsMessage(t *testing.T) {
+	err := Errors{
+		{Field: "email", Rule: "required", Message: "is required"},
+		Unknown("emial"),
+	}
+
+	want := "invalid payload: email is required; emial is not a known field"
+	if err.Error() != want {
+		t.Fatalf("got %q, want %q", err.Error(), want)
+	}
+}
+
+func TestUnknownRulePanics(t *testing.T) {
+	type broken struct {
+		Name string `validate:"shiny"`
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), `unknown rule "shiny"`) {
+			t.Fatalf("expected a panic about the unknown rule, got %v", r)
+		}
+	}()
+
+	Struct(broken{Name: "x"})
+}
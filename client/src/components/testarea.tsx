@@ -4,10 +4,15 @@ export default function TestArea() {
   const [sent, setSent] = useState<string>("Nothing sent yet...");
   const [received, setReceived] = useState<string>("Nothing received yet...");
   const [outputs, setOutputs] = useState<string[][]>([]);
+  // access token handed out by the authentication service, sent along with every other request
+  const [accessToken, setAccessToken] = useState<string>("");
 
   function fetchData(url: string, payload: object, serviceName: string) {
     const headers = new Headers();
     headers.append("Content-Type", "application/json");
+    if (accessToken !== "") {
+      headers.append("Authorization", `Bearer ${accessToken}`);
+    }
 
     const body = {
       method: "POST",
@@ -20,6 +25,9 @@ export default function TestArea() {
       .then((data) => {
         setSent(JSON.stringify(payload, undefined, 4));
         setReceived(JSON.stringify(data, undefined, 4));
+        if (!data.error && data.data?.access_token) {
+          setAccessToken(data.data.access_token);
+        }
         if (data.error) {
           setOutputs([
             [
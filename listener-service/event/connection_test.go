@@ -0,0 +1,176 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jateen67/amqptest"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// reconnect straight away when the connection is dropped
+func init() {
+	reconnectWait, reconnectMaxWait = 5*time.Millisecond, 20*time.Millisecond
+}
+
+// a stand-in for rabbitmq, and a connection to it
+func startRabbit(t *testing.T) (*amqptest.Server, *Connection) {
+	t.Helper()
+
+	server := amqptest.NewServer()
+	t.Cleanup(server.Close)
+
+	conn, err := amqp.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConnection(server.URL, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return server, c
+}
+
+// wait for cond to hold, failing the test if it doesnt within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectionReconnects(t *testing.T) {
+	server, c := startRabbit(t)
+
+	if !c.Connected() {
+		t.Fatal("not connected after NewConnection")
+	}
+	if err := c.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Stop()
+	eventually(t, "the drop is noticed", func() bool { return !c.Connected() })
+
+	if _, err := c.Channel(); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Channel() err = %v, want %v", err, ErrNotConnected)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() while disconnected = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- c.Wait(context.Background()) }()
+	server.Start()
+
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() didnt return once rabbitmq came back")
+	}
+	if !c.Connected() {
+		t.Fatal("not connected after Wait returned")
+	}
+	if got := server.Exchanges(); !reflect.DeepEqual(got, []string{"logs_topic"}) {
+		t.Fatalf("declared %v after reconnecting, want the logs_topic exchange", got)
+	}
+}
+
+func TestConnectionClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// whether rabbitmq is down, and the connection reconnecting, when it is closed
+		down bool
+	}{
+		{name: "while connected"},
+		{name: "while reconnecting", down: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, c := startRabbit(t)
+			if tt.down {
+				server.Stop()
+				eventually(t, "the drop is noticed", func() bool { return !c.Connected() })
+			}
+
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.Channel(); !errors.Is(err, ErrConnectionClosed) {
+				t.Fatalf("Channel() err = %v, want %v", err, ErrConnectionClosed)
+			}
+			if err := c.Wait(context.Background()); !errors.Is(err, ErrConnectionClosed) {
+				t.Fatalf("Wait() err = %v, want %v", err, ErrConnectionClosed)
+			}
+
+			// it isnt reconnected once rabbitmq is back
+			dials := server.Dials()
+			if tt.down {
+				server.Start()
+			}
+			time.Sleep(50 * time.Millisecond)
+			if got := server.Dials(); got != dials {
+				t.Fatalf("dialled rabbitmq %d more times after Close", got-dials)
+			}
+		})
+	}
+}
+
+func TestListenDeclaresEverythingAgainAfterReconnecting(t *testing.T) {
+	server, c := startRabbit(t)
+	consumer := NewConsumer(c, http.DefaultClient, ConsumerOptions{Queue: "logs"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	listened := make(chan error, 1)
+	go func() { listened <- consumer.Listen(ctx, []string{"log.INFO", "log.ERROR.#"}) }()
+
+	wantBindings := []amqptest.Binding{
+		{Queue: "logs", Exchange: "logs_topic", RoutingKey: "log.INFO"},
+		{Queue: "logs", Exchange: "logs_topic", RoutingKey: "log.ERROR.#"},
+	}
+	declared := func() bool {
+		return reflect.DeepEqual(server.Exchanges(), []string{"logs_topic"}) &&
+			reflect.DeepEqual(server.Queues(), []string{"logs"}) &&
+			reflect.DeepEqual(server.Bindings(), wantBindings) &&
+			reflect.DeepEqual(server.Consumers(), []string{"logs"})
+	}
+	eventually(t, "the queue is bound and consumed from", declared)
+
+	// rabbitmq restarts, forgetting the exchange, the queue and its bindings
+	server.Restart()
+	eventually(t, "they are all declared again and the queue is consumed from again", declared)
+
+	// and stopping the listener cancels its consumer
+	cancel()
+	select {
+	case err := <-listened:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Listen didnt return once its context was done")
+	}
+	eventually(t, "the consumer is cancelled", func() bool { return len(server.Consumers()) == 0 })
+}
@@ -2,63 +2,149 @@ package event
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/jateen67/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // type used for receiving events from the queue
 type Consumer struct {
-	conn      *amqp.Connection
-	queueName string
+	conn *Connection
+	opts ConsumerOptions
+	// client the events are sent to the logger service with
+	client *http.Client
+	// one for every event being handled, so that they can be drained on shutdown
+	inFlight sync.WaitGroup
+	// the events being handled are cut off when this is cancelled (see Drain)
+	handleCtx    context.Context
+	cancelHandle context.CancelFunc
 }
 
 // type used for pushing events to the queue
 type Payload struct {
 	Name string `json:"name"`
 	Data string `json:"data"`
+	// taken from the routing key the event was pushed with, e.g. ERROR for "log.ERROR.auth"
+	Severity string `json:"severity,omitempty"`
 }
 
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
-	// declare consumer
-	consumer := Consumer{
-		conn: conn,
-	}
+// the http header and amqp message header the id of the request that produced an event travels in
+const (
+	requestIDHeader    = "X-Request-ID"
+	requestIDAMQPField = "x-request-id"
+)
 
-	// set up the consumer by opening up a channel and declaring an exchange
-	err := consumer.setup()
-	if err != nil {
-		return Consumer{}, err
+// name of the logger service in the registry
+const loggerService = "logger-service"
+
+// how the consumer takes events off rabbitmq
+type ConsumerOptions struct {
+	// name of the durable queue the events wait in. defaults to "logs"
+	Queue string
+	// most events handed to us that we havent acknowledged yet. defaults to 32
+	Prefetch int
+}
+
+func (o ConsumerOptions) withDefaults() ConsumerOptions {
+	if o.Queue == "" {
+		o.Queue = "logs"
+	}
+	if o.Prefetch <= 0 {
+		o.Prefetch = 32
 	}
 
-	return consumer, nil
+	return o
 }
 
-// helper function for setting up the channel
-func (consumer *Consumer) setup() error {
-	channel, err := consumer.conn.Channel()
-	if err != nil {
-		return err
+// events are logged with client, which should spread them across the logger service's instances.
+// conn declares the exchange the events come from, and reconnects if rabbitmq goes away
+func NewConsumer(conn *Connection, client *http.Client, opts ConsumerOptions) *Consumer {
+	// declare consumer
+	consumer := &Consumer{
+		conn:   conn,
+		opts:   opts.withDefaults(),
+		client: client,
 	}
+	consumer.handleCtx, consumer.cancelHandle = context.WithCancel(context.Background())
 
-	// function defined in event.go used to declare our exchange
-	return declareExchange(channel)
+	return consumer
 }
 
-// listens to the queue for specific topics
-func (consumer *Consumer) Listen(topics []string) error {
+// how long to wait before consuming again after it failed for some other reason than rabbitmq going away
+const consumeRetry = 5 * time.Second
+
+// how long to hold on to an event the logger service couldnt take before handing it back to rabbitmq,
+// so that it isnt sent straight back to us over and over while the logger service is down
+const redeliverWait = 1 * time.Second
+
+// listens to the queue for specific topics until ctx is done. it then stops taking new events and returns once
+// the ones rabbitmq already sent us have been handed out; they can still be running (see Drain).
+// each event is only acknowledged once the logger service has stored it, so one that is being handled when the
+// listener dies is sent again; events are delivered at least once.
+// if rabbitmq goes away, it waits for the connection to come back, then carries on from the same queue
+func (consumer *Consumer) Listen(ctx context.Context, topics []string) error {
+	for {
+		err := consumer.consume(ctx, topics)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		log.Println("stopped consuming, resuming once rabbitmq is back:", err)
+
+		// a dropped connection is waited for; anything else gets a moment before we try again
+		if consumer.conn.Connected() {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(consumeRetry):
+			}
+		}
+
+		if err := consumer.conn.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// bind the queue to the topics and consume from it until ctx is done or the channel closes.
+// bindings for topics that were taken out of LISTENER_TOPICS stay on the queue until it is deleted
+func (consumer *Consumer) consume(ctx context.Context, topics []string) error {
 	// go to our consumer channel and get things from it
 	ch, err := consumer.conn.Channel()
 	if err != nil {
 		return err
 	}
-	defer ch.Close()
 
-	// we have our channel, now we need to get a random queue
-	q, err := declareRandomQueue(ch)
+	// events are acknowledged on the channel they came in on, so it stays open until they have all been handled
+	var handlers sync.WaitGroup
+	defer func() {
+		go func() {
+			handlers.Wait()
+			ch.Close()
+		}()
+	}()
+
+	// dont take more events at once than we are willing to have unacknowledged
+	if err := ch.Qos(consumer.opts.Prefetch, 0, false); err != nil {
+		return err
+	}
+
+	q, err := declareQueue(ch, consumer.opts.Queue)
 	if err != nil {
 		return err
 	}
@@ -68,7 +154,7 @@ func (consumer *Consumer) Listen(topics []string) error {
 	// go through our list of topics
 	for _, s := range topics {
 		// bind our channel to each of these topics
-		ch.QueueBind(
+		err = ch.QueueBind(
 			q.Name,       // name of queue
 			s,            // topic
 			"logs_topic", // name of the exchange
@@ -82,86 +168,206 @@ func (consumer *Consumer) Listen(topics []string) error {
 		}
 	}
 
-	// look for messages
+	// look for messages. the consumer is named so that it can be cancelled when we shut down
+	const consumerTag = "listener"
 	messages, err := ch.Consume(
-		q.Name, // name of queue
-		"",     // name of consumer
-		true,   // auto acknowledge?
-		false,  // exclusive?
-		false,  // internal>
-		false,  // no wait?
-		nil,    // any specific arguments
+		q.Name,      // name of queue
+		consumerTag, // name of consumer
+		false,       // auto acknowledge?
+		false,       // exclusive?
+		false,       // internal>
+		false,       // no wait?
+		nil,         // any specific arguments
 	)
 	if err != nil {
 		return err
 	}
 
-	// i want to do this forever; i want to consume all the things that come from rabbitmq until i exit the app
-	// do this by declaring a new channel
-	forever := make(chan bool)
-	// will run in background
+	// ask rabbitmq to stop sending us events once we are told to stop. the ones it already sent
+	// still get handled and acknowledged before messages is closed
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		for d := range messages {
-			// decode d.Body into this json payload variable
-			var payload Payload
-			_ = json.Unmarshal(d.Body, &payload)
-
-			go handlePayload(payload)
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		if err := ch.Cancel(consumerTag, false); err != nil {
+			log.Println("error cancelling consumer:", err)
 		}
 	}()
 
 	fmt.Printf("waiting for message [exchange, queue] [logs_topic, %s]\n", q.Name)
-	// keep the consumption going forever by making this blocking
-	<-forever
+
+	// consume all the things that come from rabbitmq until we are told to stop
+	for d := range messages {
+		messagesConsumed.WithLabelValues(d.RoutingKey).Inc()
+
+		consumer.inFlight.Add(1)
+		handlers.Add(1)
+		go func(d amqp.Delivery) {
+			defer consumer.inFlight.Done()
+			defer handlers.Done()
+			consumer.handleDelivery(d)
+		}(d)
+	}
+
+	if ctx.Err() == nil {
+		return errors.New("rabbitmq closed the channel")
+	}
 
 	return nil
 }
 
+// Drain waits for the events being handled to finish, once Listen has returned.
+// if ctx is done first, the ones that are left are cut off
+func (consumer *Consumer) Drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		consumer.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		consumer.cancelHandle()
+		<-drained
+		return ctx.Err()
+	}
+}
+
+// the severity in a routing key like "log.ERROR" or "log.ERROR.auth". empty if it doesnt have one
+func severityOf(routingKey string) string {
+	segments := strings.SplitN(routingKey, ".", 3)
+	if len(segments) < 2 || segments[0] != "log" {
+		return ""
+	}
+
+	return segments[1]
+}
+
+// the id of the broker request that pushed a message, taken from its headers (or its correlation id)
+func requestID(d amqp.Delivery) string {
+	if id, ok := d.Headers[requestIDAMQPField].(string); ok && id != "" {
+		return id
+	}
+
+	return d.CorrelationId
+}
+
+// returned by handlePayload for an event that will never be logged however many times it is tried,
+// e.g. because the logger service turned it down as invalid
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// handle a message inside a consumer span that continues the trace of whoever published it, then tell rabbitmq
+// whether it can be forgotten. one that failed for a reason that may pass is handed back to be tried again
+func (consumer *Consumer) handleDelivery(d amqp.Delivery) {
+	ctx := otel.GetTextMapPropagator().Extract(consumer.handleCtx, tracing.HeaderCarrier(d.Headers))
+	ctx, span := tracer.Start(ctx, "logs_topic receive", trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.source.name", "logs_topic"),
+			attribute.String("messaging.rabbitmq.destination.routing_key", d.RoutingKey),
+		))
+	defer span.End()
+
+	// decode d.Body into this json payload variable
+	var payload Payload
+	err := json.Unmarshal(d.Body, &payload)
+	if err != nil {
+		err = &permanentError{fmt.Errorf("event isnt valid json: %w", err)}
+	} else {
+		if severity := severityOf(d.RoutingKey); severity != "" {
+			payload.Severity = severity
+		}
+		err = consumer.handlePayload(ctx, payload, requestID(d))
+	}
+	recordHandled(payload.Name, err)
+
+	var permanent *permanentError
+	switch {
+	case err == nil:
+		err = d.Ack(false)
+	case errors.As(err, &permanent):
+		log.Println("dropping event:", err)
+		err = d.Reject(false)
+	default:
+		log.Println("error logging event, handing it back to rabbitmq:", err)
+		// while shutting down, hand it back straight away so that another listener can take it
+		select {
+		case <-time.After(redeliverWait):
+		case <-consumer.handleCtx.Done():
+		}
+		err = d.Nack(false, true)
+	}
+
+	// the channel closed under us, so rabbitmq sends the event again anyway
+	if err != nil {
+		log.Println("error acknowledging event:", err)
+	}
+}
+
 // take an action based on the name of an event that we get pushed to us from the queue
-func handlePayload(payload Payload) {
+func (consumer *Consumer) handlePayload(ctx context.Context, payload Payload, requestID string) error {
 	// switch on the 'Name' value from the payload variable we received as a call to this function
 	switch payload.Name {
 	case "log", "event":
 		// log whatever we get
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
+		return consumer.logEvent(ctx, payload, requestID)
 	case "auth":
 		// authenticate
+		return nil
 	default:
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
+		return consumer.logEvent(ctx, payload, requestID)
 	}
 }
 
 // logic to log an event to the logger service once we get it from rabbitmq
-func logEvent(entry Payload) error {
+// the request id is passed on so that the log entry can be traced back to the broker request that produced it
+func (consumer *Consumer) logEvent(ctx context.Context, entry Payload, requestID string) error {
 
 	// create json that well send to the logger microservice by encoding the name/data json we receive ('entry')
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 
 	// prepare service to send a post request to the /log endpoint defined in the logger-service routes.go file
 	// we will prepare the recently encoded jsonData with the name/data as a request body
-	request, err := http.NewRequest("POST", "http://logger-service/log", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", "http://"+loggerService+"/log", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
+	if requestID != "" {
+		request.Header.Set(requestIDHeader, requestID)
+	}
 
 	// we will actually send the request now and get the response from the logger service
-	client := &http.Client{}
-	res, err := client.Do(request)
+	res, err := consumer.client.Do(request)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
-	// make sure we get the correct status code from the logger service
+	// make sure we get the correct status code from the logger service. it only sends a 202 once the entry
+	// is stored in mongo, and a 4xx means it never will be
 	if res.StatusCode != http.StatusAccepted {
+		err := fmt.Errorf("logger-service answered with status %d", res.StatusCode)
+		if res.StatusCode >= 400 && res.StatusCode < 500 {
+			return &permanentError{err}
+		}
 		return err
 	}
 
@@ -0,0 +1,107 @@
+package event
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jateen67/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// records what the consumer told rabbitmq about a delivery
+type fakeAcknowledger struct {
+	acked, nacked, rejected bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.nacked = true
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.rejected = true
+	return nil
+}
+
+// sends every request to server, whatever host it was made for
+type toServer struct {
+	server *httptest.Server
+}
+
+func (t toServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(t.server.URL)
+	r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestHandleDeliveryContinuesTheBrokersTrace(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "memory")
+	shutdown, err := tracing.Setup(context.Background(), "listener-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	// stands in for the logger service, keeping the trace context each request came with
+	var got trace.SpanContext
+	logger := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header)))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer logger.Close()
+
+	consumer := NewConsumer(nil, &http.Client{Transport: tracing.Transport(toServer{logger})}, ConsumerOptions{})
+
+	// the broker's publish span, sent along in the message headers the way its emitter does
+	ctx, publish := otel.Tracer("broker").Start(context.Background(), "logs_topic publish", trace.WithSpanKind(trace.SpanKindProducer))
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, tracing.HeaderCarrier(headers))
+	publish.End()
+
+	ack := &fakeAcknowledger{}
+	consumer.handleDelivery(amqp.Delivery{
+		Acknowledger: ack,
+		Headers:      headers,
+		RoutingKey:   "log.INFO",
+		Body:         []byte(`{"name": "event", "data": "hi"}`),
+	})
+
+	if !ack.acked {
+		t.Fatalf("the event wasnt acked: %+v", ack)
+	}
+	if got.TraceID() != publish.SpanContext().TraceID() {
+		t.Fatalf("the logger service got trace %s, want the broker's %s", got.TraceID(), publish.SpanContext().TraceID())
+	}
+
+	// the receive span sits between the broker's publish and the request to the logger service
+	var receive, request trace.SpanContext
+	for _, s := range tracing.Memory.GetSpans() {
+		switch s.SpanKind {
+		case trace.SpanKindConsumer:
+			receive = s.SpanContext
+			if s.Parent.SpanID() != publish.SpanContext().SpanID() {
+				t.Errorf("the receive span's parent is %s, want the publish span %s", s.Parent.SpanID(), publish.SpanContext().SpanID())
+			}
+		case trace.SpanKindClient:
+			request = s.SpanContext
+		}
+	}
+	if !receive.IsValid() || !request.IsValid() {
+		t.Fatal("missing the receive or request span")
+	}
+	if got.SpanID() != request.SpanID() {
+		t.Errorf("the logger service's parent is %s, want the request span %s", got.SpanID(), request.SpanID())
+	}
+}
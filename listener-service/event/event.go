@@ -2,8 +2,12 @@ package event
 
 import (
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
 )
 
+// tracer used for the spans around consuming messages
+var tracer = otel.Tracer("github.com/jateen67/listener/event")
+
 // when we call this function in consumer.go, we want it to return nil
 func declareExchange(ch *amqp.Channel) error {
 	return ch.ExchangeDeclare(
@@ -17,12 +21,14 @@ func declareExchange(ch *amqp.Channel) error {
 	)
 }
 
-func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
+// the queue events wait in until the listener has logged them. it is durable, and not exclusive to one connection,
+// so that events pushed while the listener is down or reconnecting wait for it, and replicas share the work
+func declareQueue(ch *amqp.Channel, name string) (amqp.Queue, error) {
 	return ch.QueueDeclare(
-		"",    // name of queue
-		false, // is the queue durable?
+		name,  // name of queue
+		true,  // is the queue durable?
 		false, // do you get rid of it when you are done with it?
-		true,  // is the queue exclusive?
+		false, // is the queue exclusive?
 		false, // no wait?
 		nil,   // any specific arguments?
 	)
@@ -0,0 +1,45 @@
+// prometheus metrics for the messages the listener takes off the queue
+package event
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "listener_messages_consumed_total",
+		Help: "Number of messages taken off the queue, by routing key.",
+	}, []string{"routing_key"})
+
+	messagesHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "listener_messages_handled_total",
+		Help: "Number of messages handled successfully, by event name.",
+	}, []string{"name"})
+
+	messagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "listener_messages_failed_total",
+		Help: "Number of messages that could not be handled, by event name.",
+	}, []string{"name"})
+)
+
+// event names come from whoever published the message, so anything we dont know about is counted
+// under "other" to keep the number of series bounded
+func eventLabel(name string) string {
+	switch name {
+	case "log", "event", "auth":
+		return name
+	default:
+		return "other"
+	}
+}
+
+// count the outcome of handling a message
+func recordHandled(name string, err error) {
+	if err != nil {
+		messagesFailed.WithLabelValues(eventLabel(name)).Inc()
+		return
+	}
+
+	messagesHandled.WithLabelValues(eventLabel(name)).Inc()
+}
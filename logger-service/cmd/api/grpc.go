@@ -8,7 +8,11 @@ import (
 
 	"github.com/jateen67/log-service/data"
 	"github.com/jateen67/log-service/logs"
+	"github.com/jateen67/tracing"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 )
 
 type LogServer struct {
@@ -21,12 +25,15 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 
 	// write the log
 	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
+		Name:      input.Name,
+		Data:      input.Data,
+		Severity:  input.Severity,
+		RequestID: requestIDFromMetadata(ctx),
 	}
 
 	// log to mongo
-	err := l.Models.LogEntry.Insert(logEntry)
+	err := l.Models.LogEntry.Insert(ctx, logEntry)
+	recordInsert("grpc", err)
 	if err != nil {
 		res := &logs.LogResponse{Result: "failed"}
 		return res, err
@@ -37,21 +44,44 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 	return res, nil
 }
 
-func (app *Config) gRPCListen() {
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRpcPort))
-	if err != nil {
-		log.Fatalf("failed to listen for grpc: %v", err)
+// the id of the broker request, sent in the call's metadata
+func requestIDFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
 	}
 
-	// new grpc server
-	s := grpc.NewServer()
+	values := md.Get("x-request-id")
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// build the grpc server. its health service is returned too, so that it can be marked as not serving while we shut down
+func (app *Config) gRPCServer() (*grpc.Server, *health.Server) {
+	// new grpc server, with a span for every call that continues the caller's trace
+	s := grpc.NewServer(grpc.UnaryInterceptor(tracing.UnaryServerInterceptor()))
 
 	// register the service
 	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models})
 
-	log.Printf("grpc server started on port %s", gRpcPort)
+	// register the standard grpc health service so that clients can check that we are serving
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
 
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to listen for grpc: %v", err)
+	return s, healthServer
+}
+
+// serve grpc on gRpcPort until the server is stopped
+func gRPCListen(s *grpc.Server) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRpcPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen for grpc: %w", err)
 	}
+
+	log.Printf("grpc server started on port %s", gRpcPort)
+
+	return s.Serve(lis)
 }
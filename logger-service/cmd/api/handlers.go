@@ -7,10 +7,14 @@ import (
 )
 
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Severity string `json:"severity"`
 }
 
+// header the broker (and the services it calls) send the id of the original request in
+const requestIDHeader = "X-Request-ID"
+
 // method that will be called when we send a post request to "localhost:80/log" (will be mapped to 8080 through docker)
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	// this is the json that the request will get decoded/fitted into
@@ -21,14 +25,18 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	// create an event we will log
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Name:      requestPayload.Name,
+		Data:      requestPayload.Data,
+		Severity:  requestPayload.Severity,
+		RequestID: r.Header.Get(requestIDHeader),
 	}
 
 	// insert it into the mongo db using the method defined in data/models.go
-	err := app.Models.LogEntry.Insert(event)
+	err := app.Models.LogEntry.Insert(r.Context(), event)
+	recordInsert("http", err)
+	// the entry wasnt stored, but may be if it is sent again (the listener service does)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
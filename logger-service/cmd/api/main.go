@@ -4,14 +4,18 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/jateen67/log-service/data"
+	"github.com/jateen67/tracing"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
 const (
@@ -28,6 +32,17 @@ type Config struct {
 }
 
 func main() {
+	// stop on ctrl+c, or on the SIGTERM docker sends when the container is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// set up tracing before anything else, so that every span is exported
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// connect to mongo
 	mongoClient, err := connectToMongo()
 	if err != nil {
@@ -36,15 +51,17 @@ func main() {
 
 	client = mongoClient
 
-	// create a context in order to disconnect
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	// close connection
+	// close connection once everything that writes to it has finished
 	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			panic(err)
+		// create a context in order to disconnect
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		if err := client.Disconnect(ctx); err != nil {
+			log.Println("error disconnecting from mongo:", err)
+			return
 		}
+		log.Println("disconnected from mongo")
 	}()
 
 	app := Config{
@@ -53,11 +70,9 @@ func main() {
 
 	// register the rpc server to tell the app that we will indeed be accepting rpc requests
 	err = rpc.Register(new(RPCServer))
-	// start our rpc server
-	go app.rpcListen()
-
-	// start our grpc server
-	go app.gRPCListen()
+	if err != nil {
+		log.Panic(err)
+	}
 
 	// start our server
 	log.Printf("starting logger service on port %s\n", port)
@@ -66,36 +81,38 @@ func main() {
 		Handler: app.routes(),
 	}
 
-	err = srv.ListenAndServe()
-	if err != nil {
-		log.Panic()
-	}
-}
+	grpcServer, grpcHealth := app.gRPCServer()
+	rpcServer := &rpcListener{}
 
-// method to start up rpc server
-func (app *Config) rpcListen() error {
-	log.Println("starting rpc server on port ", rpcPort)
-	// listen for a tcp connection on any and all ip addresses (0.0.0.0) and our rpcPort
-	listen, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", rpcPort))
-	if err != nil {
-		return err
-	}
-	defer listen.Close()
+	// each server reports here if it stops on its own, which means something went wrong
+	serveErrs := make(chan error, 3)
+	go func() { serveErrs <- srv.ListenAndServe() }()
+	go func() { serveErrs <- gRPCListen(grpcServer) }()
+	go func() {
+		log.Println("starting rpc server on port ", rpcPort)
+		serveErrs <- rpcServer.serve(fmt.Sprintf("0.0.0.0:%s", rpcPort))
+	}()
 
-	// loop that executes forever to accept connections
-	for {
-		rpcConn, err := listen.Accept()
-		if err != nil {
-			continue
+	select {
+	case <-ctx.Done():
+		log.Println("shutting down...")
+	case err := <-serveErrs:
+		if !serverStopped(err) {
+			log.Println("server stopped unexpectedly, shutting down:", err)
 		}
-		go rpc.ServeConn(rpcConn)
 	}
+
+	// finish the writes we are in the middle of, then the deferred calls above disconnect from mongo
+	shutdown(srv, grpcServer, grpcHealth, rpcServer)
 }
 
 func connectToMongo() (*mongo.Client, error) {
 	// create connection options
 	clientOptions := options.Client().ApplyURI(mongoUrl)
 
+	// record a span for every mongo command
+	clientOptions.SetMonitor(otelmongo.NewMonitor())
+
 	// set the authentication
 	clientOptions.SetAuth(options.Credential{
 		Username: "admin",
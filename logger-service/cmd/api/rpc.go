@@ -2,10 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net"
+	"net/rpc"
+	"sync"
 	"time"
 
 	"github.com/jateen67/log-service/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // any time we want to setup rpc, we need to specify a specific type for it
@@ -15,19 +24,37 @@ type RPCServer struct{}
 type RPCPayload struct {
 	Name string
 	Data string
+	// e.g. INFO, WARNING or ERROR
+	Severity string
+	// id of the broker request that made this call
+	RequestID string
+	// trace context of the caller, since net/rpc has no headers to carry it in
+	TraceContext map[string]string
 }
 
 // now we define methods we want to expose via rpc
 func (r *RPCServer) LogInfo(payload RPCPayload, res *string) error {
+	// continue the caller's trace with a server span of our own
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(payload.TraceContext))
+	ctx, span := tracer.Start(ctx, "RPCServer.LogInfo", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("rpc.system", "net/rpc"), attribute.String("rpc.method", "RPCServer.LogInfo")))
+	defer span.End()
+
 	// write to the logger service, meaning write to mongo
 	collection := client.Database("logs").Collection("logs")
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
+	_, err := collection.InsertOne(ctx, data.LogEntry{
 		Name:      payload.Name,
 		Data:      payload.Data,
+		Severity:  data.SeverityOrDefault(payload.Severity),
+		RequestID: payload.RequestID,
 		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	})
+	recordInsert("rpc", err)
 	if err != nil {
 		log.Println("error writing to mongo:", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
@@ -36,3 +63,103 @@ func (r *RPCServer) LogInfo(payload RPCPayload, res *string) error {
 
 	return nil
 }
+
+// lets clients check that the rpc server is up and answering
+func (r *RPCServer) Ping(payload string, res *string) error {
+	*res = "pong"
+
+	return nil
+}
+
+// rpcListener serves net/rpc connections, keeping track of them so that they can be drained on shutdown
+type rpcListener struct {
+	mu      sync.Mutex
+	lis     net.Listener
+	conns   map[net.Conn]struct{}
+	closing bool
+	// one for every connection being served
+	served sync.WaitGroup
+}
+
+// accept rpc connections on addr until shutdown is called
+func (l *rpcListener) serve(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	if l.closing {
+		l.mu.Unlock()
+		lis.Close()
+		return net.ErrClosed
+	}
+	l.lis = lis
+	l.conns = make(map[net.Conn]struct{})
+	l.mu.Unlock()
+
+	// loop that executes forever to accept connections
+	for {
+		rpcConn, err := lis.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			continue
+		}
+
+		l.mu.Lock()
+		if l.closing {
+			l.mu.Unlock()
+			rpcConn.Close()
+			continue
+		}
+		l.conns[rpcConn] = struct{}{}
+		l.served.Add(1)
+		l.mu.Unlock()
+
+		go func() {
+			defer l.served.Done()
+			// returns once the connection stops sending requests and every call on it has been answered
+			rpc.ServeConn(rpcConn)
+
+			l.mu.Lock()
+			delete(l.conns, rpcConn)
+			l.mu.Unlock()
+		}()
+	}
+}
+
+// shutdown stops accepting connections, then waits for the calls already made on the open ones to be answered.
+// whatever is still running once ctx is done is cut off
+func (l *rpcListener) shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	l.closing = true
+	if l.lis != nil {
+		l.lis.Close()
+	}
+	// stop reading new requests. net/rpc still sends the answers to the calls it already read,
+	// then closes the connection
+	for conn := range l.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	l.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		l.served.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		for conn := range l.conns {
+			conn.Close()
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,81 @@
+// stopping the logger without dropping the writes it is in the middle of
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// how long in-flight writes get to finish once the logger is told to stop, before they are cut off.
+// docker compose waits 30 seconds (see stop_grace_period) before killing us, which leaves time to disconnect from mongo
+const shutdownTimeout = 20 * time.Second
+
+// shutdown stops the logger taking new writes over http, grpc and rpc, then waits for the ones it is running
+// to finish. whatever is still running after shutdownTimeout is cut off
+func shutdown(srv *http.Server, grpcServer *grpc.Server, grpcHealth *health.Server, rpcServer *rpcListener) {
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// tell clients checking our grpc health to go elsewhere
+	grpcHealth.Shutdown()
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("error draining http requests:", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		stopGRPC(ctx, grpcServer)
+	}()
+
+	go func() {
+		defer wg.Done()
+		if err := rpcServer.shutdown(ctx); err != nil {
+			log.Println("error draining rpc calls:", err)
+		}
+	}()
+
+	wg.Wait()
+
+	log.Printf("drained in-flight writes in %s", time.Since(start).Round(time.Millisecond))
+}
+
+// stopGRPC stops the grpc server taking new calls and waits for the calls it is running to finish.
+// if ctx is done first, the calls that are left are cut off
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Println("error draining grpc calls:", ctx.Err())
+		s.Stop()
+		<-stopped
+	}
+}
+
+// a server that stopped because it was shut down isnt an error
+func serverStopped(err error) bool {
+	return err == nil || errors.Is(err, http.ErrServerClosed) || errors.Is(err, grpc.ErrServerStopped) ||
+		errors.Is(err, net.ErrClosed)
+}
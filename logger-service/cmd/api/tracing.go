@@ -0,0 +1,9 @@
+package main
+
+import "go.opentelemetry.io/otel"
+
+// name our spans are reported under (see the tracing module for how they are exported)
+const serviceName = "logger-service"
+
+// tracer used for the spans we start by hand (net/rpc calls)
+var tracer = otel.Tracer("github.com/jateen67/log-service")
@@ -26,19 +26,34 @@ type LogEntry struct {
 	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
 	Name      string    `bson:"name" json:"name"`
 	Data      string    `bson:"data" json:"data"`
+	Severity  string    `bson:"severity" json:"severity"`                         // e.g. INFO, WARNING or ERROR
+	RequestID string    `bson:"request_id,omitempty" json:"request_id,omitempty"` // id of the broker request that produced this entry
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
 }
 
-// insert doc into collection
-func (l *LogEntry) Insert(entry LogEntry) error {
+// entries that dont say how severe they are are stored as this
+const DefaultSeverity = "INFO"
+
+func SeverityOrDefault(severity string) string {
+	if severity == "" {
+		return DefaultSeverity
+	}
+
+	return severity
+}
+
+// insert doc into collection. ctx carries the trace the insert's span belongs to
+func (l *LogEntry) Insert(ctx context.Context, entry LogEntry) error {
 	// declare a var named 'collection' that points to one of the collections in the mongo db
 	// if it doesnt exist then itll be created for us
 	collection := client.Database("logs").Collection("logs")
 
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
+	_, err := collection.InsertOne(ctx, LogEntry{
 		Name:      entry.Name,
 		Data:      entry.Data,
+		Severity:  SeverityOrDefault(entry.Severity),
+		RequestID: entry.RequestID,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	})
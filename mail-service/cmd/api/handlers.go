@@ -28,7 +28,8 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ...then send it
-	err = app.Mailer.SendSMTPMessage(msg)
+	err = app.Mailer.SendSMTPMessage(r.Context(), msg)
+	recordMailSend(err)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
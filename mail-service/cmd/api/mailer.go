@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"html/template"
 	"time"
 
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // type used for setting up an instance of the mailer with the appropriate configuration
@@ -32,8 +36,21 @@ type Message struct {
 	DataMap     map[string]any
 }
 
-// create function to send email
-func (m *Mail) SendSMTPMessage(msg Message) error {
+// create function to send email. ctx carries the trace the send's span belongs to
+func (m *Mail) SendSMTPMessage(ctx context.Context, msg Message) (err error) {
+	_, span := tracer.Start(ctx, "smtp send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("net.peer.name", m.Host),
+			attribute.Int("net.peer.port", m.Port),
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	// make sure theres a valid	'FromAddress' and 'FromName'
 	// if not specified, well use the defaults
 	if msg.From == "" {
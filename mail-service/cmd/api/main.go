@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+
+	"github.com/jateen67/tracing"
 )
 
 const port = "80"
@@ -15,6 +20,17 @@ type Config struct {
 }
 
 func main() {
+	// stop on ctrl+c, or on the SIGTERM docker sends when the container is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// set up tracing before anything else, so that every span is exported
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	app := Config{
 		Mailer: createMail(),
 	}
@@ -26,10 +42,8 @@ func main() {
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
-	if err != nil {
-		log.Panic(err)
-	}
+	// run the server until we are told to stop
+	serve(ctx, srv)
 }
 
 // function that creates a variable of type Mail and sending it back
@@ -0,0 +1,66 @@
+// prometheus metrics for the mail service, served on /metrics
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of http requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "How long http requests took to handle, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	mailSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mail_sends_total",
+		Help: "Number of emails we tried to send over smtp, by result (success or failure).",
+	}, []string{"result"})
+)
+
+// counts every request and times how long it took, labelled with the route that matched
+// (e.g. "/send") rather than the raw path, so the number of series stays bounded
+func (app *Config) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		// a handler that never wrote a status code sent a 200
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// count an email we tried to send
+func recordMailSend(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	mailSends.WithLabelValues(result).Inc()
+}
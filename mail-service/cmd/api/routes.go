@@ -6,11 +6,16 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/jateen67/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
+	// start a span for every request, continuing the caller's trace if it sent one
+	mux.Use(tracing.Middleware(serviceName))
+
 	// specify who is able to connect
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
@@ -24,6 +29,12 @@ func (app *Config) routes() http.Handler {
 	// easily make sure that the service is running by hitting the endpoint to get a response
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	// count and time every request (see metrics.go)
+	mux.Use(app.recordMetrics)
+
+	// prometheus metrics
+	mux.Handle("/metrics", promhttp.Handler())
+
 	// post request to localhost:80/send will run the SendMail method (will be mapped to 8080 through docker)
 	mux.Post("/send", app.SendMail)
 
@@ -0,0 +1,41 @@
+// stopping the mail service without dropping the requests it is in the middle of
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// how long in-flight requests, and the mails they are sending, get to finish once the service is told to stop, before they are cut off.
+// docker compose waits 30 seconds (see stop_grace_period) before killing us, which leaves time for flushing our traces
+const shutdownTimeout = 20 * time.Second
+
+// serve http until ctx is done, then stop taking new requests and wait for the ones being handled to finish
+func serve(ctx context.Context, srv *http.Server) {
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	select {
+	case <-ctx.Done():
+		log.Println("shutting down...")
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Println("server stopped unexpectedly:", err)
+		}
+		return
+	}
+
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("error draining http requests:", err)
+	}
+
+	log.Printf("drained in-flight requests in %s", time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,15 @@
+#!/bin/sh
+# the .proto files live here, and the go code generated from them is written into every service that uses them.
+# needs protoc, protoc-gen-go and protoc-gen-go-grpc on your PATH. run from this directory
+set -e
+
+# logs.proto: the logger serves it, the broker calls it
+for service in logger-service broker-service; do
+    protoc -I logs --go_out=../$service --go-grpc_out=../$service logs/logs.proto
+done
+
+# broker.proto: the broker serves it
+protoc -I broker \
+    --go_out=../broker-service --go_opt=module=github.com/jateen67/broker \
+    --go-grpc_out=../broker-service --go-grpc_opt=module=github.com/jateen67/broker \
+    broker/broker.proto
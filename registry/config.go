@@ -0,0 +1,167 @@
+// package registry maps the name of every service we call to the instances it runs on,
+// and spreads our requests across those instances
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// how the next instance of a service is picked
+type Balancer string
+
+const (
+	// take turns, one instance after the other
+	RoundRobin Balancer = "round-robin"
+	// the instance with the fewest requests still waiting on an answer
+	LeastOutstanding Balancer = "least-outstanding"
+)
+
+type ServiceConfig struct {
+	// hosts the service runs on, without a port (the port depends on whether we talk http, rpc or grpc to it).
+	// every host is looked up in dns and each address it resolves to is used as an instance,
+	// so a service scaled up with docker compose is spread across all of its replicas.
+	// defaults to the name of the service
+	Endpoints []string `json:"endpoints"`
+	// defaults to the registry's balancer
+	Balancer Balancer `json:"balancer"`
+}
+
+type Config struct {
+	Services map[string]ServiceConfig `json:"services"`
+	// balancer for services that dont pick one. defaults to round-robin
+	Balancer Balancer `json:"balancer"`
+	// an instance that fails this many requests in a row is ejected. defaults to 3
+	MaxFailures int `json:"max_failures"`
+	// how long an ejected instance is left out for before it gets another chance. defaults to 30s
+	EjectionTime Duration `json:"ejection_time"`
+	// how often the endpoints are looked up in dns again. defaults to 10s
+	RefreshInterval Duration `json:"refresh_interval"`
+}
+
+// a time.Duration written as a string like "30s" in the config file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (c Config) withDefaults() Config {
+	if c.Services == nil {
+		c.Services = make(map[string]ServiceConfig)
+	}
+	if c.Balancer == "" {
+		c.Balancer = RoundRobin
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = 3
+	}
+	if c.EjectionTime <= 0 {
+		c.EjectionTime = Duration(30 * time.Second)
+	}
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = Duration(10 * time.Second)
+	}
+
+	return c
+}
+
+// LoadConfig reads the registry's config from the json file named by SERVICE_REGISTRY_FILE, if there is one.
+// the env can then change it further:
+//   - SERVICE_BALANCER sets the default balancer
+//   - <SERVICE>_ENDPOINTS sets the comma separated endpoints of a service, e.g.
+//     LOGGER_SERVICE_ENDPOINTS=logger-1,logger-2 for "logger-service"
+//   - <SERVICE>_BALANCER sets the balancer of a service
+//
+// services must be named in services for their env variables to be looked at
+func LoadConfig(services ...string) (Config, error) {
+	var cfg Config
+
+	if file := os.Getenv("SERVICE_REGISTRY_FILE"); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return cfg, err
+		}
+
+		err = json.Unmarshal(b, &cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("reading %s: %w", file, err)
+		}
+	}
+
+	cfg = cfg.withDefaults()
+
+	if balancer := os.Getenv("SERVICE_BALANCER"); balancer != "" {
+		cfg.Balancer = Balancer(balancer)
+	}
+
+	for _, name := range services {
+		svc := cfg.Services[name]
+		prefix := envPrefix(name)
+
+		if endpoints := os.Getenv(prefix + "_ENDPOINTS"); endpoints != "" {
+			svc.Endpoints = nil
+			for _, endpoint := range strings.Split(endpoints, ",") {
+				if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+					svc.Endpoints = append(svc.Endpoints, endpoint)
+				}
+			}
+		}
+		if balancer := os.Getenv(prefix + "_BALANCER"); balancer != "" {
+			svc.Balancer = Balancer(balancer)
+		}
+
+		cfg.Services[name] = svc
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c Config) validate() error {
+	if err := c.Balancer.validate(); err != nil {
+		return err
+	}
+
+	for name, svc := range c.Services {
+		if svc.Balancer == "" {
+			continue
+		}
+		if err := svc.Balancer.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (b Balancer) validate() error {
+	if b != RoundRobin && b != LeastOutstanding {
+		return fmt.Errorf("unknown balancer %q, expected %q or %q", b, RoundRobin, LeastOutstanding)
+	}
+
+	return nil
+}
+
+// "logger-service" becomes "LOGGER_SERVICE"
+func envPrefix(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// write the registry file and point SERVICE_REGISTRY_FILE at it
+func writeRegistryFile(t *testing.T, contents string) {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "registry.json")
+	if err := os.WriteFile(file, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SERVICE_REGISTRY_FILE", file)
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	t.Setenv("SERVICE_REGISTRY_FILE", "")
+	t.Setenv("SERVICE_BALANCER", "")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		Services:        map[string]ServiceConfig{},
+		Balancer:        RoundRobin,
+		MaxFailures:     3,
+		EjectionTime:    Duration(30 * time.Second),
+		RefreshInterval: Duration(10 * time.Second),
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("cfg = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	writeRegistryFile(t, `{
+		"services": {
+			"logger-service": {"endpoints": ["logger-1", "logger-2"], "balancer": "least-outstanding"},
+			"mail-service": {"endpoints": ["mailer"]}
+		},
+		"max_failures": 5,
+		"ejection_time": "1m",
+		"refresh_interval": "2s"
+	}`)
+	t.Setenv("SERVICE_BALANCER", "")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		Services: map[string]ServiceConfig{
+			"logger-service": {Endpoints: []string{"logger-1", "logger-2"}, Balancer: LeastOutstanding},
+			"mail-service":   {Endpoints: []string{"mailer"}},
+		},
+		Balancer:        RoundRobin,
+		MaxFailures:     5,
+		EjectionTime:    Duration(time.Minute),
+		RefreshInterval: Duration(2 * time.Second),
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("cfg = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	writeRegistryFile(t, `{"services": {"logger-service": {"endpoints": ["logger"], "balancer": "round-robin"}}}`)
+	t.Setenv("SERVICE_BALANCER", "least-outstanding")
+	t.Setenv("LOGGER_SERVICE_ENDPOINTS", " logger-1, logger-2,,")
+	t.Setenv("LOGGER_SERVICE_BALANCER", "least-outstanding")
+	t.Setenv("MAIL_SERVICE_ENDPOINTS", "mailer-1,mailer-2")
+	// not one of the services asked for, so it is left alone
+	t.Setenv("AUTHENTICATION_SERVICE_ENDPOINTS", "auth")
+
+	cfg, err := LoadConfig("logger-service", "mail-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Balancer != LeastOutstanding {
+		t.Errorf("balancer = %q, want %q", cfg.Balancer, LeastOutstanding)
+	}
+	want := map[string]ServiceConfig{
+		"logger-service": {Endpoints: []string{"logger-1", "logger-2"}, Balancer: LeastOutstanding},
+		"mail-service":   {Endpoints: []string{"mailer-1", "mailer-2"}},
+	}
+	if !reflect.DeepEqual(cfg.Services, want) {
+		t.Errorf("services = %+v, want %+v", cfg.Services, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// contents of the registry file, if there is one
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "missing file", env: map[string]string{"SERVICE_REGISTRY_FILE": "/does/not/exist.json"}, wantErr: "no such file"},
+		{name: "bad json", file: `{"services": [}`, wantErr: "reading"},
+		{name: "bad duration", file: `{"ejection_time": "soon"}`, wantErr: "reading"},
+		{name: "unknown balancer in the file", file: `{"balancer": "random"}`, wantErr: `unknown balancer "random"`},
+		{name: "unknown service balancer in the file", file: `{"services": {"mail-service": {"balancer": "random"}}}`, wantErr: `mail-service: unknown balancer "random"`},
+		{name: "unknown balancer in the env", env: map[string]string{"SERVICE_BALANCER": "random"}, wantErr: `unknown balancer "random"`},
+		{name: "unknown service balancer in the env", env: map[string]string{"MAIL_SERVICE_BALANCER": "random"}, wantErr: `mail-service: unknown balancer "random"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SERVICE_REGISTRY_FILE", "")
+			t.Setenv("SERVICE_BALANCER", "")
+			if tt.file != "" {
+				writeRegistryFile(t, tt.file)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := LoadConfig("mail-service")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/jateen67/registry
+
+go 1.20
@@ -0,0 +1,310 @@
+// where the services we call are running, and how to spread requests across their instances.
+// it is its own module, shared by every service that calls another one, the same way proto/ is
+package registry
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// holds every service we call, and keeps their instances up to date
+type Registry struct {
+	cfg      Config
+	services map[string]*Service
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New creates a registry holding the named services, plus any others in the config, and looks up
+// their instances straight away. the lookups are then repeated in the background on every refresh interval
+func New(cfg Config, services ...string) *Registry {
+	cfg = cfg.withDefaults()
+
+	r := &Registry{
+		cfg:      cfg,
+		services: make(map[string]*Service),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, name := range services {
+		r.add(name)
+	}
+	for name := range cfg.Services {
+		r.add(name)
+	}
+
+	r.refresh()
+	go r.refreshLoop()
+
+	return r
+}
+
+func (r *Registry) add(name string) {
+	if _, ok := r.services[name]; ok {
+		return
+	}
+
+	svc := r.cfg.Services[name]
+	if len(svc.Endpoints) == 0 {
+		svc.Endpoints = []string{name}
+	}
+	if svc.Balancer == "" {
+		svc.Balancer = r.cfg.Balancer
+	}
+
+	r.services[name] = &Service{
+		Name:         name,
+		endpoints:    svc.Endpoints,
+		balancer:     svc.Balancer,
+		maxFailures:  r.cfg.MaxFailures,
+		ejectionTime: time.Duration(r.cfg.EjectionTime),
+	}
+}
+
+// Service returns the named service, or nil if the registry doesnt hold it
+func (r *Registry) Service(name string) *Service {
+	return r.services[name]
+}
+
+// state of every instance of every service, keyed by the service's name
+func (r *Registry) Snapshot() map[string][]InstanceSnapshot {
+	snapshot := make(map[string][]InstanceSnapshot, len(r.services))
+	for name, svc := range r.services {
+		snapshot[name] = svc.Snapshot()
+	}
+
+	return snapshot
+}
+
+// stop looking up instances in the background
+func (r *Registry) Close() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Registry) refreshLoop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(time.Duration(r.cfg.RefreshInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.refresh()
+		}
+	}
+}
+
+func (r *Registry) refresh() {
+	for _, svc := range r.services {
+		svc.refresh()
+	}
+}
+
+// one service, and the instances it runs on
+type Service struct {
+	Name         string
+	endpoints    []string
+	balancer     Balancer
+	maxFailures  int
+	ejectionTime time.Duration
+
+	mu        sync.Mutex
+	instances []*Instance
+	next      int
+}
+
+// a single instance of a service
+type Instance struct {
+	service *Service
+	// ip address (or host name, if it couldnt be looked up) of the instance
+	host string
+
+	// the fields below are guarded by the service's mutex
+	outstanding  int
+	failures     int
+	ejectedUntil time.Time
+}
+
+// the state of an instance
+type InstanceSnapshot struct {
+	Host         string     `json:"host"`
+	Outstanding  int        `json:"outstanding"`
+	Failures     int        `json:"failures"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+}
+
+// look up every endpoint of the service in dns. instances that are still there keep their state
+func (s *Service) refresh() {
+	var hosts []string
+	for _, endpoint := range s.endpoints {
+		hosts = append(hosts, lookup(endpoint)...)
+	}
+	sort.Strings(hosts)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := make(map[string]*Instance, len(s.instances))
+	for _, instance := range s.instances {
+		existing[instance.host] = instance
+	}
+
+	instances := make([]*Instance, 0, len(hosts))
+	for _, host := range hosts {
+		instance, ok := existing[host]
+		if !ok {
+			instance = &Instance{service: s, host: host}
+		}
+		instances = append(instances, instance)
+	}
+
+	s.instances = instances
+}
+
+// the addresses a host resolves to. a host that cant be looked up right now (e.g. because its
+// container hasnt started yet) is used as it is, and the lookup is tried again on the next refresh
+func lookup(host string) []string {
+	if net.ParseIP(host) != nil {
+		return []string{host}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return []string{host}
+	}
+
+	return addrs
+}
+
+// Pick chooses the instance the next request goes to, using the service's balancer.
+// ejected instances are skipped, unless every instance has been ejected.
+// Done must be called on the instance once the request has finished
+func (s *Service) Pick() *Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instance := s.pick()
+	instance.outstanding++
+
+	return instance
+}
+
+// Addr chooses an instance the same way Pick does, for a connection that will be kept open
+// rather than a single request, and returns its address with the given port
+func (s *Service) Addr(port string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pick().Addr(port)
+}
+
+// must be called with the mutex held
+func (s *Service) pick() *Instance {
+	if len(s.instances) == 0 {
+		// only possible before the first refresh
+		s.instances = []*Instance{{service: s, host: s.endpoints[0]}}
+	}
+
+	now := time.Now()
+	candidates := make([]*Instance, 0, len(s.instances))
+	for _, instance := range s.instances {
+		if !now.Before(instance.ejectedUntil) {
+			candidates = append(candidates, instance)
+		}
+	}
+
+	// better to try an instance that might have recovered than to not try at all
+	if len(candidates) == 0 {
+		candidates = s.instances
+	}
+
+	start := s.next % len(candidates)
+	s.next++
+
+	if s.balancer == LeastOutstanding {
+		best := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			instance := candidates[(start+i)%len(candidates)]
+			if instance.outstanding < best.outstanding {
+				best = instance
+			}
+		}
+		return best
+	}
+
+	return candidates[start]
+}
+
+// the instances of the service and how they are doing
+func (s *Service) Snapshot() []InstanceSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	snapshot := make([]InstanceSnapshot, 0, len(s.instances))
+	for _, instance := range s.instances {
+		is := InstanceSnapshot{
+			Host:        instance.host,
+			Outstanding: instance.outstanding,
+			Failures:    instance.failures,
+		}
+		if now.Before(instance.ejectedUntil) {
+			until := instance.ejectedUntil
+			is.EjectedUntil = &until
+		}
+		snapshot = append(snapshot, is)
+	}
+
+	return snapshot
+}
+
+// address of the instance with the given port, or just its host if port is empty
+func (i *Instance) Addr(port string) string {
+	if port == "" {
+		return i.host
+	}
+
+	return net.JoinHostPort(i.host, port)
+}
+
+// Done reports how a request picked with Pick went. an instance that fails too many
+// requests in a row is ejected for a while
+func (i *Instance) Done(err error) {
+	s := i.service
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i.outstanding--
+
+	if err == nil {
+		i.failures = 0
+		return
+	}
+
+	i.failures++
+	if i.failures >= s.maxFailures {
+		i.failures = 0
+		i.ejectedUntil = time.Now().Add(s.ejectionTime)
+	}
+}
+
+// Release gives back a request picked with Pick without saying how it went, e.g. because the caller
+// cancelled it before the instance answered
+func (i *Instance) Release() {
+	s := i.service
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i.outstanding--
+}
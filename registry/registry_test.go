@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// a registry holding one service, "svc", running on the given addresses (which arent looked up in dns)
+func newTestService(t *testing.T, cfg Config, hosts ...string) *Service {
+	t.Helper()
+
+	cfg.Services = map[string]ServiceConfig{"svc": {Endpoints: hosts}}
+	r := New(cfg)
+	t.Cleanup(r.Close)
+
+	return r.Service("svc")
+}
+
+// pick an instance for each request in turn, finishing each one before the next is picked
+func pickHosts(s *Service, n int) []string {
+	var hosts []string
+	for i := 0; i < n; i++ {
+		instance := s.Pick()
+		hosts = append(hosts, instance.Addr(""))
+		instance.Done(nil)
+	}
+
+	return hosts
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	s := newTestService(t, Config{}, "10.0.0.2", "10.0.0.1", "10.0.0.3")
+
+	got := pickHosts(s, 6)
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("picked %v, want %v", got, want)
+	}
+}
+
+func TestPickLeastOutstanding(t *testing.T) {
+	s := newTestService(t, Config{Balancer: LeastOutstanding}, "10.0.0.1", "10.0.0.2", "10.0.0.3")
+
+	// three slow requests, one on each instance
+	slow := []*Instance{s.Pick(), s.Pick(), s.Pick()}
+	seen := make(map[string]bool)
+	for _, instance := range slow {
+		seen[instance.Addr("")] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("three requests went to %v, want one on each instance", seen)
+	}
+
+	// once one of them is answered, its instance gets the next request, whoevers turn it would have been
+	slow[1].Done(nil)
+	for i := 0; i < 3; i++ {
+		next := s.Pick()
+		if next != slow[1] {
+			t.Fatalf("picked %s, want the idle %s", next.Addr(""), slow[1].Addr(""))
+		}
+		next.Release()
+	}
+
+	// round robin doesnt care how busy they are
+	s.balancer = RoundRobin
+	got := pickHosts(s, 3)
+	sort.Strings(got)
+	if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("round robin picked %v, want every instance once", got)
+	}
+}
+
+func TestEjectAndReadmit(t *testing.T) {
+	s := newTestService(t, Config{MaxFailures: 2, EjectionTime: Duration(50 * time.Millisecond)}, "10.0.0.1", "10.0.0.2")
+	failed := errors.New("connection refused")
+
+	// finish the next request that goes to 10.0.0.1 with err, and the ones in between with success
+	doneOnFirst := func(err error) {
+		for instance := s.Pick(); ; instance = s.Pick() {
+			if instance.Addr("") == "10.0.0.1" {
+				instance.Done(err)
+				return
+			}
+			instance.Done(nil)
+		}
+	}
+
+	// a success in between starts the count again
+	for _, err := range []error{failed, nil, failed} {
+		doneOnFirst(err)
+	}
+	if snapshot := s.Snapshot(); snapshot[0].Failures != 1 || snapshot[0].EjectedUntil != nil {
+		t.Fatalf("snapshot = %+v, want one failure in a row and not ejected", snapshot[0])
+	}
+
+	// the second failure in a row ejects it
+	doneOnFirst(failed)
+
+	snapshot := s.Snapshot()
+	if snapshot[0].EjectedUntil == nil || snapshot[1].EjectedUntil != nil {
+		t.Fatalf("snapshot = %+v, want just 10.0.0.1 ejected", snapshot)
+	}
+	for _, host := range pickHosts(s, 4) {
+		if host != "10.0.0.2" {
+			t.Fatalf("picked the ejected %s", host)
+		}
+	}
+
+	// and once its time is up, it is let back in
+	time.Sleep(60 * time.Millisecond)
+	if snapshot := s.Snapshot(); snapshot[0].EjectedUntil != nil || snapshot[0].Failures != 0 {
+		t.Fatalf("snapshot = %+v once its ejection was over", snapshot[0])
+	}
+	got := pickHosts(s, 2)
+	sort.Strings(got)
+	if want := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("picked %v once 10.0.0.1 was readmitted, want %v", got, want)
+	}
+}
+
+func TestPickWithEveryInstanceEjected(t *testing.T) {
+	s := newTestService(t, Config{MaxFailures: 1, EjectionTime: Duration(time.Minute)}, "10.0.0.1", "10.0.0.2")
+
+	for i := 0; i < 2; i++ {
+		s.Pick().Done(errors.New("connection refused"))
+	}
+	for _, instance := range s.Snapshot() {
+		if instance.EjectedUntil == nil {
+			t.Fatalf("%s wasnt ejected", instance.Host)
+		}
+	}
+
+	// better to try one of them than none
+	if got := pickHosts(s, 2); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("picked %v", got)
+	}
+}
+
+func TestReleaseDoesntCountAsAFailure(t *testing.T) {
+	s := newTestService(t, Config{MaxFailures: 1}, "10.0.0.1")
+
+	instance := s.Pick()
+	instance.Release()
+
+	if snapshot := s.Snapshot(); snapshot[0].Outstanding != 0 || snapshot[0].Failures != 0 || snapshot[0].EjectedUntil != nil {
+		t.Fatalf("snapshot = %+v after a release", snapshot[0])
+	}
+}
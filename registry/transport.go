@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Transport sends each request to an instance of the service named in the host of its url, e.g.
+// "http://logger-service/log" goes to one of the logger service's instances. requests to hosts that
+// arent in the registry are sent as they are. network errors and 5xx responses count as failures of the instance,
+// but requests the caller cancelled or timed out dont count either way
+type Transport struct {
+	Registry *Registry
+	// transport the request is sent with once its host has been picked. defaults to http.DefaultTransport
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	svc := t.Registry.Service(req.URL.Hostname())
+	if svc == nil {
+		return base.RoundTrip(req)
+	}
+
+	instance := svc.Pick()
+
+	out := req.Clone(req.Context())
+	out.URL.Host = instance.Addr(req.URL.Port())
+	// keep sending the service's name in the Host header
+	out.Host = req.URL.Host
+
+	res, err := base.RoundTrip(out)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// the caller gave up on the request, which says nothing about the instance
+		instance.Release()
+	case err != nil:
+		instance.Done(err)
+	case res.StatusCode >= http.StatusInternalServerError:
+		instance.Done(fmt.Errorf("%w: %s", errServerError, res.Status))
+	default:
+		instance.Done(nil)
+	}
+
+	return res, err
+}
+
+var errServerError = errors.New("server error")
@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransportCountsFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		// what the instance answers with
+		status int
+		err    error
+		// whether the caller has given up on the request by the time it answers
+		cancel       bool
+		wantFailures int
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "client error", status: http.StatusNotFound},
+		{name: "server error", status: http.StatusBadGateway, wantFailures: 1},
+		{name: "network error", err: errors.New("connection refused"), wantFailures: 1},
+		{name: "cancelled by the caller", err: context.Canceled, cancel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(Config{Services: map[string]ServiceConfig{"svc": {Endpoints: []string{"127.0.0.1"}}}})
+			defer r.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			transport := &Transport{
+				Registry: r,
+				Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					if req.URL.Host != "127.0.0.1:8080" || req.Host != "svc:8080" {
+						t.Errorf("sent to %s with host %s", req.URL.Host, req.Host)
+					}
+					if tt.cancel {
+						cancel()
+					}
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return &http.Response{StatusCode: tt.status, Body: http.NoBody}, nil
+				}),
+			}
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://svc:8080/", nil)
+			res, err := transport.RoundTrip(req)
+			if err == nil {
+				res.Body.Close()
+			}
+
+			snapshot := r.Service("svc").Snapshot()
+			if len(snapshot) != 1 {
+				t.Fatalf("got %d instances, want 1", len(snapshot))
+			}
+			if snapshot[0].Outstanding != 0 {
+				t.Errorf("outstanding = %d, want 0", snapshot[0].Outstanding)
+			}
+			if snapshot[0].Failures != tt.wantFailures {
+				t.Errorf("failures = %d, want %d", snapshot[0].Failures, tt.wantFailures)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package tracing
+
+// HeaderCarrier lets trace context be written to and read from the headers of an amqp message,
+// e.g. tracing.HeaderCarrier(msg.Headers). it has the same underlying type as amqp.Table
+type HeaderCarrier map[string]any
+
+func (c HeaderCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c HeaderCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
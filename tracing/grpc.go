@@ -0,0 +1,16 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+)
+
+// UnaryClientInterceptor starts a client span for every unary call and passes the trace context on in its metadata
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return otelgrpc.UnaryClientInterceptor()
+}
+
+// UnaryServerInterceptor starts a server span for every unary call, continuing the trace of the caller if it sent one
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return otelgrpc.UnaryServerInterceptor()
+}
@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts a server span for every request, continuing the trace of the caller if it sent one.
+// the span is named after the chi route that matched, e.g. "POST /handle"
+func Middleware(serviceName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				trace.SpanFromContext(r.Context()).SetName(r.Method + " " + rctx.RoutePattern())
+			}
+		})
+
+		return otelhttp.NewHandler(named, serviceName)
+	}
+}
+
+// Transport is an http transport that starts a client span for every outgoing request and passes the
+// trace context on in its headers
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
@@ -0,0 +1,64 @@
+// package tracing sets up opentelemetry the same way in every service: the exporter picked with
+// OTEL_TRACES_EXPORTER, the propagator that passes trace context on, and the spans around http, grpc and amqp
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// holds every finished span when OTEL_TRACES_EXPORTER=memory, so tests can look at them
+var Memory *tracetest.InMemoryExporter
+
+// set up the global tracer provider and the propagator that passes trace context on to other services.
+// the exporter is picked with the OTEL_TRACES_EXPORTER env variable:
+//   - "otlp" sends spans to the collector at OTEL_EXPORTER_OTLP_ENDPOINT
+//   - "stdout" prints them
+//   - "memory" keeps them in Memory, for tests
+//   - "none" (the default) records nothing, but trace context is still passed on
+//
+// the returned function flushes any spans that havent been exported yet
+func Setup(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	}
+
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "otlp":
+		exp, err := otlptracegrpc.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "stdout":
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "memory":
+		// a syncer, so that a span can be looked at as soon as it ends
+		Memory = tracetest.NewInMemoryExporter()
+		opts = append(opts, sdktrace.WithSyncer(Memory))
+	case "none", "":
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q, expected otlp, stdout, memory or none", exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,210 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// set up tracing with the in-memory exporter, the way a service's tests would
+func setupMemory(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "memory")
+	shutdown, err := Setup(context.Background(), "test-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { shutdown(context.Background()) })
+}
+
+// wait for n spans to end. a server span can end after its caller already has the response
+func waitSpans(t *testing.T, n int) tracetest.SpanStubs {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if spans := Memory.GetSpans(); len(spans) >= n {
+			return spans
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("got %d spans, want %d", len(Memory.GetSpans()), n)
+	return nil
+}
+
+// the span of the given kind, failing the test if there isnt exactly one
+func spanOfKind(t *testing.T, spans tracetest.SpanStubs, kind trace.SpanKind) tracetest.SpanStub {
+	t.Helper()
+
+	var found []tracetest.SpanStub
+	for _, s := range spans {
+		if s.SpanKind == kind {
+			found = append(found, s)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("got %d %s spans, want 1", len(found), kind)
+	}
+
+	return found[0]
+}
+
+// check that child continues the trace of parent, as its direct child
+func assertChild(t *testing.T, child tracetest.SpanStub, parent trace.SpanContext) {
+	t.Helper()
+
+	if child.SpanContext.TraceID() != parent.TraceID() {
+		t.Errorf("%q is in trace %s, want %s", child.Name, child.SpanContext.TraceID(), parent.TraceID())
+	}
+	if child.Parent.SpanID() != parent.SpanID() {
+		t.Errorf("%q has parent %s, want %s", child.Name, child.Parent.SpanID(), parent.SpanID())
+	}
+}
+
+func TestSetup(t *testing.T) {
+	for _, exporter := range []string{"", "none", "stdout", "memory"} {
+		t.Setenv("OTEL_TRACES_EXPORTER", exporter)
+		shutdown, err := Setup(context.Background(), "test-service")
+		if err != nil {
+			t.Fatalf("%q: %v", exporter, err)
+		}
+		shutdown(context.Background())
+	}
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "jaeger")
+	if _, err := Setup(context.Background(), "test-service"); err == nil {
+		t.Fatal("expected an error for an unknown exporter")
+	}
+}
+
+func TestMemoryKeepsSpans(t *testing.T) {
+	setupMemory(t)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+
+	spans := waitSpans(t, 1)
+	if spans[0].Name != "work" {
+		t.Fatalf("got span %q, want %q", spans[0].Name, "work")
+	}
+	if got := spans[0].Resource.Attributes(); len(got) == 0 || got[0].Value.AsString() != "test-service" {
+		t.Errorf("resource = %v, want the service name", got)
+	}
+}
+
+func TestHTTPPropagation(t *testing.T) {
+	setupMemory(t)
+
+	mux := chi.NewRouter()
+	mux.Use(Middleware("next-service"))
+	mux.Get("/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "root")
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/things/7", nil)
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+	root.End()
+
+	spans := waitSpans(t, 3)
+	clientSpan := spanOfKind(t, spans, trace.SpanKindClient)
+	serverSpan := spanOfKind(t, spans, trace.SpanKindServer)
+
+	assertChild(t, clientSpan, root.SpanContext())
+	assertChild(t, serverSpan, clientSpan.SpanContext)
+	if serverSpan.Name != "GET /things/{id}" {
+		t.Errorf("server span is named %q, want it named after the route", serverSpan.Name)
+	}
+}
+
+func TestGRPCPropagation(t *testing.T) {
+	setupMemory(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor()))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithUnaryInterceptor(UnaryClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "root")
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+
+	spans := waitSpans(t, 3)
+	clientSpan := spanOfKind(t, spans, trace.SpanKindClient)
+	serverSpan := spanOfKind(t, spans, trace.SpanKindServer)
+
+	assertChild(t, clientSpan, root.SpanContext())
+	assertChild(t, serverSpan, clientSpan.SpanContext)
+}
+
+func TestAMQPPropagation(t *testing.T) {
+	setupMemory(t)
+
+	// the publisher writes its span into the message headers
+	ctx, publish := otel.Tracer("test").Start(context.Background(), "publish", trace.WithSpanKind(trace.SpanKindProducer))
+	headers := map[string]any{"x-other": 1}
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier(headers))
+	publish.End()
+
+	if _, ok := headers["traceparent"].(string); !ok {
+		t.Fatalf("no traceparent in the headers: %v", headers)
+	}
+
+	// and the consumer, with none of the publisher's context, continues it from them
+	ctx = otel.GetTextMapPropagator().Extract(context.Background(), HeaderCarrier(headers))
+	_, receive := otel.Tracer("test").Start(ctx, "receive", trace.WithSpanKind(trace.SpanKindConsumer))
+	receive.End()
+
+	spans := waitSpans(t, 2)
+	assertChild(t, spanOfKind(t, spans, trace.SpanKindConsumer), publish.SpanContext())
+}
+
+func TestHeaderCarrierIgnoresHeadersThatArentStrings(t *testing.T) {
+	c := HeaderCarrier{"traceparent": 42, "name": "value"}
+
+	if got := c.Get("traceparent"); got != "" {
+		t.Errorf("Get() = %q for a header that isnt a string", got)
+	}
+	if got := c.Get("name"); got != "value" {
+		t.Errorf("Get() = %q, want %q", got, "value")
+	}
+	if got := len(c.Keys()); got != 2 {
+		t.Errorf("got %d keys, want 2", got)
+	}
+}